@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"net/url"
+	"strings"
+)
+
+// KINTONE_TO_S3_BUCKETNAME may be an access point ARN,
+// arn:aws:s3:ap-northeast-1:123456789012:accesspoint/exports, or a
+// Multi-Region Access Point's, which has no region:
+// arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap.
+// an access point alias is used like a bucket name and needs nothing here.
+func accessPointARN() (arn.ARN, bool) {
+	if !arn.IsARN(config.bucketName) {
+		return arn.ARN{}, false
+	}
+	a, err := arn.Parse(config.bucketName)
+	if err != nil || a.Service != "s3" || !strings.HasPrefix(a.Resource, "accesspoint/") {
+		return arn.ARN{}, false
+	}
+	return a, true
+}
+
+func validateAccessPointOptions() error {
+	if !arn.IsARN(config.bucketName) {
+		return nil
+	}
+	a, ok := accessPointARN()
+	if !ok {
+		return fmt.Errorf(T("not an S3 access point ARN: %s"), config.bucketName)
+	}
+	switch {
+	case config.s3Endpoint != "", config.s3ForcePathStyle:
+		return errors.New(T("an access point cannot be used with -s3-endpoint or -s3-force-path-style"))
+	case config.s3Accelerate:
+		return errors.New(T("an access point cannot be used with -s3-accelerate"))
+	case config.createBucket:
+		return errors.New(T("-create-bucket cannot create an access point"))
+	case a.Region == "" && config.presign > 0:
+		// presigning a Multi-Region Access Point needs SigV4A
+		return errors.New(T("-presign is not supported with a Multi-Region Access Point"))
+	}
+	return nil
+}
+
+// the CopySource of an object in the bucket, which for an access point is
+// <access point ARN>/object/<key>
+func copySource(key string) string {
+	if _, ok := accessPointARN(); ok {
+		return url.PathEscape(config.bucketName + "/object/" + key)
+	}
+	return url.PathEscape(config.bucketName + "/" + key)
+}
+
+// the ARN of the bucket or access point written to
+func bucketARN() string {
+	if _, ok := accessPointARN(); ok {
+		return config.bucketName
+	}
+	return "arn:aws:s3:::" + config.bucketName
+}
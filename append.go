@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+)
+
+const PARTITION_MANIFEST = "_manifest.json"
+
+// tries of the conditional manifest update against concurrent -append runs
+const PARTITION_MANIFEST_TRIES = 10
+
+// the part files of one date partition written by -append runs. the part
+// numbers are taken from here, so runs never overwrite each other's parts.
+type partitionManifest struct {
+	Partition string `json:"partition"`
+	// of the part files; the manifest itself is UTF-8 like any JSON
+	Encoding string `json:"encoding"`
+	// the -record-hash column, to tell changed rows by
+	HashColumn string          `json:"hash_column,omitempty"`
+	Parts      []partitionPart `json:"parts"`
+
+	// of the manifest read, empty for a new one
+	etag string
+}
+
+type partitionPart struct {
+	Key       string    `json:"key"`
+	RunId     string    `json:"run_id"`
+	Records   uint64    `json:"records"`
+	Bytes     int64     `json:"bytes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func validateAppendOptions() error {
+	if chunked() {
+		return errors.New(T("-append cannot be combined with -chunk-records or -chunk-time"))
+	}
+	return nil
+}
+
+// e.g. golang-kintone-to-s3/dt=2024-05-01
+func partitionPrefix() string {
+	base := strings.TrimSuffix(config.key, path.Ext(config.key))
+	return base + "/dt=" + templateTime.Format("2006-01-02")
+}
+
+func loadPartitionManifest(svc *s3.S3) (*partitionManifest, error) {
+	prefix := partitionPrefix()
+	out, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(config.bucketName),
+		Key:    aws.String(prefix + "/" + PARTITION_MANIFEST),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return &partitionManifest{Partition: prefix, Encoding: config.encoding, Parts: make([]partitionPart, 0)}, nil
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	data, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		return nil, err
+	}
+	var m partitionManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %v", PARTITION_MANIFEST, err)
+	}
+	m.etag = aws.StringValue(out.ETag)
+	return &m, nil
+}
+
+func objectExists(svc *s3.S3, key string) (bool, error) {
+	_, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(config.bucketName),
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		return true, nil
+	}
+	if aerr, ok := err.(awserr.RequestFailure); ok && aerr.StatusCode() == 404 {
+		return false, nil
+	}
+	return false, err
+}
+
+// the next free part key of the partition. a part left by a run which
+// failed to update the manifest is skipped, not overwritten.
+func nextPartKey(svc *s3.S3, m *partitionManifest) (string, error) {
+	for n := len(m.Parts); ; n++ {
+		key := fmt.Sprintf("%s/part-%05d-%s%s", m.Partition, n, attemptId(), path.Ext(config.key))
+		exists, err := objectExists(svc, key)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return key, nil
+		}
+	}
+}
+
+// add the part to the manifest, written only if it is still the one read.
+// when another run updated it in between, the part is added to theirs.
+func savePartitionManifest(svc *s3.S3, m *partitionManifest, key string) error {
+	part := partitionPart{
+		Key:       key,
+		RunId:     run.Id,
+		Records:   run.Records,
+		Bytes:     run.Bytes,
+		CreatedAt: time.Now(),
+	}
+	for try := 1; ; try++ {
+		if config.recordHash {
+			m.HashColumn = RECORD_HASH_COLUMN
+		}
+		m.Parts = append(m.Parts, part)
+
+		data, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return err
+		}
+		manifestKey := m.Partition + "/" + PARTITION_MANIFEST
+		input := newPutObjectInput(manifestKey, bytes.NewReader(data))
+		input.ContentType = aws.String("application/json")
+		if m.etag == "" {
+			input.IfNoneMatch = aws.String("*")
+		} else {
+			input.IfMatch = aws.String(m.etag)
+		}
+		_, err = svc.PutObject(input)
+		// 409 is a conditional write racing another one
+		if aerr, ok := err.(awserr.RequestFailure); ok && (aerr.StatusCode() == 412 || aerr.StatusCode() == 409) && try < PARTITION_MANIFEST_TRIES {
+			if m, err = loadPartitionManifest(svc); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if exportSigner != nil {
+			return putSignature(svc, manifestKey, data)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"github.com/apache/arrow/go/v14/arrow"
+	"github.com/apache/arrow/go/v14/arrow/array"
+	"github.com/apache/arrow/go/v14/arrow/ipc"
+	"github.com/apache/arrow/go/v14/arrow/memory"
+	"github.com/kintone/go-kintone"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// the stream goes to standard output in one run, with the columns of one
+// CSV; what writes objects to S3 does not apply
+func validateArrowOptions() error {
+	if chunked() || config.appendMode || config.splitBy != "" || partitionColumns != nil || config.pinRevisions || config.manifest || config.firehoseStream != "" || localOutput() {
+		return errors.New(T("-o arrow cannot be combined with -split-by, -partition-by, -chunk-records, -chunk-time, -append, -pin-revisions, -manifest, -firehose-stream or -output"))
+	}
+	return nil
+}
+
+// ids are typed, everything else is a string as in the CSV. a number keeps
+// all its digits, which a float would round.
+func arrowType(f *Column) arrow.DataType {
+	switch f.Type {
+	case kintone.FT_ID, kintone.FT_REVISION, kintone.FT_SUBTABLE:
+		return arrow.PrimitiveTypes.Int64
+	}
+	return arrow.BinaryTypes.String
+}
+
+func arrowSchema(columns Columns) *arrow.Schema {
+	fields := make([]arrow.Field, 0, len(columns))
+	for _, f := range columns {
+		fields = append(fields, arrow.Field{Name: f.Code, Type: arrowType(f), Nullable: true})
+	}
+	return arrow.NewSchema(fields, nil)
+}
+
+func appendArrowValue(b array.Builder, value string, ok bool) {
+	switch b := b.(type) {
+	case *array.Int64Builder:
+		if n, err := strconv.ParseInt(value, 10, 64); ok && err == nil {
+			b.Append(n)
+		} else {
+			b.AppendNull()
+		}
+	case *array.StringBuilder:
+		if ok {
+			b.Append(value)
+		} else {
+			b.AppendNull()
+		}
+	}
+}
+
+// write the records as an Arrow IPC stream, a record batch per page, with
+// the same rows and columns as the CSV. the end-of-stream marker is only
+// written once every record is, so a failed export reads as truncated.
+func writeArrow(app *kintone.App, query string, out io.Writer) error {
+	fields, err := getFields(app)
+	if err != nil {
+		return err
+	}
+
+	duplicates, err := dedupeFilter(app, query)
+	if err != nil {
+		return err
+	}
+
+	var columns Columns
+	if config.fields == nil {
+		columns = makeColumns(fields)
+	} else {
+		columns = makePartialColumns(fields, config.fields)
+	}
+	columns = append(columns, derivedColumns()...)
+
+	schema := arrowSchema(columns)
+	w := ipc.NewWriter(out, ipc.WithSchema(schema))
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+
+	pages := fetchPages(app, query, fetchFields())
+	defer pages.stop()
+
+	for {
+		records, err := pages.next()
+		if err != nil {
+			return err
+		}
+		if records == nil {
+			break
+		}
+		started := time.Now()
+
+		for _, record := range records {
+			if duplicates[record.Id()] {
+				atomic.AddUint64(&run.Duplicates, 1)
+				continue
+			}
+			if redactRecord(record) {
+				continue
+			}
+			if err := truncateRecord(record); err != nil {
+				return err
+			}
+
+			rowNum := getSubTableRowCount(record, columns)
+			derivedValues := evalDerived(record)
+			for j := 0; j < rowNum; j++ {
+				for i, f := range columns {
+					value, ok, err := cellValue(app, record, f, j, record.Id(), derivedValues)
+					if err != nil {
+						return err
+					}
+					appendArrowValue(b.Field(i), value, ok)
+				}
+			}
+			atomic.AddUint64(&run.Rows, uint64(rowNum))
+			atomic.AddUint64(&run.Records, 1)
+		}
+
+		batch := b.NewRecord()
+		err = w.Write(batch)
+		batch.Release()
+		if err != nil {
+			return err
+		}
+		addStage(STAGE_SERIALIZE, time.Since(started), uint64(len(records)), 0)
+	}
+	// a short stream ends without the end-of-stream marker
+	if err := reconcileRecordCount(); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// stream the export to out without S3, e.g. into DuckDB or polars
+func exportArrow(app *kintone.App, out io.Writer) error {
+	startRun()
+	run.Destination = "-"
+
+	err := func() error {
+		unlock, err := openState()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		if err := createWorkdir(); err != nil {
+			return err
+		}
+		defer removeWorkdir()
+
+		if run.TotalCount, err = fetchTotalCount(); err != nil {
+			return err
+		}
+		if err := writeArrow(app, config.query, out); err != nil {
+			return err
+		}
+		if config.fileDir != "" {
+			return publishAttachments()
+		}
+		return nil
+	}()
+	endRun(err)
+	return err
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/kintone/go-kintone"
+	"os"
+	"sort"
+	"strings"
+)
+
+// `name` in Athena DDL
+func athenaIdentifier(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// 'value' in Athena DDL
+func athenaString(value string) string {
+	return "'" + strings.Replace(value, "'", "\\'", -1) + "'"
+}
+
+func athenaProperties(params map[string]*string) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]string, 0, len(names))
+	for _, name := range names {
+		list = append(list, "  "+athenaString(name)+" = "+athenaString(aws.StringValue(params[name])))
+	}
+	return strings.Join(list, ",\n")
+}
+
+// schema athena [<database>/<table>]: print the CREATE EXTERNAL TABLE of
+// the CSV export as -glue-table would register it, for the layout of the
+// given options. the table is -glue-table's, else default/kintone_app_<id>.
+func schemaCommand(app *kintone.App, args []string) error {
+	if len(args) == 0 || args[0] != "athena" {
+		return errors.New(T("usage: schema athena [<database>/<table>]"))
+	}
+	name := config.glueTable
+	if len(args) > 1 {
+		name = args[1]
+	}
+	if name == "" {
+		name = fmt.Sprintf("default/kintone_app_%d", config.appId)
+	}
+	database, table, err := parseGlueTable(name)
+	if err != nil {
+		return err
+	}
+	if config.format != "csv" {
+		return errors.New(T("schema athena needs -o csv"))
+	}
+	if glueLocation() == "" {
+		return errors.New(T("-glue-table needs a key under a prefix of its own, e.g. -k exports/{appId}/records.csv"))
+	}
+
+	input, err := glueTableInput(app, table)
+	if err != nil {
+		return err
+	}
+	sd := input.StorageDescriptor
+	columns := make([]string, 0, len(sd.Columns))
+	for _, c := range sd.Columns {
+		columns = append(columns, fmt.Sprintf("  %s %s COMMENT %s", athenaIdentifier(aws.StringValue(c.Name)), aws.StringValue(c.Type), athenaString(aws.StringValue(c.Comment))))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "CREATE EXTERNAL TABLE IF NOT EXISTS %s.%s (\n", athenaIdentifier(database), athenaIdentifier(table))
+	b.WriteString(strings.Join(columns, ",\n") + "\n)\n")
+	fmt.Fprintf(&b, "COMMENT %s\n", athenaString(aws.StringValue(input.Description)))
+
+	// the keys are named as the directories but where a column has the name
+	repairable := true
+	if len(input.PartitionKeys) > 0 {
+		keys := make([]string, 0, len(input.PartitionKeys))
+		for i, c := range input.PartitionKeys {
+			keys = append(keys, athenaIdentifier(aws.StringValue(c.Name))+" "+aws.StringValue(c.Type))
+			if aws.StringValue(c.Name) != gluePartitionPaths()[i] {
+				repairable = false
+			}
+		}
+		fmt.Fprintf(&b, "PARTITIONED BY (%s)\n", strings.Join(keys, ", "))
+	}
+	fmt.Fprintf(&b, "ROW FORMAT SERDE %s\n", athenaString(aws.StringValue(sd.SerdeInfo.SerializationLibrary)))
+	fmt.Fprintf(&b, "WITH SERDEPROPERTIES (\n%s\n)\n", athenaProperties(sd.SerdeInfo.Parameters))
+	fmt.Fprintf(&b, "STORED AS INPUTFORMAT %s\n", athenaString(aws.StringValue(sd.InputFormat)))
+	fmt.Fprintf(&b, "OUTPUTFORMAT %s\n", athenaString(aws.StringValue(sd.OutputFormat)))
+	fmt.Fprintf(&b, "LOCATION %s\n", athenaString(aws.StringValue(sd.Location)))
+	// EXTERNAL is what CREATE EXTERNAL TABLE says
+	properties := make(map[string]*string)
+	for name, value := range input.Parameters {
+		if name != "EXTERNAL" {
+			properties[name] = value
+		}
+	}
+	fmt.Fprintf(&b, "TBLPROPERTIES (\n%s\n);\n", athenaProperties(properties))
+	if len(input.PartitionKeys) > 0 && repairable {
+		fmt.Fprintf(&b, "\nMSCK REPAIR TABLE %s.%s;\n", athenaIdentifier(database), athenaIdentifier(table))
+	}
+
+	_, err = os.Stdout.WriteString(b.String())
+	return err
+}
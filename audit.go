@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const AUDIT_LOG_LIMIT = 500
+
+func validateAuditLogOptions() error {
+	if config.apiToken != "" {
+		// the audit log is only open to administrators, not to API tokens
+		return errors.New(T("-audit-log needs an administrator's login (-u), not an API token"))
+	}
+	return nil
+}
+
+// e.g. golang-kintone-to-s3.audit-log.ndjson next to golang-kintone-to-s3.csv
+func auditLogKey(key string) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + ".audit-log.ndjson"
+}
+
+// a page of the audit log API's response
+type auditLogPage struct {
+	Events *[]json.RawMessage `json:"events"`
+}
+
+// the part of an event the export filters on; the event itself is
+// written as it came
+type auditLogEvent struct {
+	// the app the event is about, none for events outside of an app
+	AppId string `json:"appId"`
+}
+
+// the audit log events of the last -audit-log-since which concern the app,
+// one JSON object per line
+func fetchAuditLog() ([]byte, error) {
+	appId := strconv.FormatUint(config.appId, 10)
+	until := time.Now()
+	since := until.Add(-config.auditLogSince)
+
+	var b bytes.Buffer
+	for offset := 0; ; offset += AUDIT_LOG_LIMIT {
+		if err := interruption(); err != nil {
+			return nil, err
+		}
+		params := url.Values{}
+		params.Set("startAt", since.UTC().Format(time.RFC3339))
+		params.Set("endAt", until.UTC().Format(time.RFC3339))
+		params.Set("limit", strconv.Itoa(AUDIT_LOG_LIMIT))
+		params.Set("offset", strconv.Itoa(offset))
+		data, err := kintoneGet(config.auditLogPath, params)
+		if err != nil {
+			return nil, err
+		}
+
+		var page auditLogPage
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf(T("unexpected response from %s: %v"), config.auditLogPath, err)
+		}
+		if page.Events == nil {
+			return nil, fmt.Errorf(T("unexpected response from %s: no events"), config.auditLogPath)
+		}
+		for _, data := range *page.Events {
+			var event auditLogEvent
+			if err := json.Unmarshal(data, &event); err != nil {
+				return nil, fmt.Errorf(T("unexpected response from %s: %v"), config.auditLogPath, err)
+			}
+			if event.AppId == appId {
+				b.Write(data)
+				b.WriteByte('\n')
+			}
+		}
+		if len(*page.Events) < AUDIT_LOG_LIMIT {
+			return b.Bytes(), nil
+		}
+	}
+}
+
+func exportAuditLog(svc *s3.S3, key string) error {
+	data, err := fetchAuditLog()
+	if err != nil {
+		return err
+	}
+
+	input := newPutObjectInput(auditLogKey(key), bytes.NewReader(data))
+	input.ContentType = aws.String("application/x-ndjson")
+	_, err = svc.PutObject(input)
+	return err
+}
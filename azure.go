@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// -output az://<container>/<blob>
+const AZURE_OUTPUT_PREFIX = "az://"
+
+const AZURE_API_VERSION = "2021-08-06"
+
+// how requests to the storage account are authorized: the account key or a
+// SAS of a connection string, or a token of the managed identity
+type azureCredential struct {
+	account  string
+	endpoint string
+	key      []byte
+	sas      url.Values
+	token    string
+}
+
+func parseAzureOutput(output string) (string, string, error) {
+	path := strings.TrimPrefix(output, AZURE_OUTPUT_PREFIX)
+	i := strings.Index(path, "/")
+	if i <= 0 || i == len(path)-1 {
+		return "", "", fmt.Errorf(T("-output az:// needs a container and a blob name: %s"), output)
+	}
+	return path[:i], path[i+1:], nil
+}
+
+// AccountName=...;AccountKey=...;EndpointSuffix=core.windows.net, or with
+// BlobEndpoint and SharedAccessSignature
+func parseAzureConnectionString(s string) (*azureCredential, error) {
+	values := make(map[string]string)
+	for _, part := range strings.Split(s, ";") {
+		if i := strings.Index(part, "="); i > 0 {
+			values[strings.TrimSpace(part[:i])] = strings.TrimSpace(part[i+1:])
+		}
+	}
+
+	c := &azureCredential{account: values["AccountName"], endpoint: values["BlobEndpoint"]}
+	if c.endpoint == "" {
+		if c.account == "" {
+			return nil, errors.New(T("the Azure connection string has neither AccountName nor BlobEndpoint"))
+		}
+		protocol, suffix := values["DefaultEndpointsProtocol"], values["EndpointSuffix"]
+		if protocol == "" {
+			protocol = "https"
+		}
+		if suffix == "" {
+			suffix = "core.windows.net"
+		}
+		c.endpoint = fmt.Sprintf("%s://%s.blob.%s", protocol, c.account, suffix)
+	}
+	c.endpoint = strings.TrimSuffix(c.endpoint, "/")
+
+	switch {
+	case values["AccountKey"] != "":
+		key, err := base64.StdEncoding.DecodeString(values["AccountKey"])
+		if err != nil {
+			return nil, fmt.Errorf(T("invalid AccountKey in the Azure connection string: %v"), err)
+		}
+		c.key = key
+	case values["SharedAccessSignature"] != "":
+		sas, err := url.ParseQuery(strings.TrimPrefix(values["SharedAccessSignature"], "?"))
+		if err != nil {
+			return nil, err
+		}
+		c.sas = sas
+	default:
+		return nil, errors.New(T("the Azure connection string has neither AccountKey nor SharedAccessSignature"))
+	}
+	return c, nil
+}
+
+// a token for the storage account from the Azure Instance Metadata
+// Service; AZURE_CLIENT_ID picks a user-assigned identity
+func managedIdentityCredential(account string) (*azureCredential, error) {
+	params := url.Values{}
+	params.Set("api-version", "2018-02-01")
+	params.Set("resource", "https://storage.azure.com/")
+	if clientId := os.Getenv("AZURE_CLIENT_ID"); clientId != "" {
+		params.Set("client_id", clientId)
+	}
+	req, err := http.NewRequest("GET", "http://169.254.169.254/metadata/identity/oauth2/token?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata", "true")
+
+	resp, err := sharedHTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(T("managed identity token: %s: %s"), resp.Status, body)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, err
+	}
+	return &azureCredential{
+		account:  account,
+		endpoint: fmt.Sprintf("https://%s.blob.core.windows.net", account),
+		token:    token.AccessToken,
+	}, nil
+}
+
+func newAzureCredential() (*azureCredential, error) {
+	if config.azureConnectionString != "" {
+		return parseAzureConnectionString(config.azureConnectionString)
+	}
+	if config.azureAccount != "" {
+		return managedIdentityCredential(config.azureAccount)
+	}
+	return nil, errors.New(T("-output az:// needs -azure-connection-string or -azure-account"))
+}
+
+// the Shared Key signature of a request, see "Authorize with Shared Key"
+func (c *azureCredential) signature(req *http.Request, contentLength int) string {
+	length := ""
+	if contentLength > 0 {
+		length = fmt.Sprint(contentLength)
+	}
+	var b strings.Builder
+	for _, v := range []string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		length,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, given as x-ms-date
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	} {
+		b.WriteString(v + "\n")
+	}
+
+	names := make([]string, 0)
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-ms-") {
+			names = append(names, lower)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+
+	b.WriteString("/" + c.account + req.URL.EscapedPath())
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for name := range query {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+	for _, name := range params {
+		values := query[name]
+		sort.Strings(values)
+		b.WriteString("\n" + strings.ToLower(name) + ":" + strings.Join(values, ","))
+	}
+
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(b.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (c *azureCredential) do(method, container, blob string, query url.Values, header http.Header, body []byte) error {
+	u, err := url.Parse(c.endpoint + "/" + container + "/" + blob)
+	if err != nil {
+		return err
+	}
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	for k, v := range c.sas {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", AZURE_API_VERSION)
+	switch {
+	case c.key != nil:
+		req.Header.Set("Authorization", "SharedKey "+c.account+":"+c.signature(req, len(body)))
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := sharedHTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s/%s: %s: %s", method, container, blob, resp.Status, data)
+	}
+	return nil
+}
+
+// uploads what is written to it as the blocks of a block blob, committed
+// by Close. uncommitted blocks of a failed export are discarded by Azure.
+type azureBlobWriter struct {
+	cred      *azureCredential
+	container string
+	blob      string
+	buf       bytes.Buffer
+	blocks    []string
+	size      int64
+	metadata  map[string]*string
+}
+
+func newAzureBlobWriter(output string) (*azureBlobWriter, error) {
+	container, blob, err := parseAzureOutput(output)
+	if err != nil {
+		return nil, err
+	}
+	cred, err := newAzureCredential()
+	if err != nil {
+		return nil, err
+	}
+	return &azureBlobWriter{cred: cred, container: container, blob: blob}, nil
+}
+
+func (w *azureBlobWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		room := int(config.partSize) - w.buf.Len()
+		if room > len(p) {
+			room = len(p)
+		}
+		w.buf.Write(p[:room])
+		p = p[room:]
+		if w.buf.Len() >= int(config.partSize) {
+			if err := w.putBlock(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *azureBlobWriter) putBlock() error {
+	// the IDs of a blob are all of one length
+	id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%08d", len(w.blocks))))
+	query := url.Values{"comp": {"block"}, "blockid": {id}}
+	if err := w.cred.do("PUT", w.container, w.blob, query, nil, w.buf.Bytes()); err != nil {
+		return err
+	}
+	w.blocks = append(w.blocks, id)
+	w.size += int64(w.buf.Len())
+	w.buf.Reset()
+	return nil
+}
+
+func (w *azureBlobWriter) Close() error {
+	if w.buf.Len() > 0 {
+		if err := w.putBlock(); err != nil {
+			return err
+		}
+	}
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?><BlockList>`)
+	for _, id := range w.blocks {
+		b.WriteString("<Latest>" + id + "</Latest>")
+	}
+	b.WriteString("</BlockList>")
+
+	header := http.Header{}
+	header.Set("x-ms-blob-content-type", exportContentType())
+	if encoding := exportContentEncoding(); encoding != nil {
+		header.Set("x-ms-blob-content-encoding", *encoding)
+	}
+	for name, value := range w.metadata {
+		// metadata names are C# identifiers
+		header.Set("x-ms-meta-"+strings.Replace(name, "-", "_", -1), *value)
+	}
+	return w.cred.do("PUT", w.container, w.blob, url.Values{"comp": {"blocklist"}}, header, b.Bytes())
+}
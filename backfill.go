@@ -0,0 +1,21 @@
+package main
+
+import (
+	"errors"
+	"path"
+	"strings"
+)
+
+// -backfill exports $id and the given fields only, to be merged into an
+// earlier full export by $id. the narrow file gets its own key, e.g.
+// golang-kintone-to-s3.backfill.担当者_期日.csv
+func setupBackfill(codes []string, colNames string) error {
+	if colNames != "" {
+		return errors.New(T("-backfill cannot be combined with -c"))
+	}
+
+	config.fields = append([]string{"$id"}, codes...)
+	ext := path.Ext(config.key)
+	config.key = strings.TrimSuffix(config.key, ext) + ".backfill." + strings.Join(codes, "_") + ext
+	return nil
+}
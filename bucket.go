@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"log"
+)
+
+func validateCreateBucketOptions() error {
+	if !config.createBucket {
+		return nil
+	}
+	if config.bucketOwner != "" {
+		return errors.New(T("-create-bucket cannot create a bucket in another account (-s3-owner)"))
+	}
+	return nil
+}
+
+// create the bucket when it does not exist yet, private and with all
+// public access blocked
+func ensureBucket(svc *s3.S3) error {
+	_, err := svc.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String(config.bucketName)})
+	if err == nil {
+		return nil
+	}
+	// a 403 is a bucket of someone else or missing permissions, neither of
+	// which creating it would fix
+	if aerr, ok := err.(awserr.RequestFailure); !ok || aerr.StatusCode() != 404 {
+		return err
+	}
+
+	input := &s3.CreateBucketInput{Bucket: aws.String(config.bucketName)}
+	region := aws.StringValue(svc.Config.Region)
+	if region != "" && region != "us-east-1" && config.s3Endpoint == "" {
+		// us-east-1 is the default and rejects the constraint
+		input.CreateBucketConfiguration = &s3.CreateBucketConfiguration{
+			LocationConstraint: aws.String(region),
+		}
+	}
+	if config.objectOwnership != "" {
+		input.ObjectOwnership = aws.String(config.objectOwnership)
+	}
+	if _, err := svc.CreateBucket(input); err != nil {
+		return err
+	}
+	if err := svc.WaitUntilBucketExists(&s3.HeadBucketInput{Bucket: aws.String(config.bucketName)}); err != nil {
+		return err
+	}
+	log.Printf(T("created bucket s3://%s"), config.bucketName)
+
+	if config.s3Endpoint != "" {
+		// S3-compatible stores mostly have no Block Public Access
+		return nil
+	}
+	_, err = svc.PutPublicAccessBlock(&s3.PutPublicAccessBlockInput{
+		Bucket: aws.String(config.bucketName),
+		PublicAccessBlockConfiguration: &s3.PublicAccessBlockConfiguration{
+			BlockPublicAcls:       aws.Bool(true),
+			BlockPublicPolicy:     aws.Bool(true),
+			IgnorePublicAcls:      aws.Bool(true),
+			RestrictPublicBuckets: aws.Bool(true),
+		},
+	})
+	return err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var orderByRegexp = regexp.MustCompile(`(?i)\border\s+by\b`)
+
+// without an order by, kintone's order is not guaranteed to repeat
+func canonicalQuery(query string) string {
+	if orderByRegexp.MatchString(query) {
+		return query
+	}
+	return andQueryTail(query, "order by $id asc")
+}
+
+// put order by before a limit and offset of the query
+func andQueryTail(query, order string) string {
+	if loc := queryTailRegexp.FindStringIndex(query); loc != nil {
+		return query[:loc[0]] + order + " " + query[loc[0]:]
+	}
+	return query + " " + order
+}
+
+func canonicalValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = canonicalValue(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = canonicalValue(value)
+		}
+	case json.Number:
+		if _, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return v
+		}
+		if f, err := strconv.ParseFloat(string(v), 64); err == nil {
+			return json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+		}
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	return v
+}
+
+// re-encode a record so equal data gives equal bytes: keys sorted, numbers
+// in their shortest form, times in UTC and no HTML escaping
+func canonicalJSON(data []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(canonicalValue(v)); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/kintone/go-kintone"
+	"hash"
+	"io"
+	"log"
+	"time"
+)
+
+// progress of a snapshot exported over several invocations, kept in the
+// state store. each invocation exports the records after AfterId into one
+// part object; the invocation that reaches the end joins the parts into
+// the output object.
+type chunkState struct {
+	Snapshot  string    `json:"snapshot"`
+	Query     string    `json:"query"`
+	StartedAt time.Time `json:"started_at"`
+	Columns   []string  `json:"columns,omitempty"`
+	Parts     int       `json:"parts"`
+	PartKeys  []string  `json:"part_keys,omitempty"`
+	PartSizes []int64   `json:"part_sizes,omitempty"`
+	// the SHA-256 of the parts so far, to continue in the next part
+	HashState []byte `json:"hash_state,omitempty"`
+	AfterId   uint64 `json:"after_id"`
+	Records   uint64 `json:"records"`
+
+	// this invocation
+	started  time.Time
+	fetched  uint64
+	complete bool
+}
+
+// nil unless -chunk-records or -chunk-time is given
+var chunk *chunkState
+
+func chunked() bool {
+	return config.chunkRecords > 0 || config.chunkTime > 0
+}
+
+func validateChunkOptions() error {
+	if config.state == "" {
+		return errors.New(T("-chunk-records and -chunk-time need -state"))
+	}
+	if config.format == "json" {
+		return errors.New(T("-chunk-records and -chunk-time cannot be combined with -o json"))
+	}
+	if config.pipe != "" {
+		return errors.New(T("-chunk-records and -chunk-time cannot be combined with -pipe"))
+	}
+	if queryTailRegexp.MatchString(config.query) {
+		return errors.New(T("the query of a chunked export must not have order by, limit or offset"))
+	}
+	return nil
+}
+
+func chunkStateKey() string {
+	return fmt.Sprintf("chunk/%s/%d.json", config.domain, config.appId)
+}
+
+// the part this invocation writes. an invocation which failed before
+// recording its part leaves it behind rather than being overwritten.
+func chunkPartKey(snapshot string, part int) string {
+	return fmt.Sprintf("%s.parts/%s/%05d-%s", config.key, snapshot, part, attemptId())
+}
+
+func chunkPartsPrefix(snapshot string) string {
+	return fmt.Sprintf("%s.parts/%s/", config.key, snapshot)
+}
+
+// load the snapshot in progress, or start a new one
+func beginChunk() error {
+	data, err := state.Get(chunkStateKey())
+	if err != nil {
+		return err
+	}
+
+	chunk = &chunkState{}
+	if data != nil {
+		if err := json.Unmarshal(data, chunk); err != nil {
+			return err
+		}
+		if chunk.Query != config.query {
+			log.Printf(T("the query changed, restarting snapshot %s"), chunk.Snapshot)
+			chunk = &chunkState{}
+		} else if chunk.Parts > 0 && (len(chunk.PartSizes) != chunk.Parts || chunk.HashState == nil) {
+			// the parts cannot be joined without their sizes and sum
+			log.Printf(T("snapshot %s was started by an older version, restarting it"), chunk.Snapshot)
+			chunk = &chunkState{}
+		}
+	}
+	if chunk.Snapshot == "" {
+		chunk.Snapshot = run.Id
+		chunk.Query = config.query
+		chunk.StartedAt = run.StartedAt
+	}
+	chunk.started = time.Now()
+
+	run.Snapshot = chunk.Snapshot
+	run.Part = chunk.Parts + 1
+	run.Destination = "s3://" + config.bucketName + "/" + chunkPartKey(chunk.Snapshot, chunk.Parts)
+	return nil
+}
+
+// whether this invocation has fetched its share of the snapshot
+func (c *chunkState) full() bool {
+	if config.chunkRecords > 0 && c.fetched >= uint64(config.chunkRecords) {
+		return true
+	}
+	return config.chunkTime > 0 && time.Since(c.started) >= config.chunkTime
+}
+
+// the page of records following afterId, in id order so that the next
+// invocation can carry on from the last one
+func getRecordsAfter(app *kintone.App, fields []string, afterId uint64) ([]*kintone.Record, bool, error) {
+	query := andQuery(config.query, fmt.Sprintf("$id > %d", afterId))
+	query += fmt.Sprintf(" order by $id asc limit %d", EXPORT_ROW_LIMIT)
+
+	records, err := fetchRecords(app, fields, query)
+	if err != nil {
+		return nil, true, err
+	}
+	return records, len(records) < EXPORT_ROW_LIMIT, nil
+}
+
+// codes to rebuild the same columns in the later parts; the order of
+// makeColumns is not stable between invocations
+func chunkColumnCodes(columns Columns) []string {
+	codes := make([]string, 0, len(columns))
+	for _, c := range columns {
+		if c.IsSubField || c.Type == FT_DERIVED {
+			continue
+		}
+		codes = append(codes, c.Code)
+	}
+	return codes
+}
+
+// the SHA-256 of the parts exported so far, which the upload of the next
+// part carries on, so the joined object's sum needs no download
+func (c *chunkState) resumeHash() (hash.Hash, error) {
+	h := sha256.New()
+	if c.HashState != nil {
+		if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(c.HashState); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// after the part is uploaded: record the progress, or join the parts if
+// the snapshot is complete
+func endChunk(svc *s3.S3, upload *uploadPipeline) error {
+	hashState, err := upload.hash.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	chunk.PartKeys = append(chunk.PartKeys, chunkPartKey(chunk.Snapshot, chunk.Parts))
+	chunk.PartSizes = append(chunk.PartSizes, upload.Len())
+	chunk.HashState = hashState
+	chunk.Parts++
+	chunk.Records += run.Records
+
+	if !chunk.complete {
+		data, err := json.Marshal(chunk)
+		if err != nil {
+			return err
+		}
+		log.Printf(T("snapshot %s: part %d exported, %d records so far"), chunk.Snapshot, chunk.Parts, chunk.Records)
+		return state.Put(chunkStateKey(), data)
+	}
+
+	if err := joinChunkParts(svc); err != nil {
+		return err
+	}
+	log.Printf(T("snapshot %s: complete, %d records in %d parts"), chunk.Snapshot, chunk.Records, chunk.Parts)
+	return state.Delete(chunkStateKey())
+}
+
+// the multipart upload joining the parts of a snapshot into config.key.
+// the parts are copied by S3; those under MIN_PART_SIZE, which S3 does
+// not take but as the last part, are downloaded and merged with the next.
+type chunkJoin struct {
+	svc      *s3.S3
+	uploadId *string
+	partSize int64
+	buf      bytes.Buffer
+	done     []*s3.CompletedPart
+}
+
+func (j *chunkJoin) add(key string, size int64) error {
+	var offset int64
+	if j.buf.Len() > 0 {
+		// fill up the merged part from the head of this one
+		offset = int64(MIN_PART_SIZE - j.buf.Len())
+		if offset > size {
+			offset = size
+		}
+		if err := j.download(key, 0, offset); err != nil {
+			return err
+		}
+		if j.buf.Len() >= MIN_PART_SIZE {
+			if err := j.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	for offset < size {
+		n := size - offset
+		if n < MIN_PART_SIZE {
+			return j.download(key, offset, n)
+		}
+		if n > j.partSize {
+			n = j.partSize
+		}
+		if err := j.copy(key, offset, n); err != nil {
+			return err
+		}
+		offset += n
+	}
+	return nil
+}
+
+func (j *chunkJoin) nextPart() (*int64, error) {
+	if len(j.done) == MAX_PARTS {
+		return nil, fmt.Errorf(T("snapshot %s needs more than %d parts to be joined; export larger chunks"), chunk.Snapshot, MAX_PARTS)
+	}
+	return aws.Int64(int64(len(j.done)) + 1), nil
+}
+
+func (j *chunkJoin) download(key string, offset, n int64) error {
+	if n == 0 {
+		return nil
+	}
+	out, err := j.svc.GetObject(&s3.GetObjectInput{
+		Bucket:              aws.String(config.bucketName),
+		Key:                 aws.String(key),
+		Range:               aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+n-1)),
+		ExpectedBucketOwner: newPutObjectInput(key, nil).ExpectedBucketOwner,
+	})
+	if err != nil {
+		return err
+	}
+	defer out.Body.Close()
+	_, err = io.Copy(&j.buf, out.Body)
+	return err
+}
+
+// upload the merged part
+func (j *chunkJoin) flush() error {
+	number, err := j.nextPart()
+	if err != nil {
+		return err
+	}
+	input := &s3.UploadPartInput{
+		Bucket:              aws.String(config.bucketName),
+		Key:                 aws.String(config.key),
+		UploadId:            j.uploadId,
+		PartNumber:          number,
+		Body:                bytes.NewReader(j.buf.Bytes()),
+		ExpectedBucketOwner: newPutObjectInput(config.key, nil).ExpectedBucketOwner,
+	}
+	if config.s3Checksum {
+		input.ChecksumSHA256 = checksumSHA256(j.buf.Bytes())
+	}
+	out, err := j.svc.UploadPart(input)
+	if err == nil {
+		err = verifyChecksum(input.ChecksumSHA256, out.ChecksumSHA256)
+	}
+	if err != nil {
+		return err
+	}
+	j.done = append(j.done, &s3.CompletedPart{ETag: out.ETag, PartNumber: number, ChecksumSHA256: out.ChecksumSHA256})
+	j.buf.Reset()
+	return nil
+}
+
+func (j *chunkJoin) copy(key string, offset, n int64) error {
+	number, err := j.nextPart()
+	if err != nil {
+		return err
+	}
+	owner := newPutObjectInput(key, nil).ExpectedBucketOwner
+	out, err := j.svc.UploadPartCopy(&s3.UploadPartCopyInput{
+		Bucket:                    aws.String(config.bucketName),
+		Key:                       aws.String(config.key),
+		UploadId:                  j.uploadId,
+		PartNumber:                number,
+		CopySource:                aws.String(copySource(key)),
+		CopySourceRange:           aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+n-1)),
+		ExpectedBucketOwner:       owner,
+		ExpectedSourceBucketOwner: owner,
+	})
+	if err != nil {
+		return err
+	}
+	j.done = append(j.done, &s3.CompletedPart{ETag: out.CopyPartResult.ETag, PartNumber: number, ChecksumSHA256: out.CopyPartResult.ChecksumSHA256})
+	return nil
+}
+
+func (j *chunkJoin) complete() error {
+	// the last part may be short; an empty object is one empty part
+	if j.buf.Len() > 0 || len(j.done) == 0 {
+		if err := j.flush(); err != nil {
+			return err
+		}
+	}
+	complete := &s3.CompleteMultipartUploadInput{
+		Bucket:              aws.String(config.bucketName),
+		Key:                 aws.String(config.key),
+		UploadId:            j.uploadId,
+		ExpectedBucketOwner: newPutObjectInput(config.key, nil).ExpectedBucketOwner,
+		MultipartUpload:     &s3.CompletedMultipartUpload{Parts: j.done},
+	}
+	if config.ifNotExists != "" {
+		complete.IfNoneMatch = aws.String("*")
+	}
+	_, err := j.svc.CompleteMultipartUpload(complete)
+	if config.ifNotExists != "" {
+		err = keyTakenError(err)
+	}
+	return err
+}
+
+func (j *chunkJoin) abort() {
+	_, err := j.svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:              aws.String(config.bucketName),
+		Key:                 aws.String(config.key),
+		UploadId:            j.uploadId,
+		ExpectedBucketOwner: newPutObjectInput(config.key, nil).ExpectedBucketOwner,
+	})
+	if err != nil {
+		log.Printf(T("could not abort upload of s3://%s/%s: %v"), config.bucketName, config.key, err)
+	}
+}
+
+// join the parts into config.key without downloading them; the sum is
+// the one carried over the parts' uploads
+func joinChunkParts(svc *s3.S3) error {
+	var size int64
+	for _, n := range chunk.PartSizes {
+		size += n
+	}
+	h, err := chunk.resumeHash()
+	if err != nil {
+		return err
+	}
+	sum := h.Sum(nil)
+
+	input := newCreateMultipartUploadInput(config.key)
+	input.Metadata = provenanceMetadata(true)
+	input.ContentType = aws.String(exportContentType())
+	input.ContentEncoding = exportContentEncoding()
+	if config.s3Checksum {
+		input.ChecksumAlgorithm = aws.String(s3.ChecksumAlgorithmSha256)
+	}
+	out, err := svc.CreateMultipartUpload(input)
+	if err != nil {
+		return err
+	}
+	j := &chunkJoin{svc: svc, uploadId: out.UploadId, partSize: copyPartSize(size)}
+	for part, key := range chunk.PartKeys {
+		if err = j.add(key, chunk.PartSizes[part]); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = j.complete()
+	}
+	if err != nil {
+		j.abort()
+		return err
+	}
+
+	run.Destination = "s3://" + config.bucketName + "/" + config.key
+	addManifestObject(config.key, size, chunk.Records, sum)
+	if exportSigner != nil {
+		if err := signObject(svc, config.key, size, sum); err != nil {
+			return err
+		}
+	}
+	if config.sha256Sidecar {
+		if err := putChecksumSidecar(svc, config.key, sum); err != nil {
+			return err
+		}
+	}
+
+	// the parts joined and any left by failed invocations
+	keys := make([]string, 0, chunk.Parts)
+	err = svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(config.bucketName),
+		Prefix: aws.String(chunkPartsPrefix(chunk.Snapshot)),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.StringValue(obj.Key))
+		}
+		return true
+	})
+	if err != nil {
+		log.Printf(T("could not list the parts of snapshot %s: %v"), chunk.Snapshot, err)
+		keys = chunk.PartKeys
+	}
+	for _, key := range keys {
+		_, err := svc.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(config.bucketName),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			log.Printf(T("could not remove part s3://%s/%s: %v"), config.bucketName, key, err)
+		}
+	}
+	return nil
+}
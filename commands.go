@@ -0,0 +1,435 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type commandInfo struct {
+	name     string
+	args     string
+	summary  string
+	examples []string
+}
+
+// "export" is what runs when no command is given
+var commands = []commandInfo{
+	{
+		name:    "export",
+		summary: "Export the app's records to S3 (the default command)",
+		examples: []string{
+			"-a 123 -q 'updated_time > LAST_WEEK()'",
+			"export -a 123 -c '$id,会社名,担当者' -e sjis",
+			"-config jobs/customers.json",
+		},
+	},
+	{
+		name:    "preflight",
+		summary: "Check kintone and S3 access without exporting",
+		examples: []string{
+			"preflight -a 123",
+			"preflight -config jobs/customers.json -sse-kms-key-id alias/partner",
+		},
+	},
+	{
+		name:    "template",
+		summary: "Print a job config for the app to start from",
+		examples: []string{
+			"template -a 123 > jobs/customers.json",
+			"template -a 123 -q 'status not in (\"完了\")'",
+		},
+	},
+	{
+		name:    "backfill",
+		args:    "<date field> <from> <to>",
+		summary: "Export a date range window by window into its partitions, with checkpoints",
+		examples: []string{
+			"backfill -a 123 -state s3://my-bucket/state -partition-by dt 作成日時 2023-01-01 2024-12-31",
+			"backfill -a 123 -state state -window month -k 'exports/{date}.csv' 納期 2024-01-01 2024-12-31",
+		},
+	},
+	{
+		name:    "schema",
+		args:    "athena [<database>/<table>]",
+		summary: "Print the Athena CREATE EXTERNAL TABLE of the CSV export",
+		examples: []string{
+			"schema athena -a 123 -k exports/app-123/records.csv -partition-by app_id,dt",
+			"schema athena analytics/customers -config jobs/customers.json",
+		},
+	},
+	{
+		name:    "runs",
+		args:    "list | show <run id> | tag <tag> | download <run id or tag>",
+		summary: "Show the run history",
+		examples: []string{
+			"runs list -history s3://my-bucket/runs",
+			"runs list -a 123",
+			"runs show 20240501T020000Z-1a2b3c4d",
+			"runs tag -a 123 pre-migration",
+			"runs download -a 123 pre-migration > customers.csv",
+		},
+	},
+	{
+		name:    "retry-failures",
+		summary: "Download the attachments queued by -queue-attachment-failures again",
+		examples: []string{
+			"retry-failures -a 123 -b attachments",
+		},
+	},
+	{
+		name:    "decrypt",
+		args:    "<key>",
+		summary: "Write an object exported with -envelope-kms-key-id, decrypted, to standard output",
+		examples: []string{
+			"decrypt exports/customers.csv > customers.csv",
+		},
+	},
+	{
+		name:    "completion",
+		args:    "bash | zsh | fish | powershell",
+		summary: "Print a shell completion script",
+		examples: []string{
+			"completion bash > /etc/bash_completion.d/golang-kintone-to-s3",
+			"completion zsh > \"${fpath[1]}/_golang-kintone-to-s3\"",
+			"completion fish > ~/.config/fish/completions/golang-kintone-to-s3.fish",
+			"completion powershell >> $PROFILE",
+		},
+	},
+	{
+		name:    "help",
+		args:    "[command]",
+		summary: "Show help for a command",
+		examples: []string{
+			"help runs",
+		},
+	},
+}
+
+// values offered by the completion scripts
+var flagValues = map[string][]string{
+	"o":               {"csv", "json", "sqlite", "arrow"},
+	"e":               encodings,
+	"lang":            {"ja", "en"},
+	"s3-ownership":    {"BucketOwnerEnforced", "BucketOwnerPreferred", "ObjectWriter"},
+	"acl":             {"private", "bucket-owner-full-control", "bucket-owner-read", "authenticated-read", "public-read"},
+	"event-format":    {"tool", "s3"},
+	"sse":             {"AES256", "aws:kms"},
+	"storage-class":   {"STANDARD", "STANDARD_IA", "ONEZONE_IA", "INTELLIGENT_TIERING", "GLACIER_IR", "GLACIER", "DEEP_ARCHIVE"},
+	"schema-breaking": {"fail", "warn"},
+	"window":          {"day", "month"},
+	"sign-algorithm":  {"RSASSA_PSS_SHA_256", "RSASSA_PSS_SHA_384", "RSASSA_PSS_SHA_512", "RSASSA_PKCS1_V1_5_SHA_256", "ECDSA_SHA_256"},
+}
+
+// flags taking a file or directory
+var fileFlags = []string{"b", "config", "cpuprofile", "f", "memprofile", "routes", "sign-key", "workdir"}
+
+// the hidden command the completion scripts run for the names of jobs
+// and profiles, which are only known at completion time
+const COMPLETE_COMMAND = "__complete"
+
+// flags completed by COMPLETE_COMMAND, with what it lists for them
+var dynamicFlags = map[string]string{
+	"config":      "jobs",
+	"aws-profile": "profiles",
+}
+
+func sortedDynamicFlags() []string {
+	keys := make([]string, 0, len(dynamicFlags))
+	for key := range dynamicFlags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func completeCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(T("usage: __complete jobs | profiles"))
+	}
+
+	var names []string
+	var err error
+	switch args[0] {
+	case "jobs":
+		names, err = jobNames()
+	case "profiles":
+		names, err = awsProfileNames()
+	default:
+		return fmt.Errorf(T("unknown completion: %s"), args[0])
+	}
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return nil
+}
+
+func isFileFlag(name string) bool {
+	for _, f := range fileFlags {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+func findCommand(name string) *commandInfo {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
+
+func printUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, T("Usage: %s [command] [options]")+"\n\n", programName())
+	fmt.Fprintln(out, T("Commands:"))
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-12s %s\n", c.name, T(c.summary))
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, T("Options:"))
+	flag.PrintDefaults()
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, T("-u, -p, -U, -P, -t, -sign-key, KINTONE_API_TOKEN, KINTONE_TO_S3_ACCESSKEY, KINTONE_TO_S3_SECRET and -azure-connection-string may be secret references:"))
+	fmt.Fprintln(out, "  env://NAME  file:///path  secretsmanager://name#field  ssm:///name  vault://secret/data/name#field")
+}
+
+func printCommandHelp(c *commandInfo) {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "%s %s %s\n\n", programName(), c.name, c.args)
+	fmt.Fprintf(out, "  %s\n\n", T(c.summary))
+	fmt.Fprintln(out, T("Examples:"))
+	for _, example := range c.examples {
+		fmt.Fprintf(out, "  %s %s\n", programName(), example)
+	}
+}
+
+func helpCommand(args []string) error {
+	if len(args) == 0 {
+		printUsage()
+		return nil
+	}
+	c := findCommand(args[0])
+	if c == nil {
+		return fmt.Errorf(T("unknown command: %s"), args[0])
+	}
+	printCommandHelp(c)
+	return nil
+}
+
+func flagNames() []string {
+	names := make([]string, 0)
+	flag.VisitAll(func(f *flag.Flag) {
+		names = append(names, "-"+f.Name)
+	})
+	sort.Strings(names)
+	return names
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func completionCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(T("usage: completion bash | zsh | fish | powershell"))
+	}
+
+	name := programName()
+	fn := "_" + strings.NewReplacer("-", "_", ".", "_").Replace(name)
+	var script string
+	switch args[0] {
+	case "bash":
+		script = bashCompletion(name, fn)
+	case "zsh":
+		script = zshCompletion(name, fn)
+	case "fish":
+		script = fishCompletion(name)
+	case "powershell":
+		script = powershellCompletion(name)
+	default:
+		return fmt.Errorf(T("unsupported shell: %s"), args[0])
+	}
+	fmt.Print(script)
+	return nil
+}
+
+func bashCompletion(name, fn string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	b.WriteString("  local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	b.WriteString("  case \"$prev\" in\n")
+	for _, key := range sortedDynamicFlags() {
+		files := ""
+		if isFileFlag(key) {
+			files = " $(compgen -f -- \"$cur\")"
+		}
+		fmt.Fprintf(&b, "    -%s|--%s) COMPREPLY=( $(compgen -W \"$(%s %s %s 2>/dev/null)\" -- \"$cur\")%s ); return ;;\n", key, key, name, COMPLETE_COMMAND, dynamicFlags[key], files)
+	}
+	for _, key := range fileFlags {
+		if dynamicFlags[key] != "" {
+			continue
+		}
+		fmt.Fprintf(&b, "    -%s|--%s) COMPREPLY=( $(compgen -f -- \"$cur\") ); return ;;\n", key, key)
+	}
+	for _, key := range sortedKeys(flagValues) {
+		fmt.Fprintf(&b, "    -%s|--%s) COMPREPLY=( $(compgen -W \"%s\" -- \"$cur\") ); return ;;\n", key, key, strings.Join(flagValues[key], " "))
+	}
+	b.WriteString("    runs) COMPREPLY=( $(compgen -W \"list show tag download\" -- \"$cur\") ); return ;;\n")
+	b.WriteString("    schema) COMPREPLY=( $(compgen -W \"athena\" -- \"$cur\") ); return ;;\n")
+	b.WriteString("    completion) COMPREPLY=( $(compgen -W \"bash zsh fish powershell\" -- \"$cur\") ); return ;;\n")
+	fmt.Fprintf(&b, "    help) COMPREPLY=( $(compgen -W \"%s\" -- \"$cur\") ); return ;;\n", strings.Join(commandNames(), " "))
+	b.WriteString("  esac\n")
+	b.WriteString("  if [[ $COMP_CWORD -eq 1 && \"$cur\" != -* ]]; then\n")
+	fmt.Fprintf(&b, "    COMPREPLY=( $(compgen -W \"%s\" -- \"$cur\") )\n", strings.Join(commandNames(), " "))
+	b.WriteString("    return\n")
+	b.WriteString("  fi\n")
+	fmt.Fprintf(&b, "  COMPREPLY=( $(compgen -W \"%s\" -- \"$cur\") )\n", strings.Join(flagNames(), " "))
+	b.WriteString("}\n")
+	fmt.Fprintf(&b, "complete -F %s %s\n", fn, name)
+	return b.String()
+}
+
+func zshCompletion(name, fn string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#compdef %s\n\n", name)
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	b.WriteString("  local -a commands\n")
+	b.WriteString("  commands=(\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "    %s\n", zshQuote(c.name+":"+T(c.summary)))
+	}
+	b.WriteString("  )\n")
+	b.WriteString("  case \"${words[CURRENT-1]}\" in\n")
+	for _, key := range sortedDynamicFlags() {
+		files := ""
+		if isFileFlag(key) {
+			files = " _files;"
+		}
+		fmt.Fprintf(&b, "    -%s|--%s) compadd -- ${(f)\"$(%s %s %s 2>/dev/null)\"};%s return ;;\n", key, key, name, COMPLETE_COMMAND, dynamicFlags[key], files)
+	}
+	for _, key := range fileFlags {
+		if dynamicFlags[key] != "" {
+			continue
+		}
+		fmt.Fprintf(&b, "    -%s|--%s) _files; return ;;\n", key, key)
+	}
+	for _, key := range sortedKeys(flagValues) {
+		fmt.Fprintf(&b, "    -%s|--%s) compadd -- %s; return ;;\n", key, key, strings.Join(flagValues[key], " "))
+	}
+	b.WriteString("    runs) compadd -- list show tag download; return ;;\n")
+	b.WriteString("    schema) compadd -- athena; return ;;\n")
+	b.WriteString("    completion) compadd -- bash zsh fish powershell; return ;;\n")
+	fmt.Fprintf(&b, "    help) compadd -- %s; return ;;\n", strings.Join(commandNames(), " "))
+	b.WriteString("  esac\n")
+	b.WriteString("  if (( CURRENT == 2 )) && [[ \"${words[CURRENT]}\" != -* ]]; then\n")
+	b.WriteString("    _describe 'command' commands\n")
+	b.WriteString("    return\n")
+	b.WriteString("  fi\n")
+	fmt.Fprintf(&b, "  compadd -- %s\n", strings.Join(flagNames(), " "))
+	b.WriteString("}\n\n")
+	fmt.Fprintf(&b, "compdef %s %s\n", fn, name)
+	return b.String()
+}
+
+func zshQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "'\\''", -1) + "'"
+}
+
+func fishCompletion(name string) string {
+	var b strings.Builder
+	for _, c := range commands {
+		fmt.Fprintf(&b, "complete -c %s -f -n '__fish_use_subcommand' -a %s -d %s\n", name, c.name, zshQuote(T(c.summary)))
+	}
+	fmt.Fprintf(&b, "complete -c %s -f -n '__fish_seen_subcommand_from runs' -a 'list show tag download'\n", name)
+	fmt.Fprintf(&b, "complete -c %s -f -n '__fish_seen_subcommand_from schema' -a 'athena'\n", name)
+	fmt.Fprintf(&b, "complete -c %s -f -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish powershell'\n", name)
+	flag.VisitAll(func(f *flag.Flag) {
+		switch {
+		case dynamicFlags[f.Name] != "" && isFileFlag(f.Name):
+			fmt.Fprintf(&b, "complete -c %s -o %s -r -F -a %s -d %s\n", name, f.Name, zshQuote(fmt.Sprintf("(%s %s %s 2>/dev/null)", name, COMPLETE_COMMAND, dynamicFlags[f.Name])), zshQuote(f.Usage))
+		case dynamicFlags[f.Name] != "":
+			fmt.Fprintf(&b, "complete -c %s -o %s -x -a %s -d %s\n", name, f.Name, zshQuote(fmt.Sprintf("(%s %s %s 2>/dev/null)", name, COMPLETE_COMMAND, dynamicFlags[f.Name])), zshQuote(f.Usage))
+		case isFileFlag(f.Name):
+			fmt.Fprintf(&b, "complete -c %s -o %s -r -F -d %s\n", name, f.Name, zshQuote(f.Usage))
+		case flagValues[f.Name] != nil:
+			fmt.Fprintf(&b, "complete -c %s -o %s -x -a %s -d %s\n", name, f.Name, zshQuote(strings.Join(flagValues[f.Name], " ")), zshQuote(f.Usage))
+		default:
+			fmt.Fprintf(&b, "complete -c %s -o %s -d %s\n", name, f.Name, zshQuote(f.Usage))
+		}
+	})
+	return b.String()
+}
+
+func powershellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func powershellList(items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = powershellQuote(item)
+	}
+	return "@(" + strings.Join(quoted, ", ") + ")"
+}
+
+func powershellCompletion(name string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Register-ArgumentCompleter -Native -CommandName %s -ScriptBlock {\n", powershellQuote(name))
+	b.WriteString("    param($wordToComplete, $commandAst, $cursorPosition)\n")
+	fmt.Fprintf(&b, "    $commands = %s\n", powershellList(commandNames()))
+	fmt.Fprintf(&b, "    $flags = %s\n", powershellList(flagNames()))
+	b.WriteString("    $values = @{\n")
+	for _, key := range sortedKeys(flagValues) {
+		fmt.Fprintf(&b, "        %s = %s\n", powershellQuote("-"+key), powershellList(flagValues[key]))
+	}
+	b.WriteString("        'runs' = @('list', 'show', 'tag', 'download')\n")
+	b.WriteString("        'schema' = @('athena')\n")
+	b.WriteString("        'completion' = @('bash', 'zsh', 'fish', 'powershell')\n")
+	fmt.Fprintf(&b, "        'help' = %s\n", powershellList(commandNames()))
+	b.WriteString("    }\n")
+	b.WriteString("    $dynamic = @{\n")
+	for _, key := range sortedDynamicFlags() {
+		fmt.Fprintf(&b, "        %s = %s\n", powershellQuote("-"+key), powershellQuote(dynamicFlags[key]))
+	}
+	b.WriteString("    }\n")
+	b.WriteString("    $elements = @($commandAst.CommandElements | ForEach-Object { $_.ToString() })\n")
+	b.WriteString("    $index = if ($wordToComplete) { $elements.Count - 2 } else { $elements.Count - 1 }\n")
+	b.WriteString("    $prev = if ($index -ge 1) { $elements[$index] } else { '' }\n")
+	fmt.Fprintf(&b, "    $candidates = if ($dynamic.ContainsKey($prev)) { & %s %s $dynamic[$prev] 2>$null }\n", powershellQuote(name), COMPLETE_COMMAND)
+	b.WriteString("        elseif ($values.ContainsKey($prev)) { $values[$prev] }\n")
+	b.WriteString("        elseif ($index -eq 0 -and -not $wordToComplete.StartsWith('-')) { $commands }\n")
+	b.WriteString("        else { $flags }\n")
+	b.WriteString("    $candidates | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {\n")
+	b.WriteString("        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)\n")
+	b.WriteString("    }\n")
+	b.WriteString("}\n")
+	return b.String()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const COMPRESS_GZIP = "gzip"
+
+func validateCompressOptions() error {
+	switch config.compress {
+	case "":
+		return nil
+	case COMPRESS_GZIP:
+	default:
+		return fmt.Errorf(T("-compress must be 'gzip': %s"), config.compress)
+	}
+	if config.format != "csv" && config.format != "json" {
+		return errors.New(T("-compress needs -o csv or -o json"))
+	}
+	return nil
+}
+
+// the extension the object key's {ext} expands to
+func formatExt() string {
+	if config.compress == COMPRESS_GZIP {
+		return config.format + ".gz"
+	}
+	return config.format
+}
+
+// compress what is written to out, or nil without -compress. the parts of
+// a chunked export are gzip members of their own, which join into one
+// valid gzip stream.
+func compressWriter(out io.Writer) *gzip.Writer {
+	if config.compress != COMPRESS_GZIP {
+		return nil
+	}
+	return gzip.NewWriter(out)
+}
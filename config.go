@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// a flag which may be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// apply a JSON config file. keys are flag names and values are strings,
+// numbers, booleans or, for flags which may be repeated, arrays. strings
+// are expanded as templates, see expandTemplate.
+// flags given on the command line take precedence.
+func loadConfigFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	for name, value := range values {
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf(T("%s: unknown option: %s"), path, name)
+		}
+		if given[name] {
+			continue
+		}
+
+		var items []interface{}
+		if list, ok := value.([]interface{}); ok {
+			items = list
+		} else {
+			items = []interface{}{value}
+		}
+		for _, item := range items {
+			var s string
+			switch v := item.(type) {
+			case float64:
+				s = strconv.FormatFloat(v, 'f', -1, 64)
+			case string:
+				s, err = expandTemplate(v)
+				if err != nil {
+					return fmt.Errorf("%s: %s: %v", path, name, err)
+				}
+			default:
+				s = fmt.Sprint(v)
+			}
+			if err := flag.Set(name, s); err != nil {
+				return fmt.Errorf("%s: %s: %v", path, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// job configs are kept as NAME.json in the directory of KINTONE_TO_S3_JOBS,
+// and -config takes the NAME as well as a path
+func jobsDir() string {
+	return os.Getenv("KINTONE_TO_S3_JOBS")
+}
+
+func jobNames() ([]string, error) {
+	if jobsDir() == "" {
+		return nil, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(jobsDir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(paths))
+	for _, path := range paths {
+		names = append(names, strings.TrimSuffix(filepath.Base(path), ".json"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// the path of -config, which may name a job in KINTONE_TO_S3_JOBS
+func jobConfigPath(name string) string {
+	if jobsDir() == "" || strings.ContainsAny(name, `/\`) || filepath.Ext(name) != "" {
+		return name
+	}
+	path := filepath.Join(jobsDir(), name+".json")
+	if _, err := os.Stat(path); err != nil {
+		return name
+	}
+	return path
+}
+
+// a flag of a byte count with an optional unit: 512MB, 2GB, 65536
+type byteSize struct {
+	value *int64
+}
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KB", 1 << 10},
+	{"MB", 1 << 20},
+	{"GB", 1 << 30},
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"B", 1},
+}
+
+func (b *byteSize) String() string {
+	if b.value == nil {
+		return "0"
+	}
+	return strconv.FormatInt(*b.value, 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			unit = u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf(T("invalid size: %s"), s)
+	}
+	*b.value = n * unit
+	return nil
+}
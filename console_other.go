@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "strings"
+
+// the locale environment variables are all there is
+func systemLang() string {
+	return ""
+}
+
+func safeFileName(name string) string {
+	name = strings.Replace(name, "/", "_", -1)
+	if name == "" || name == "." || name == ".." {
+		name = "_" + name
+	}
+	return name
+}
@@ -0,0 +1,47 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"strings"
+	"syscall"
+)
+
+var kernel32 = syscall.NewLazyDLL("kernel32.dll")
+
+// Windows has no LANG, ask for the user's UI language instead
+func systemLang() string {
+	langId, _, _ := kernel32.NewProc("GetUserDefaultUILanguage").Call()
+	// primary language ID of Japanese
+	if langId&0x3ff == 0x11 {
+		return "ja"
+	}
+	return "en"
+}
+
+var reservedFileNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// attachment names are chosen by kintone users and may not be valid on Windows
+func safeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimRight(name, ". ")
+
+	base := name
+	if idx := strings.Index(base, "."); idx >= 0 {
+		base = base[:idx]
+	}
+	if name == "" || reservedFileNames[strings.ToUpper(base)] {
+		name = "_" + name
+	}
+	return name
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/glue"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/kintone/go-kintone"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// what -schema-contract does on a breaking change
+const (
+	SCHEMA_BREAKING_FAIL = "fail"
+	SCHEMA_BREAKING_WARN = "warn"
+)
+
+// -schema-contract glue://<database>/<table>
+const GLUE_CONTRACT_PREFIX = "glue://"
+
+// a schema contract: the columns downstream relies on and their kintone
+// field types. one is written from the export when the contract is missing.
+type schemaContract struct {
+	Columns []contractColumn `json:"columns"`
+}
+
+type contractColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+func validateSchemaContractOptions() error {
+	if config.schemaContract == "" {
+		return nil
+	}
+	switch config.schemaBreaking {
+	case SCHEMA_BREAKING_FAIL, SCHEMA_BREAKING_WARN:
+	default:
+		return fmt.Errorf(T("-schema-breaking must be 'fail' or 'warn': %s"), config.schemaBreaking)
+	}
+	if config.format != "csv" && config.format != "json" {
+		return errors.New(T("-schema-contract needs -o csv or -o json"))
+	}
+	return nil
+}
+
+// the columns of the CSV header, as writeCsv makes them
+func csvColumns(app *kintone.App) (Columns, error) {
+	fields, err := getFields(app)
+	if err != nil {
+		return nil, err
+	}
+	var columns Columns
+	if config.fields == nil {
+		columns = makeColumns(fields)
+	} else {
+		columns = makePartialColumns(fields, config.fields)
+	}
+	return append(columns, derivedColumns()...), nil
+}
+
+// the columns the export will have, in the order of the CSV header
+func exportColumns(app *kintone.App) ([]contractColumn, error) {
+	columns, err := csvColumns(app)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]contractColumn, 0, len(columns))
+	for _, c := range columns {
+		list = append(list, contractColumn{Name: c.Code, Type: c.Type})
+	}
+	return list, nil
+}
+
+// the Hive type of a column of the CSV export, as a Glue or Athena table
+// would declare it
+func hiveType(fieldType string) string {
+	switch fieldType {
+	case kintone.FT_ID, kintone.FT_REVISION:
+		return "bigint"
+	case kintone.FT_DECIMAL:
+		return "double"
+	case kintone.FT_DATE:
+		return "date"
+	case kintone.FT_DATETIME, kintone.FT_CTIME, kintone.FT_MTIME:
+		return "timestamp"
+	}
+	return "string"
+}
+
+func isNumericHiveType(t string) bool {
+	for _, prefix := range []string{"tinyint", "smallint", "int", "bigint", "float", "double", "decimal"} {
+		if strings.HasPrefix(t, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// whether a table declaring want still reads a column written as got
+func hiveTypeCompatible(want, got string) bool {
+	want = strings.ToLower(want)
+	return want == got || want == "string" || (isNumericHiveType(want) && isNumericHiveType(got))
+}
+
+func loadContractFile(source string) ([]byte, error) {
+	if strings.HasPrefix(source, "s3://") {
+		bucket, key, err := parseS3URL(source)
+		if err != nil {
+			return nil, err
+		}
+		svc, err := newS3Client()
+		if err != nil {
+			return nil, err
+		}
+		out, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+				return nil, nil
+			}
+			return nil, err
+		}
+		defer out.Body.Close()
+		return ioutil.ReadAll(out.Body)
+	}
+	data, err := ioutil.ReadFile(source)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func saveContractFile(source string, columns []contractColumn) error {
+	data, err := json.MarshalIndent(&schemaContract{Columns: columns}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(source, "s3://") {
+		return ioutil.WriteFile(source, data, 0644)
+	}
+	bucket, key, err := parseS3URL(source)
+	if err != nil {
+		return err
+	}
+	svc, err := newS3Client()
+	if err != nil {
+		return err
+	}
+	_, err = svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+// the columns of a Glue table, without its partition keys
+func loadGlueColumns(source string) ([]contractColumn, error) {
+	path := strings.TrimPrefix(source, GLUE_CONTRACT_PREFIX)
+	i := strings.Index(path, "/")
+	if i <= 0 || i == len(path)-1 {
+		return nil, fmt.Errorf(T("-schema-contract glue:// needs a database and a table: %s"), source)
+	}
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	out, err := glue.New(sess, newAwsConfig()).GetTable(&glue.GetTableInput{
+		DatabaseName: aws.String(path[:i]),
+		Name:         aws.String(path[i+1:]),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]contractColumn, 0)
+	if out.Table.StorageDescriptor != nil {
+		for _, c := range out.Table.StorageDescriptor.Columns {
+			columns = append(columns, contractColumn{Name: aws.StringValue(c.Name), Type: aws.StringValue(c.Type)})
+		}
+	}
+	return columns, nil
+}
+
+// the breaking changes of the export against the contract: columns gone,
+// which a rename in kintone looks like too, and columns of another type
+func breakingChanges(contract, columns []contractColumn, glueTypes bool) ([]string, []string) {
+	current := make(map[string]contractColumn, len(columns))
+	for _, c := range columns {
+		name := c.Name
+		if glueTypes {
+			// Glue keeps the column names in lower case
+			name = strings.ToLower(name)
+		}
+		current[name] = c
+	}
+
+	breaking := make([]string, 0)
+	known := make(map[string]bool, len(contract))
+	for _, want := range contract {
+		name := want.Name
+		if glueTypes {
+			name = strings.ToLower(name)
+		}
+		known[name] = true
+		got, ok := current[name]
+		switch {
+		case !ok:
+			breaking = append(breaking, fmt.Sprintf(T("column %s is gone"), want.Name))
+		case glueTypes && config.format == "csv" && !hiveTypeCompatible(want.Type, hiveType(got.Type)):
+			breaking = append(breaking, fmt.Sprintf(T("column %s is %s, the table has %s"), want.Name, hiveType(got.Type), want.Type))
+		case !glueTypes && want.Type != got.Type:
+			breaking = append(breaking, fmt.Sprintf(T("column %s is %s, the contract has %s"), want.Name, got.Type, want.Type))
+		}
+	}
+
+	added := make([]string, 0)
+	for name, c := range current {
+		if !known[name] {
+			added = append(added, c.Name)
+		}
+	}
+	sort.Strings(added)
+	return breaking, added
+}
+
+// compare the columns of the export with the contract before anything is
+// uploaded. -o json is compared by the field codes and, against a stored
+// contract, their types; a Glue table's types are of the CSV columns.
+func checkSchemaContract(app *kintone.App) error {
+	columns, err := exportColumns(app)
+	if err != nil {
+		return err
+	}
+
+	var contract []contractColumn
+	glueTypes := strings.HasPrefix(config.schemaContract, GLUE_CONTRACT_PREFIX)
+	if glueTypes {
+		if contract, err = loadGlueColumns(config.schemaContract); err != nil {
+			return err
+		}
+	} else {
+		data, err := loadContractFile(config.schemaContract)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			log.Printf(T("no schema contract at %s, writing the export's columns as the contract"), config.schemaContract)
+			return saveContractFile(config.schemaContract, columns)
+		}
+		var c schemaContract
+		if err := json.Unmarshal(data, &c); err != nil {
+			return fmt.Errorf("%s: %v", config.schemaContract, err)
+		}
+		contract = c.Columns
+	}
+
+	breaking, added := breakingChanges(contract, columns, glueTypes)
+	if len(added) > 0 {
+		log.Printf(T("columns not in the schema contract: %s"), strings.Join(added, ", "))
+	}
+	if len(breaking) == 0 {
+		return nil
+	}
+	for _, b := range breaking {
+		log.Printf(T("breaking change against %s: %s"), config.schemaContract, b)
+	}
+	if config.schemaBreaking == SCHEMA_BREAKING_WARN {
+		return nil
+	}
+	return fmt.Errorf(T("%d breaking changes against the schema contract %s"), len(breaking), config.schemaContract)
+}
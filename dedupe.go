@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"github.com/kintone/go-kintone"
+	"strings"
+)
+
+// make a key of the dedupe fields, records with the same key are duplicates
+func dedupeKey(record *kintone.Record) string {
+	values := make([]string, 0, len(config.dedupeBy))
+	for _, code := range config.dedupeBy {
+		values = append(values, toString(record.Fields[code], ","))
+	}
+	return strings.Join(values, "\x1f")
+}
+
+// kintone ignores unknown codes in fields[], which would give every record
+// the same empty key, and a subtable field has a value per row
+func validateDedupeFields(app *kintone.App) error {
+	fields, err := getFields(app)
+	if err != nil {
+		return err
+	}
+	for _, code := range config.dedupeBy {
+		if f, ok := fields[code]; ok {
+			if f.Type == kintone.FT_SUBTABLE {
+				return fmt.Errorf(T("-dedupe-by cannot take the subtable %s"), code)
+			}
+			continue
+		}
+		for _, f := range fields {
+			for _, sub := range f.Fields {
+				if sub.Code == code {
+					return fmt.Errorf(T("-dedupe-by cannot take %s in the subtable %s"), code, f.Code)
+				}
+			}
+		}
+		return fmt.Errorf(T("-dedupe-by: no field %s in the app"), code)
+	}
+	return nil
+}
+
+// scan the query once with only the fields needed and pick the record to keep
+// for each key: the newest revision, then the newest record. returns the
+// records to drop as duplicates, so a record created after the scan is kept,
+// or nil if deduplication is not requested.
+func dedupeFilter(app *kintone.App, query string) (map[uint64]bool, error) {
+	if len(config.dedupeBy) == 0 {
+		return nil, nil
+	}
+	if err := validateDedupeFields(app); err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		id       uint64
+		revision int64
+	}
+
+	fields := append([]string{"$id", "$revision"}, config.dedupeBy...)
+	best := make(map[string]candidate)
+	duplicates := make(map[uint64]bool)
+	for offset := int64(0); ; offset += EXPORT_ROW_LIMIT {
+		if err := interruption(); err != nil {
+			return nil, err
+		}
+		records, eof, err := getRecords(app, query, fields, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, record := range records {
+			key := dedupeKey(record)
+			c, ok := best[key]
+			if !ok || record.Revision() > c.revision || (record.Revision() == c.revision && record.Id() > c.id) {
+				if ok {
+					duplicates[c.id] = true
+				}
+				best[key] = candidate{id: record.Id(), revision: record.Revision()}
+			} else {
+				duplicates[record.Id()] = true
+			}
+		}
+		if eof {
+			break
+		}
+	}
+	return duplicates, nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kintone/go-kintone"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const FT_DERIVED = "DERIVED"
+
+// a computed output column, e.g. "full_name=concat(姓, \" \", 名)"
+type derivedColumn struct {
+	name string
+	expr derivedExpr
+}
+
+// an expression node: a field reference, a literal or a function call
+type derivedExpr struct {
+	field   string
+	literal *string
+	fn      string
+	args    []derivedExpr
+}
+
+type derivedFunc struct {
+	minArgs int
+	maxArgs int // -1 for variadic
+	call    func(args []string) string
+}
+
+var derivedFuncs = map[string]derivedFunc{
+	"concat": {1, -1, func(args []string) string {
+		return strings.Join(args, "")
+	}},
+	"coalesce": {1, -1, func(args []string) string {
+		for _, arg := range args {
+			if arg != "" {
+				return arg
+			}
+		}
+		return ""
+	}},
+	"upper": {1, 1, func(args []string) string { return strings.ToUpper(args[0]) }},
+	"lower": {1, 1, func(args []string) string { return strings.ToLower(args[0]) }},
+	"trim":  {1, 1, func(args []string) string { return strings.TrimSpace(args[0]) }},
+	"replace": {3, 3, func(args []string) string {
+		return strings.Replace(args[0], args[1], args[2], -1)
+	}},
+	// substr(value, start[, length]) in characters. a start or length read
+	// from a field which is no count gives an empty value.
+	"substr": {2, 3, func(args []string) string {
+		runes := []rune(args[0])
+		start, err := strconv.Atoi(args[1])
+		if err != nil || start < 0 || start > len(runes) {
+			return ""
+		}
+		end := len(runes)
+		if len(args) == 3 {
+			length, err := strconv.Atoi(args[2])
+			if err != nil || length < 0 {
+				return ""
+			}
+			if length < end-start {
+				end = start + length
+			}
+		}
+		return string(runes[start:end])
+	}},
+	"year": {1, 1, func(args []string) string {
+		return formatDatePart(args[0], func(t time.Time) int { return t.Year() })
+	}},
+	"month": {1, 1, func(args []string) string {
+		return formatDatePart(args[0], func(t time.Time) int { return int(t.Month()) })
+	}},
+	"quarter": {1, 1, func(args []string) string {
+		return formatDatePart(args[0], func(t time.Time) int { return (int(t.Month())-1)/3 + 1 })
+	}},
+	// fiscal_year(date, first month of the fiscal year), e.g. fiscal_year(受付日, 4).
+	// the month is checked to be 1 to 12 when the column is parsed
+	"fiscal_year": {2, 2, func(args []string) string {
+		start, _ := strconv.Atoi(args[1])
+		return formatDatePart(args[0], func(t time.Time) int {
+			if int(t.Month()) < start {
+				return t.Year() - 1
+			}
+			return t.Year()
+		})
+	}},
+	"fiscal_quarter": {2, 2, func(args []string) string {
+		start, _ := strconv.Atoi(args[1])
+		return formatDatePart(args[0], func(t time.Time) int {
+			return ((int(t.Month())-start+12)%12)/3 + 1
+		})
+	}},
+}
+
+// value of a DATE or DATETIME field
+func parseDateValue(s string) (time.Time, bool) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.Local(), true
+	}
+	return time.Time{}, false
+}
+
+func formatDatePart(s string, part func(time.Time) int) string {
+	t, ok := parseDateValue(s)
+	if !ok {
+		return ""
+	}
+	return strconv.Itoa(part(t))
+}
+
+func parseDerivedColumn(def string) (*derivedColumn, error) {
+	idx := strings.Index(def, "=")
+	if idx <= 0 {
+		return nil, fmt.Errorf(T("derived column must be name=expression: %s"), def)
+	}
+	name := strings.TrimSpace(def[:idx])
+
+	p := &exprParser{src: []rune(def[idx+1:])}
+	expr, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf(T("derived column %s: %v"), name, err)
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, fmt.Errorf(T("derived column %s: unexpected %q"), name, string(p.src[p.pos:]))
+	}
+	return &derivedColumn{name: name, expr: expr}, nil
+}
+
+type exprParser struct {
+	src []rune
+	pos int
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_$.-・＄￥", r)
+}
+
+func (p *exprParser) parse() (derivedExpr, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return derivedExpr{}, errors.New(T("unexpected end of expression"))
+	}
+
+	if p.src[p.pos] == '"' {
+		p.pos++
+		var b strings.Builder
+		for p.pos < len(p.src) && p.src[p.pos] != '"' {
+			if p.src[p.pos] == '\\' && p.pos+1 < len(p.src) {
+				p.pos++
+			}
+			b.WriteRune(p.src[p.pos])
+			p.pos++
+		}
+		if p.pos >= len(p.src) {
+			return derivedExpr{}, errors.New(T("unterminated string"))
+		}
+		p.pos++
+		s := b.String()
+		return derivedExpr{literal: &s}, nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.src) && isIdentRune(p.src[p.pos]) {
+		p.pos++
+	}
+	if start == p.pos {
+		return derivedExpr{}, fmt.Errorf(T("unexpected %q"), string(p.src[p.pos]))
+	}
+	ident := string(p.src[start:p.pos])
+
+	p.skipSpace()
+	if p.pos >= len(p.src) || p.src[p.pos] != '(' {
+		// numbers are literals, anything else is a field code
+		if _, err := strconv.ParseFloat(ident, 64); err == nil {
+			return derivedExpr{literal: &ident}, nil
+		}
+		return derivedExpr{field: ident}, nil
+	}
+
+	fn, ok := derivedFuncs[ident]
+	if !ok {
+		return derivedExpr{}, fmt.Errorf(T("unknown function: %s"), ident)
+	}
+	p.pos++
+
+	expr := derivedExpr{fn: ident}
+	for {
+		p.skipSpace()
+		if p.pos < len(p.src) && p.src[p.pos] == ')' && len(expr.args) == 0 {
+			p.pos++
+			break
+		}
+		arg, err := p.parse()
+		if err != nil {
+			return derivedExpr{}, err
+		}
+		expr.args = append(expr.args, arg)
+
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return derivedExpr{}, fmt.Errorf(T("missing ) after arguments of %s"), ident)
+		}
+		if p.src[p.pos] == ')' {
+			p.pos++
+			break
+		}
+		if p.src[p.pos] != ',' {
+			return derivedExpr{}, fmt.Errorf(T("unexpected %q in arguments of %s"), string(p.src[p.pos]), ident)
+		}
+		p.pos++
+	}
+
+	if len(expr.args) < fn.minArgs || (fn.maxArgs >= 0 && len(expr.args) > fn.maxArgs) {
+		return derivedExpr{}, fmt.Errorf(T("wrong number of arguments for %s"), ident)
+	}
+	if ident == "substr" {
+		for _, arg := range expr.args[1:] {
+			if arg.literal == nil {
+				continue
+			}
+			if n, err := strconv.Atoi(*arg.literal); err != nil || n < 0 {
+				return derivedExpr{}, fmt.Errorf(T("the start and length of substr must be integers of 0 or more: %s"), *arg.literal)
+			}
+		}
+	}
+	if ident == "fiscal_year" || ident == "fiscal_quarter" {
+		// the first month is a constant of the column
+		month := expr.args[1].literal
+		if month == nil {
+			return derivedExpr{}, fmt.Errorf(T("the first month of %s must be a number from 1 to 12"), ident)
+		}
+		if n, err := strconv.Atoi(*month); err != nil || n < 1 || n > 12 {
+			return derivedExpr{}, fmt.Errorf(T("the first month of %s must be a number from 1 to 12: %s"), ident, *month)
+		}
+	}
+	return expr, nil
+}
+
+func (e derivedExpr) eval(record *kintone.Record) string {
+	if e.literal != nil {
+		return *e.literal
+	}
+	if e.fn == "" {
+		switch e.field {
+		case "$id":
+			return strconv.FormatUint(record.Id(), 10)
+		case "$revision":
+			return strconv.FormatInt(record.Revision(), 10)
+		}
+		return toString(record.Fields[e.field], ",")
+	}
+
+	args := make([]string, len(e.args))
+	for i, arg := range e.args {
+		args[i] = arg.eval(record)
+	}
+	return derivedFuncs[e.fn].call(args)
+}
+
+// field codes the expression reads
+func (e derivedExpr) fields() []string {
+	if e.literal != nil {
+		return nil
+	}
+	if e.fn == "" {
+		return []string{e.field}
+	}
+	codes := make([]string, 0)
+	for _, arg := range e.args {
+		codes = append(codes, arg.fields()...)
+	}
+	return codes
+}
+
+func derivedColumns() Columns {
+	columns := make([]*Column, 0, len(config.derived))
+	for _, d := range config.derived {
+		columns = append(columns, &Column{Code: d.name, Type: FT_DERIVED})
+	}
+	if config.recordHash {
+		columns = append(columns, &Column{Code: RECORD_HASH_COLUMN, Type: FT_DERIVED})
+	}
+	return columns
+}
+
+func evalDerived(record *kintone.Record) map[string]string {
+	values := make(map[string]string, len(config.derived))
+	for _, d := range config.derived {
+		values[d.name] = d.expr.eval(record)
+	}
+	if config.recordHash {
+		values[RECORD_HASH_COLUMN] = recordHash(record, values)
+	}
+	return values
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/text/encoding"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+const (
+	ENCODING_ERRORS_FAIL    = "fail"
+	ENCODING_ERRORS_REPLACE = "replace"
+	ENCODING_ERRORS_REPORT  = "report"
+)
+
+func validateEncodingErrors(mode string) error {
+	switch mode {
+	case ENCODING_ERRORS_FAIL, ENCODING_ERRORS_REPLACE, ENCODING_ERRORS_REPORT:
+		return nil
+	}
+	return fmt.Errorf(T("unknown -encoding-errors mode: %s"), mode)
+}
+
+// whether the output encoding may be unable to represent a character
+func lossyEncoding() bool {
+	return getEncoding() != nil && !strings.HasPrefix(config.encoding, "utf-")
+}
+
+// results of encodable, shared by the objects of a split export
+var encodableRunes sync.Map
+
+func encodable(enc encoding.Encoding, r rune) bool {
+	if r < utf8.RuneSelf {
+		return true
+	}
+	if ok, found := encodableRunes.Load(r); found {
+		return ok.(bool)
+	}
+	_, err := enc.NewEncoder().String(string(r))
+	encodableRunes.Store(r, err == nil)
+	return err == nil
+}
+
+// write UTF-8 text of the record id (a CSV row or a JSON record) to the
+// encoding writer, dealing with the characters the output encoding cannot
+// represent as -encoding-errors says. without this the encoder stops at
+// the first such character and the rest of the row is lost.
+func writeEncoded(w io.Writer, text []byte, id uint64) error {
+	if !lossyEncoding() {
+		w.Write(text)
+		return nil
+	}
+
+	enc := getEncoding()
+	var b *bytes.Buffer
+	lost := make([]string, 0)
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRune(text[i:])
+		if !encodable(enc, r) {
+			if config.encodingErrors == ENCODING_ERRORS_FAIL {
+				return fmt.Errorf(T("record %d: %q cannot be encoded in %s"), id, r, config.encoding)
+			}
+			if b == nil {
+				b = bytes.NewBuffer(make([]byte, 0, len(text)))
+				b.Write(text[:i])
+			}
+			b.WriteString(config.encodingPlaceholder)
+			lost = append(lost, string(r))
+		} else if b != nil {
+			b.Write(text[i : i+size])
+		}
+		i += size
+	}
+
+	if b == nil {
+		w.Write(text)
+		return nil
+	}
+	w.Write(b.Bytes())
+
+	atomic.AddUint64(&run.LossyRecords, 1)
+	if config.encodingErrors == ENCODING_ERRORS_REPORT {
+		log.Printf(T("record %d: replaced %s which cannot be encoded in %s"), id, strings.Join(lost, " "), config.encoding)
+		runMu.Lock()
+		run.LossyRecordIds = append(run.LossyRecordIds, id)
+		runMu.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// -envelope-kms-key-id encrypts each object with its own AES-256 data key
+// from KMS. the data key, encrypted by KMS, goes into the object metadata.
+// the payload is cut into segments sealed with AES-GCM, so it is encrypted
+// while it streams to S3; the nonce of a segment is the object's random
+// prefix, the segment number and a flag set on the last segment, which
+// makes a truncated object fail to decrypt.
+const (
+	ENVELOPE_ALGORITHM    = "AES-256-GCM-STREAM"
+	ENVELOPE_SEGMENT_SIZE = 64 << 10
+	ENVELOPE_PREFIX_SIZE  = 7
+)
+
+// object metadata of an encrypted export
+const (
+	META_ENVELOPE_ALGORITHM = "envelope-algorithm"
+	META_ENVELOPE_KEY       = "envelope-key"
+	META_ENVELOPE_KMS_KEY   = "envelope-kms-key-id"
+	META_ENVELOPE_PREFIX    = "envelope-nonce-prefix"
+	META_ENVELOPE_SEGMENT   = "envelope-segment-size"
+)
+
+var envelopeKMS *kms.KMS
+
+func validateEnvelopeOptions() error {
+	if config.envelopeKmsKeyId == "" {
+		return nil
+	}
+	if chunked() {
+		// the parts are joined by concatenating them
+		return errors.New(T("-envelope-kms-key-id cannot be combined with -chunk-records or -chunk-time"))
+	}
+	if config.format == "arrow" {
+		return errors.New(T("-envelope-kms-key-id does not apply to -o arrow, which is written to standard output"))
+	}
+	return nil
+}
+
+func newKMSClient() (*kms.KMS, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	return kms.New(sess, newAwsConfig()), nil
+}
+
+// writes sealed segments of what is written to it to out
+type envelopeWriter struct {
+	out      io.Writer
+	aead     cipher.AEAD
+	prefix   []byte
+	segment  uint32
+	buf      []byte
+	metadata map[string]*string
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// start encrypting into out with a new data key
+func newEnvelopeWriter(out io.Writer) (*envelopeWriter, error) {
+	key, err := envelopeKMS.GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:   aws.String(config.envelopeKmsKeyId),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, ENVELOPE_PREFIX_SIZE)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+
+	return &envelopeWriter{
+		out:    out,
+		aead:   aead,
+		prefix: prefix,
+		buf:    make([]byte, 0, ENVELOPE_SEGMENT_SIZE*2),
+		metadata: map[string]*string{
+			META_ENVELOPE_ALGORITHM: aws.String(ENVELOPE_ALGORITHM),
+			META_ENVELOPE_KEY:       aws.String(base64.StdEncoding.EncodeToString(key.CiphertextBlob)),
+			META_ENVELOPE_KMS_KEY:   key.KeyId,
+			META_ENVELOPE_PREFIX:    aws.String(base64.StdEncoding.EncodeToString(prefix)),
+			META_ENVELOPE_SEGMENT:   aws.String(strconv.Itoa(ENVELOPE_SEGMENT_SIZE)),
+		},
+	}, nil
+}
+
+func envelopeNonce(prefix []byte, segment uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[ENVELOPE_PREFIX_SIZE:], segment)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func (e *envelopeWriter) seal(data []byte, last bool) error {
+	if e.segment == ^uint32(0) {
+		return errors.New(T("too large to encrypt"))
+	}
+	sealed := e.aead.Seal(nil, envelopeNonce(e.prefix, e.segment, last), data, nil)
+	e.segment += 1
+	_, err := e.out.Write(sealed)
+	return err
+}
+
+// a full segment is held back until more follows, as the last one is
+// only known at Close
+func (e *envelopeWriter) Write(p []byte) (int, error) {
+	e.buf = append(e.buf, p...)
+	for len(e.buf) > ENVELOPE_SEGMENT_SIZE {
+		if err := e.seal(e.buf[:ENVELOPE_SEGMENT_SIZE], false); err != nil {
+			return 0, err
+		}
+		e.buf = append(e.buf[:0], e.buf[ENVELOPE_SEGMENT_SIZE:]...)
+	}
+	return len(p), nil
+}
+
+func (e *envelopeWriter) Close() error {
+	return e.seal(e.buf, true)
+}
+
+// add the envelope's metadata to the object's
+func (e *envelopeWriter) wrapMetadata(metadata func(bool) map[string]*string) func(bool) map[string]*string {
+	return func(complete bool) map[string]*string {
+		m := make(map[string]*string)
+		if metadata != nil {
+			m = metadata(complete)
+		}
+		for k, v := range e.metadata {
+			m[k] = v
+		}
+		return m
+	}
+}
+
+// encrypt what is written to upload when -envelope-kms-key-id is set; nil
+// otherwise
+func encryptUpload(upload *uploadPipeline) (*envelopeWriter, error) {
+	if config.envelopeKmsKeyId == "" {
+		return nil, nil
+	}
+	env, err := newEnvelopeWriter(upload)
+	if err != nil {
+		return nil, err
+	}
+	upload.metadata = env.wrapMetadata(upload.metadata)
+	return env, nil
+}
+
+// decrypt an object written with -envelope-kms-key-id to out
+func decryptObject(svc *s3.S3, key string, out io.Writer) error {
+	obj, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(config.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	defer obj.Body.Close()
+
+	meta := func(name string) string {
+		// the SDK returns the names capitalized: Envelope-Key
+		for k, v := range obj.Metadata {
+			if strings.EqualFold(k, name) {
+				return aws.StringValue(v)
+			}
+		}
+		return ""
+	}
+	if algorithm := meta(META_ENVELOPE_ALGORITHM); algorithm != ENVELOPE_ALGORITHM {
+		return fmt.Errorf(T("s3://%s/%s is not encrypted with %s"), config.bucketName, key, ENVELOPE_ALGORITHM)
+	}
+	blob, err := base64.StdEncoding.DecodeString(meta(META_ENVELOPE_KEY))
+	if err != nil {
+		return err
+	}
+	prefix, err := base64.StdEncoding.DecodeString(meta(META_ENVELOPE_PREFIX))
+	if err != nil || len(prefix) != ENVELOPE_PREFIX_SIZE {
+		return fmt.Errorf(T("invalid %s: %s"), META_ENVELOPE_PREFIX, meta(META_ENVELOPE_PREFIX))
+	}
+	segmentSize, err := strconv.Atoi(meta(META_ENVELOPE_SEGMENT))
+	if err != nil || segmentSize <= 0 {
+		return fmt.Errorf(T("invalid %s: %s"), META_ENVELOPE_SEGMENT, meta(META_ENVELOPE_SEGMENT))
+	}
+
+	dataKey, err := envelopeKMS.Decrypt(&kms.DecryptInput{CiphertextBlob: blob})
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(dataKey.Plaintext)
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(obj.Body)
+	sealed := make([]byte, segmentSize+aead.Overhead())
+	for segment := uint32(0); ; segment++ {
+		n, err := io.ReadFull(r, sealed)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			if err == io.EOF {
+				// the last segment is never empty, it has the tag at least
+				err = errors.New(T("encrypted object is truncated"))
+			}
+			return err
+		}
+		last := err == io.ErrUnexpectedEOF
+		if !last {
+			_, peekErr := r.Peek(1)
+			last = peekErr == io.EOF
+		}
+		data, err := aead.Open(nil, envelopeNonce(prefix, segment, last), sealed[:n], nil)
+		if err != nil {
+			return fmt.Errorf(T("segment %d: %v"), segment, err)
+		}
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// decrypt <key>
+func decryptCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New(T("usage: decrypt <key>"))
+	}
+	svc, err := newS3Client()
+	if err != nil {
+		return err
+	}
+	if envelopeKMS, err = newKMSClient(); err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	if err := decryptObject(svc, args[0], w); err != nil {
+		return err
+	}
+	return w.Flush()
+}
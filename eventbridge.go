@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"log"
+	"time"
+)
+
+// the source and detail types of the events put on -event-bus
+const (
+	EVENT_SOURCE           = "golang-kintone-to-s3"
+	EVENT_EXPORT_COMPLETED = "kintone-to-s3.export.completed"
+	EVENT_EXPORT_FAILED    = "kintone-to-s3.export.failed"
+)
+
+// put an event of the finished run on -event-bus, the run record as its
+// detail, for rules starting the pipelines after the export
+func putRunEvent(err error) {
+	if config.eventBus == "" {
+		return
+	}
+	detailType := EVENT_EXPORT_COMPLETED
+	if err != nil || run.Status == RUN_FAILED {
+		detailType = EVENT_EXPORT_FAILED
+	}
+	detail, _ := json.Marshal(&run)
+
+	sess, perr := session.NewSession()
+	if perr == nil {
+		var out *eventbridge.PutEventsOutput
+		out, perr = eventbridge.New(sess, newAwsConfig()).PutEvents(&eventbridge.PutEventsInput{
+			Entries: []*eventbridge.PutEventsRequestEntry{{
+				EventBusName: aws.String(config.eventBus),
+				Source:       aws.String(EVENT_SOURCE),
+				DetailType:   aws.String(detailType),
+				Detail:       aws.String(string(detail)),
+				Time:         aws.Time(time.Now()),
+			}},
+		})
+		if perr == nil && aws.Int64Value(out.FailedEntryCount) > 0 {
+			entry := out.Entries[0]
+			perr = errors.New(aws.StringValue(entry.ErrorCode) + ": " + aws.StringValue(entry.ErrorMessage))
+		}
+	}
+	if perr != nil {
+		log.Printf(T("could not put the event on %s: %v"), config.eventBus, perr)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kintone/go-kintone"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the failed attachments, kept in the -b directory for retry-failures
+const ATTACHMENT_FAILURES_FILE = "_failures.json"
+
+// tries of an attachment before it fails or is queued
+const ATTACHMENT_TRIES = 3
+
+// an attachment which could not be downloaded. the file key stays valid as
+// long as the file is in the record.
+type attachmentFailure struct {
+	FileKey  string    `json:"file_key"`
+	Dir      string    `json:"dir"`
+	Name     string    `json:"name"`
+	RunId    string    `json:"run_id"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+	Tries    int       `json:"tries"`
+}
+
+// the failures of this run, saved when the attachments are published
+var attachmentFailures struct {
+	sync.Mutex
+	list []attachmentFailure
+}
+
+func attachmentFailuresPath() string {
+	return filepath.Join(config.fileDir, ATTACHMENT_FAILURES_FILE)
+}
+
+// download an attachment to path, trying again with backoff
+func downloadAttachment(app *kintone.App, fileKey, path string) error {
+	delay := time.Second
+	var err error
+	for try := 1; ; try++ {
+		var data *kintone.FileData
+		err = waitMaintenance(func() (err error) {
+			data, err = app.Download(fileKey)
+			return err
+		})
+		if err == nil {
+			started := time.Now()
+			var n int64
+			n, err = saveFile(path, data.Reader)
+			if err == nil {
+				addStage(STAGE_DOWNLOAD, time.Since(started), 0, n)
+				return nil
+			}
+			os.Remove(path)
+		}
+		if try == ATTACHMENT_TRIES {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// queue an attachment which failed its tries, instead of failing the export
+func queueAttachmentFailure(fileKey, dir, name string, err error) {
+	log.Printf(T("could not download %s, queued for retry-failures: %v"), filepath.Join(dir, name), err)
+	atomic.AddUint64(&run.AttachmentFailures, 1)
+	attachmentFailures.Lock()
+	defer attachmentFailures.Unlock()
+	attachmentFailures.list = append(attachmentFailures.list, attachmentFailure{
+		FileKey:  fileKey,
+		Dir:      dir,
+		Name:     name,
+		RunId:    run.Id,
+		Error:    err.Error(),
+		FailedAt: time.Now(),
+		Tries:    ATTACHMENT_TRIES,
+	})
+}
+
+func loadAttachmentFailures() ([]attachmentFailure, error) {
+	data, err := ioutil.ReadFile(attachmentFailuresPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []attachmentFailure
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: %v", attachmentFailuresPath(), err)
+	}
+	return list, nil
+}
+
+// write the list, or remove it once empty
+func writeAttachmentFailures(list []attachmentFailure) error {
+	if len(list) == 0 {
+		if err := os.Remove(attachmentFailuresPath()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(config.fileDir, 0777); err != nil {
+		return err
+	}
+	tmp := attachmentFailuresPath() + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, attachmentFailuresPath())
+}
+
+// add the failures of the run to the list in the -b directory. a queued
+// attachment of a record exported again is replaced by the new entry.
+func saveAttachmentFailures() error {
+	attachmentFailures.Lock()
+	defer attachmentFailures.Unlock()
+	list, err := loadAttachmentFailures()
+	if err != nil {
+		return err
+	}
+	if len(attachmentFailures.list) == 0 && len(list) == 0 {
+		return nil
+	}
+
+	// the directories published by this run replace the earlier ones
+	kept := make([]attachmentFailure, 0, len(list))
+	for _, f := range list {
+		if _, err := os.Stat(filepath.Join(attachmentStagingDir(), f.Dir)); os.IsNotExist(err) {
+			kept = append(kept, f)
+		}
+	}
+	kept = append(kept, attachmentFailures.list...)
+	return writeAttachmentFailures(kept)
+}
+
+// retry-failures: download the queued attachments again into the -b
+// directory, keeping those which fail again on the list
+func retryFailuresCommand(app *kintone.App) error {
+	if config.fileDir == "" {
+		return errors.New(T("retry-failures needs the -b directory of the export"))
+	}
+	list, err := loadAttachmentFailures()
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		log.Printf(T("no failed attachments in %s"), config.fileDir)
+		return nil
+	}
+
+	remaining := make([]attachmentFailure, 0)
+	for _, f := range list {
+		dir := filepath.Join(config.fileDir, f.Dir)
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return err
+		}
+		if err := downloadAttachment(app, f.FileKey, filepath.Join(dir, f.Name)); err != nil {
+			log.Printf(T("could not download %s: %v"), filepath.Join(f.Dir, f.Name), err)
+			f.Error = err.Error()
+			f.FailedAt = time.Now()
+			f.Tries += ATTACHMENT_TRIES
+			remaining = append(remaining, f)
+			continue
+		}
+		log.Printf(T("downloaded %s"), filepath.Join(f.Dir, f.Name))
+	}
+	if err := writeAttachmentFailures(remaining); err != nil {
+		return err
+	}
+	if len(remaining) > 0 {
+		return fmt.Errorf(T("%d of %d attachments failed again"), len(remaining), len(list))
+	}
+	return nil
+}
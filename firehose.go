@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/kintone/go-kintone"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// the limits of a PutRecordBatch request and of a record
+const (
+	FIREHOSE_BATCH_RECORDS = 500
+	FIREHOSE_BATCH_BYTES   = 4 << 20
+	FIREHOSE_RECORD_BYTES  = 1000 << 10
+)
+
+func validateFirehoseOptions() error {
+	if config.firehoseStream == "" {
+		return nil
+	}
+	if config.format != "json" {
+		return errors.New(T("-firehose-stream needs -o json"))
+	}
+	if config.encoding != "utf-8" {
+		return errors.New(T("-firehose-stream sends UTF-8 only"))
+	}
+	if config.splitBy != "" || partitionColumns != nil || config.jsonDocumentRecords > 0 || chunked() || config.appendMode || config.output != "" || config.pipe != "" || config.compress != "" || config.envelopeKmsKeyId != "" || config.truncateText > 0 {
+		return errors.New(T("-firehose-stream cannot be combined with -split-by, -partition-by, -json-document-records, -chunk-records, -append, -output, -pipe, -compress, -envelope-kms-key-id or -truncate-text"))
+	}
+	return nil
+}
+
+// the records of a PutRecordBatch request waiting to be sent
+type firehoseBatch struct {
+	svc     *firehose.Firehose
+	records []*firehose.Record
+	size    int
+}
+
+func (b *firehoseBatch) add(data []byte) error {
+	if len(b.records) == FIREHOSE_BATCH_RECORDS || b.size+len(data) > FIREHOSE_BATCH_BYTES {
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+	b.records = append(b.records, &firehose.Record{Data: data})
+	b.size += len(data)
+	return nil
+}
+
+// send the batch, sending the records Firehose failed to take again with
+// backoff up to -upload-retries times
+func (b *firehoseBatch) flush() error {
+	pending := b.records
+	delay := time.Second
+	for attempt := 0; len(pending) > 0; attempt++ {
+		out, err := b.svc.PutRecordBatch(&firehose.PutRecordBatchInput{
+			DeliveryStreamName: aws.String(config.firehoseStream),
+			Records:            pending,
+		})
+		if err != nil {
+			return err
+		}
+		if aws.Int64Value(out.FailedPutCount) == 0 {
+			break
+		}
+		failed := make([]*firehose.Record, 0, aws.Int64Value(out.FailedPutCount))
+		message := ""
+		for i, r := range out.RequestResponses {
+			if r.ErrorCode != nil {
+				failed = append(failed, pending[i])
+				message = aws.StringValue(r.ErrorCode) + ": " + aws.StringValue(r.ErrorMessage)
+			}
+		}
+		if attempt == config.uploadRetries {
+			return fmt.Errorf(T("Firehose did not take %d records: %s"), len(failed), message)
+		}
+		pending = failed
+		time.Sleep(delay)
+		if delay < time.Minute {
+			delay *= 2
+		}
+	}
+	for _, r := range b.records {
+		atomic.AddInt64(&run.Bytes, int64(len(r.Data)))
+	}
+	b.records = b.records[:0]
+	b.size = 0
+	return nil
+}
+
+// push the records into -firehose-stream as NDJSON, one record of the
+// stream per kintone record, instead of writing an object. Firehose does
+// the buffering, partitioning and format conversion.
+func exportFirehose(app *kintone.App) error {
+	startRun()
+	run.Destination = "firehose://" + config.firehoseStream
+
+	err := func() error {
+		unlock, err := openState()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		sess, err := session.NewSession()
+		if err != nil {
+			return err
+		}
+		batch := &firehoseBatch{svc: firehose.New(sess, newAwsConfig())}
+
+		if err := createWorkdir(); err != nil {
+			return err
+		}
+		defer removeWorkdir()
+
+		if run.TotalCount, err = fetchTotalCount(); err != nil {
+			return err
+		}
+
+		query := config.query
+		if config.canonicalJSON {
+			query = canonicalQuery(query)
+		}
+		duplicates, err := dedupeFilter(app, query)
+		if err != nil {
+			return err
+		}
+
+		pages := fetchPages(app, query, fetchFields())
+		defer pages.stop()
+		for {
+			records, err := pages.next()
+			if err != nil {
+				return err
+			}
+			if records == nil {
+				break
+			}
+			started := time.Now()
+			for _, record := range records {
+				if duplicates[record.Id()] {
+					atomic.AddUint64(&run.Duplicates, 1)
+					continue
+				}
+				if redactRecord(record) {
+					continue
+				}
+				for name, value := range evalDerived(record) {
+					record.Fields[name] = kintone.SingleLineTextField(value)
+				}
+				data, err := record.MarshalJSON()
+				if err != nil {
+					return err
+				}
+				if config.canonicalJSON {
+					if data, err = canonicalJSON(data); err != nil {
+						return err
+					}
+				}
+				data = append(data, '\n')
+				if len(data) > FIREHOSE_RECORD_BYTES {
+					return fmt.Errorf(T("record %d is larger than the 1000 KiB of a Firehose record"), record.Id())
+				}
+				if err := batch.add(data); err != nil {
+					return err
+				}
+				atomic.AddUint64(&run.Records, 1)
+			}
+			addStage(STAGE_SERIALIZE, time.Since(started), uint64(len(records)), 0)
+		}
+		if err := batch.flush(); err != nil {
+			return err
+		}
+		log.Printf(T("%d records sent to Firehose stream %s"), run.Records, config.firehoseStream)
+		// the records are delivered already, a short export still fails the run
+		if err := reconcileRecordCount(); err != nil {
+			return err
+		}
+
+		if config.fileDir != "" {
+			return publishAttachments()
+		}
+		return nil
+	}()
+	endRun(err)
+	return err
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/glue"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/kintone/go-kintone"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// the partitions a BatchCreatePartition request takes
+const GLUE_BATCH_PARTITIONS = 100
+
+// the column of the CSV's first "*" column, which marks the first row of a
+// record with subtables
+const GLUE_RECORD_START_COLUMN = "record_start"
+
+func parseGlueTable(name string) (string, string, error) {
+	i := strings.Index(name, "/")
+	if i <= 0 || i == len(name)-1 {
+		return "", "", fmt.Errorf(T("-glue-table needs <database>/<table>: %s"), name)
+	}
+	return name[:i], name[i+1:], nil
+}
+
+func validateGlueOptions() error {
+	if config.glueTable == "" {
+		return nil
+	}
+	if _, _, err := parseGlueTable(config.glueTable); err != nil {
+		return err
+	}
+	if config.format != "csv" || config.encoding != "utf-8" {
+		return errors.New(T("-glue-table needs -o csv and -e utf-8"))
+	}
+	if localOutput() || config.firehoseStream != "" || config.pipe != "" || config.envelopeKmsKeyId != "" {
+		return errors.New(T("-glue-table cannot be combined with -output, -firehose-stream, -pipe or -envelope-kms-key-id"))
+	}
+	if glueLocation() == "" {
+		return errors.New(T("-glue-table needs a key under a prefix of its own, e.g. -k exports/{appId}/records.csv"))
+	}
+	return nil
+}
+
+// the prefix the table reads: the directory of -partition-by or -append,
+// else the directory of the key. every object under it is a row source,
+// but those named _* or .* which Hive skips.
+func glueLocation() string {
+	if config.appendMode {
+		return strings.TrimSuffix(config.key, path.Ext(config.key)) + "/"
+	}
+	dir := path.Dir(config.key)
+	if partitionColumns != nil && config.splitBy == "" {
+		// the key is the part of the one partition already
+		for range partitionColumns {
+			dir = path.Dir(dir)
+		}
+	}
+	if dir == "." {
+		return ""
+	}
+	return dir + "/"
+}
+
+// the names of the key=value directories under the location
+func gluePartitionPaths() []string {
+	if partitionColumns != nil {
+		return partitionColumns
+	}
+	if config.appendMode {
+		return []string{PARTITION_DATE}
+	}
+	return nil
+}
+
+// the table of the CSV export: the columns in the order of the header,
+// typed as hiveType, and the partition keys of the layout
+func glueTableInput(app *kintone.App, table string) (*glue.TableInput, error) {
+	fields, err := csvColumns(app)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]*glue.Column, 0, len(fields)+1)
+	names := make(map[string]bool)
+	if hasSubTable(fields) {
+		columns = append(columns, &glue.Column{Name: aws.String(GLUE_RECORD_START_COLUMN), Type: aws.String("string")})
+		names[GLUE_RECORD_START_COLUMN] = true
+	}
+	for _, f := range fields {
+		// Glue keeps the names in lower case
+		name := strings.ToLower(f.Code)
+		columns = append(columns, &glue.Column{
+			Name:    aws.String(name),
+			Type:    aws.String(hiveType(f.Type)),
+			Comment: aws.String(f.Type),
+		})
+		names[name] = true
+	}
+
+	// the partitions are registered with their locations, so a key can
+	// differ from its directory where the column is in the CSV too
+	keys := make([]*glue.Column, 0)
+	for _, p := range gluePartitionPaths() {
+		name := strings.ToLower(p)
+		if names[name] {
+			name += "_partition"
+		}
+		keys = append(keys, &glue.Column{Name: aws.String(name), Type: aws.String("string")})
+	}
+
+	return &glue.TableInput{
+		Name:          aws.String(table),
+		Description:   aws.String(fmt.Sprintf("kintone app %d of %s", config.appId, config.domain)),
+		TableType:     aws.String("EXTERNAL_TABLE"),
+		PartitionKeys: keys,
+		Parameters: map[string]*string{
+			"classification":         aws.String("csv"),
+			"skip.header.line.count": aws.String("1"),
+			"EXTERNAL":               aws.String("TRUE"),
+		},
+		StorageDescriptor: &glue.StorageDescriptor{
+			Columns:      columns,
+			Location:     aws.String("s3://" + config.bucketName + "/" + glueLocation()),
+			InputFormat:  aws.String("org.apache.hadoop.mapred.TextInputFormat"),
+			OutputFormat: aws.String("org.apache.hadoop.hive.ql.io.HiveIgnoreKeyTextOutputFormat"),
+			SerdeInfo: &glue.SerDeInfo{
+				SerializationLibrary: aws.String("org.apache.hadoop.hive.serde2.OpenCSVSerde"),
+				// quotes in a value are doubled, as in RFC 4180
+				Parameters: map[string]*string{
+					"separatorChar": aws.String(","),
+					"quoteChar":     aws.String(`"`),
+					"escapeChar":    aws.String(`"`),
+				},
+			},
+		},
+	}, nil
+}
+
+// the partition values under the location, each with its directory
+func listGluePartitions(svc *s3.S3) (map[string][]string, error) {
+	paths := gluePartitionPaths()
+	location := glueLocation()
+	partitions := make(map[string][]string)
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(config.bucketName),
+		Prefix: aws.String(location),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			segments := strings.Split(strings.TrimPrefix(aws.StringValue(obj.Key), location), "/")
+			if len(segments) != len(paths)+1 {
+				continue
+			}
+			if name := segments[len(paths)]; strings.HasPrefix(name, "_") || strings.HasPrefix(name, ".") {
+				continue
+			}
+			values := make([]string, 0, len(paths))
+			for i, p := range paths {
+				if !strings.HasPrefix(segments[i], p+"=") {
+					break
+				}
+				value, err := url.PathUnescape(strings.TrimPrefix(segments[i], p+"="))
+				if err != nil {
+					break
+				}
+				values = append(values, value)
+			}
+			if len(values) == len(paths) {
+				partitions[location+strings.Join(segments[:len(paths)], "/")+"/"] = values
+			}
+		}
+		return true
+	})
+	return partitions, err
+}
+
+// create or update -glue-table over the export, and register the
+// partitions written so far, so Athena can query it at once
+func registerGlueTable(app *kintone.App, svc *s3.S3) error {
+	database, table, err := parseGlueTable(config.glueTable)
+	if err != nil {
+		return err
+	}
+	input, err := glueTableInput(app, table)
+	if err != nil {
+		return err
+	}
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+	catalog := glue.New(sess, newAwsConfig())
+
+	_, err = catalog.GetTable(&glue.GetTableInput{DatabaseName: aws.String(database), Name: aws.String(table)})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == glue.ErrCodeEntityNotFoundException {
+		_, err = catalog.CreateTable(&glue.CreateTableInput{DatabaseName: aws.String(database), TableInput: input})
+	} else if err == nil {
+		_, err = catalog.UpdateTable(&glue.UpdateTableInput{DatabaseName: aws.String(database), TableInput: input})
+	}
+	if err != nil {
+		return fmt.Errorf(T("Glue table %s: %v"), config.glueTable, err)
+	}
+	log.Printf(T("Glue table %s reads s3://%s/%s"), config.glueTable, config.bucketName, glueLocation())
+
+	if len(input.PartitionKeys) == 0 {
+		return nil
+	}
+	partitions, err := listGluePartitions(svc)
+	if err != nil {
+		return err
+	}
+	batch := make([]*glue.PartitionInput, 0, GLUE_BATCH_PARTITIONS)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		out, err := catalog.BatchCreatePartition(&glue.BatchCreatePartitionInput{
+			DatabaseName:       aws.String(database),
+			TableName:          aws.String(table),
+			PartitionInputList: batch,
+		})
+		if err != nil {
+			return err
+		}
+		for _, e := range out.Errors {
+			// the partitions of earlier runs are there already
+			if aws.StringValue(e.ErrorDetail.ErrorCode) != glue.ErrCodeAlreadyExistsException {
+				return fmt.Errorf(T("Glue partition %s: %s"), strings.Join(aws.StringValueSlice(e.PartitionValues), "/"), aws.StringValue(e.ErrorDetail.ErrorMessage))
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+	for dir, values := range partitions {
+		sd := *input.StorageDescriptor
+		sd.Location = aws.String("s3://" + config.bucketName + "/" + dir)
+		batch = append(batch, &glue.PartitionInput{Values: aws.StringSlice(values), StorageDescriptor: &sd})
+		if len(batch) == GLUE_BATCH_PARTITIONS {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
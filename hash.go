@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/kintone/go-kintone"
+	"hash"
+	"sort"
+	"strconv"
+)
+
+// the column of -record-hash
+const RECORD_HASH_COLUMN = "$hash"
+
+func sortedFieldCodes(fields map[string]interface{}) []string {
+	codes := make([]string, 0, len(fields))
+	for code := range fields {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+func writeHashField(h hash.Hash, code string, value string) {
+	h.Write([]byte(code))
+	h.Write([]byte{0})
+	h.Write([]byte(value))
+	h.Write([]byte{0})
+}
+
+// a SHA-256 of the record's id and exported values, in field code order,
+// so it changes only when a value does. the revision is left out: it also
+// changes when nothing exported did.
+func recordHash(record *kintone.Record, derivedValues map[string]string) string {
+	h := sha256.New()
+	writeHashField(h, "$id", strconv.FormatUint(record.Id(), 10))
+
+	for _, code := range sortedFieldCodes(record.Fields) {
+		if table, ok := record.Fields[code].(kintone.SubTableField); ok {
+			for _, row := range table {
+				writeHashField(h, code, strconv.FormatUint(row.Id(), 10))
+				for _, subCode := range sortedFieldCodes(row.Fields) {
+					writeHashField(h, subCode, toString(row.Fields[subCode], "\n"))
+				}
+			}
+			continue
+		}
+		writeHashField(h, code, toString(record.Fields[code], "\n"))
+	}
+
+	for _, d := range config.derived {
+		writeHashField(h, d.name, derivedValues[d.name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
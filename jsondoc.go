@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/kintone/go-kintone"
+	"io"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// the "metadata" block of -json-metadata, ahead of the records
+type jsonMetadata struct {
+	Domain     string  `json:"domain"`
+	AppId      uint64  `json:"app_id"`
+	Query      string  `json:"query"`
+	ExportedAt string  `json:"exported_at"`
+	TotalCount *uint64 `json:"total_count,omitempty"`
+	RunId      string  `json:"run_id"`
+	Part       *int    `json:"part,omitempty"`
+}
+
+func validateJsonDocumentOptions() error {
+	if !config.jsonMetadata && config.jsonDocumentRecords == 0 {
+		return nil
+	}
+	if config.format != "json" {
+		return errors.New(T("-json-metadata and -json-document-records need -o json"))
+	}
+	if config.jsonDocumentRecords < 0 {
+		return errors.New(T("-json-document-records must be positive"))
+	}
+	if config.jsonDocumentRecords > 0 && (config.splitBy != "" || partitionColumns != nil || chunked() || config.appendMode || config.pipe != "") {
+		return errors.New(T("-json-document-records cannot be combined with -split-by, -partition-by, -chunk-records, -chunk-time, -append or -pipe"))
+	}
+	return nil
+}
+
+// open a document: {"records": [ or, with -json-metadata,
+// {"metadata": {...}, "records": [
+func writeJsonHeader(writer io.Writer, part int) error {
+	if !config.jsonMetadata {
+		_, err := fmt.Fprint(writer, "{\"records\": [\n")
+		return err
+	}
+
+	metadata := jsonMetadata{
+		Domain:     config.domain,
+		AppId:      config.appId,
+		Query:      config.query,
+		ExportedAt: run.StartedAt.UTC().Format(time.RFC3339),
+		TotalCount: run.TotalCount,
+		RunId:      run.Id,
+	}
+	if config.jsonDocumentRecords > 0 {
+		metadata.Part = &part
+	}
+	data, err := json.Marshal(&metadata)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(writer, "{\"metadata\": %s,\n\"records\": [\n", data)
+	return err
+}
+
+// e.g. golang-kintone-to-s3/part-00000.json
+func jsonDocumentKey(part int) string {
+	base := strings.TrimSuffix(config.key, path.Ext(config.key))
+	return fmt.Sprintf("%s/part-%05d-%s%s", base, part, attemptId(), path.Ext(config.key))
+}
+
+// export the records as documents of -json-document-records records, each
+// its own object
+func exportJsonDocuments(app *kintone.App, svc *s3.S3) error {
+	run.Destination = "s3://" + config.bucketName + "/" + strings.TrimSuffix(config.key, path.Ext(config.key)) + "/"
+
+	var key string
+	var upload *uploadPipeline
+	var env *envelopeWriter
+	var gz *gzip.Writer
+	var writer *bufio.Writer
+	open := func(part int) (io.Writer, error) {
+		key = jsonDocumentKey(part)
+		upload = newUploadPipeline(svc, key)
+		upload.metadata = provenanceMetadata
+		var err error
+		if env, err = encryptUpload(upload); err != nil {
+			return nil, err
+		}
+		var out io.Writer = upload
+		if env != nil {
+			out = env
+		}
+		if gz = compressWriter(out); gz != nil {
+			out = gz
+		}
+		writer = bufio.NewWriter(out)
+		return writer, nil
+	}
+	end := func(records int) error {
+		err := writer.Flush()
+		if err == nil && gz != nil {
+			err = gz.Close()
+		}
+		if err == nil && env != nil {
+			err = env.Close()
+		}
+		if err == nil {
+			err = upload.Close()
+		} else {
+			upload.Abort()
+		}
+		atomic.AddInt64(&run.Bytes, upload.Len())
+		addStage(STAGE_SERIALIZE, 0, 0, upload.Len())
+		done := upload
+		upload = nil
+		if err != nil {
+			return uploadError(key, err)
+		}
+
+		addManifestObject(key, done.Len(), uint64(records), done.Sum())
+		if exportSigner != nil {
+			if err := signObject(svc, key, done.Len(), done.Sum()); err != nil {
+				return err
+			}
+		}
+		if config.sha256Sidecar {
+			return putChecksumSidecar(svc, key, done.Sum())
+		}
+		return nil
+	}
+
+	err := writeJsonDocuments(app, config.query, open, end)
+	if err != nil && upload != nil {
+		upload.Abort()
+	}
+	return err
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/kintone/go-kintone"
+	"path"
+	"strings"
+)
+
+const JSON_SCHEMA_DRAFT = "https://json-schema.org/draft/2020-12/schema"
+
+func validateJSONSchemaOptions() error {
+	if config.format != "json" {
+		return errors.New(T("-json-schema needs -o json"))
+	}
+	return nil
+}
+
+// e.g. golang-kintone-to-s3.schema.json
+func jsonSchemaKey(key string) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + ".schema.json"
+}
+
+var userSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"code": map[string]interface{}{"type": "string"},
+		"name": map[string]interface{}{"type": "string"},
+	},
+	"required": []string{"code"},
+}
+
+var fileSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"contentType": map[string]interface{}{"type": "string"},
+		"fileKey":     map[string]interface{}{"type": "string"},
+		"name":        map[string]interface{}{"type": "string"},
+		"size":        map[string]interface{}{"type": "string"},
+	},
+	"required": []string{"fileKey", "name"},
+}
+
+func arrayOf(items interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": "array", "items": items}
+}
+
+// the "value" of a field as go-kintone marshals it
+func valueSchema(f *kintone.FieldInfo) interface{} {
+	switch f.Type {
+	case kintone.FT_CHECK_BOX, kintone.FT_MULTI_SELECT, kintone.FT_CATEGORY:
+		return arrayOf(map[string]interface{}{"type": "string"})
+	case kintone.FT_USER, kintone.FT_ORGANIZATION, kintone.FT_GROUP, kintone.FT_ASSIGNEE:
+		return arrayOf(userSchema)
+	case kintone.FT_CREATOR, kintone.FT_MODIFIER:
+		return userSchema
+	case kintone.FT_FILE:
+		return arrayOf(fileSchema)
+	case kintone.FT_DATE:
+		return map[string]interface{}{"type": []string{"string", "null"}, "format": "date"}
+	case kintone.FT_TIME:
+		return map[string]interface{}{"type": []string{"string", "null"}}
+	case kintone.FT_DATETIME, kintone.FT_CTIME, kintone.FT_MTIME:
+		return map[string]interface{}{"type": []string{"string", "null"}, "format": "date-time"}
+	case kintone.FT_SUBTABLE:
+		return arrayOf(map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"id": map[string]interface{}{"type": "string"},
+				"value": map[string]interface{}{
+					"type":       "object",
+					"properties": subFieldSchemas(f.Fields),
+				},
+			},
+			"required": []string{"id", "value"},
+		})
+	}
+	// text, numbers and calculations are strings, as kintone sends them
+	return map[string]interface{}{"type": "string"}
+}
+
+func fieldSchema(f *kintone.FieldInfo) map[string]interface{} {
+	s := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"type":  map[string]interface{}{"const": f.Type},
+			"value": valueSchema(f),
+		},
+		"required": []string{"type", "value"},
+	}
+	if f.Label != "" {
+		s["title"] = f.Label
+	}
+	return s
+}
+
+func fieldSchemas(fields map[string]*kintone.FieldInfo) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for _, f := range fields {
+		if f.Code == "" {
+			continue
+		}
+		properties[f.Code] = fieldSchema(f)
+	}
+	return properties
+}
+
+func subFieldSchemas(fields []kintone.FieldInfo) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for i := range fields {
+		properties[fields[i].Code] = fieldSchema(&fields[i])
+	}
+	return properties
+}
+
+// a JSON Schema of the document written by writeJson, from the field
+// types of the app and limited to the exported fields
+func buildJSONSchema(fields map[string]*kintone.FieldInfo) map[string]interface{} {
+	exported := fields
+	if config.fields != nil {
+		exported = make(map[string]*kintone.FieldInfo)
+		for _, code := range config.fields {
+			if f, ok := fields[code]; ok {
+				exported[code] = f
+			}
+		}
+	}
+	properties := fieldSchemas(exported)
+	for _, c := range derivedColumns() {
+		properties[c.Code] = fieldSchema(&kintone.FieldInfo{Code: c.Code, Type: kintone.FT_SINGLE_LINE_TEXT})
+	}
+
+	document := map[string]interface{}{
+		"records": arrayOf(map[string]interface{}{
+			"type":       "object",
+			"properties": properties,
+		}),
+	}
+	required := []string{"records"}
+	if config.jsonMetadata {
+		document["metadata"] = map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"domain":      map[string]interface{}{"type": "string"},
+				"app_id":      map[string]interface{}{"type": "integer"},
+				"query":       map[string]interface{}{"type": "string"},
+				"exported_at": map[string]interface{}{"type": "string", "format": "date-time"},
+				"total_count": map[string]interface{}{"type": "integer"},
+				"run_id":      map[string]interface{}{"type": "string"},
+				"part":        map[string]interface{}{"type": "integer"},
+			},
+			"required": []string{"domain", "app_id", "query", "exported_at", "run_id"},
+		}
+		required = append(required, "metadata")
+	}
+
+	return map[string]interface{}{
+		"$schema":    JSON_SCHEMA_DRAFT,
+		"title":      fmt.Sprintf("kintone app %d records", config.appId),
+		"type":       "object",
+		"properties": document,
+		"required":   required,
+	}
+}
+
+// put the schema of the export next to it, at <key without extension>.schema.json
+func uploadJSONSchema(app *kintone.App, svc *s3.S3, key string) error {
+	fields, err := getFields(app)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(buildJSONSchema(fields), "", "  ")
+	if err != nil {
+		return err
+	}
+	schemaKey := jsonSchemaKey(key)
+	input := newPutObjectInput(schemaKey, bytes.NewReader(data))
+	input.ContentType = aws.String("application/schema+json")
+	if _, err := svc.PutObject(input); err != nil {
+		return err
+	}
+	if exportSigner != nil {
+		return putSignature(svc, schemaKey, data)
+	}
+	return nil
+}
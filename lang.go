@@ -0,0 +1,470 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// message language, decided before the flags are defined so that the
+// help text can be translated too
+var lang = detectLang(os.Args[1:])
+
+// -lang on the command line, then KINTONE_TO_S3_LANG, then the locale
+// (on Windows, the user's UI language)
+func detectLang(args []string) string {
+	for i, arg := range args {
+		switch {
+		case (arg == "-lang" || arg == "--lang") && i+1 < len(args):
+			return normalizeLang(args[i+1])
+		case strings.HasPrefix(arg, "-lang="), strings.HasPrefix(arg, "--lang="):
+			return normalizeLang(arg[strings.Index(arg, "=")+1:])
+		}
+	}
+
+	for _, name := range []string{"KINTONE_TO_S3_LANG", "LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			return normalizeLang(value)
+		}
+	}
+	if value := systemLang(); value != "" {
+		return value
+	}
+	return "en"
+}
+
+func normalizeLang(value string) string {
+	if strings.HasPrefix(strings.ToLower(value), "ja") {
+		return "ja"
+	}
+	return "en"
+}
+
+// translate a message. messages are written in English, which is also
+// the fallback for anything missing from the catalog.
+func T(message string) string {
+	if lang == "ja" {
+		if translated, ok := messagesJa[message]; ok {
+			return translated
+		}
+	}
+	return message
+}
+
+var messagesJa = map[string]string{
+	// options
+	"Login name":                     "ログイン名",
+	"Password":                       "パスワード",
+	"Basic authentication user name": "Basic認証のユーザー名",
+	"Basic authentication password":  "Basic認証のパスワード",
+	"Domain name":                    "ドメイン名",
+	"API token":                      "APIトークン",
+	"App ID":                         "アプリID",
+	"Guest Space ID":                 "ゲストスペースID",
+	"Output format: 'json', 'csv'(default), 'sqlite' or 'arrow' (an Arrow IPC stream to stdout)":           "出力形式: 'json', 'csv'(デフォルト), 'sqlite' または 'arrow'(標準出力へのArrow IPCストリーム)",
+	"-o sqlite cannot be combined with -chunk-records, -chunk-time, -append, -split-by or -pin-revisions":  "-o sqlite は -chunk-records, -chunk-time, -append, -split-by, -pin-revisions と同時に指定できません",
+	"Write under Hive-style prefixes of these columns (comma separated): app_id, dt and at most one field": "これらの列のHive形式のプレフィックスの下に出力する (カンマ区切り): app_id, dt と1つまでのフィールド",
+	"-partition-by cannot be combined with -append":                                                        "-partition-by は -append と同時に指定できません",
+	"JSON file of rules routing apps by ID or name to a bucket, prefix, format and KMS key":                "アプリをIDまたは名前でバケット, プレフィックス, 出力形式, KMSキーに振り分けるルールのJSONファイル",
+	"bad app name pattern %q: %v":                                                                          "アプリ名のパターン %q が不正です: %v",
+	"Wait a random time up to this long before starting, e.g. 10m":                                         "開始前にこの時間までのランダムな時間待つ (例: 10m)",
+	"Skip the run when it starts in this window, e.g. '01:00-03:00' or 'mon-fri 09:00-18:00' (repeatable)": "この時間帯に開始した場合は実行しない (例: '01:00-03:00', 'mon-fri 09:00-18:00') (複数指定可)",
+	"invalid time of day: %s":                                                                              "時刻が不正です: %s",
+	"invalid day of the week: %s":                                                                          "曜日が不正です: %s",
+	"invalid blackout window: %s":                                                                          "実行しない時間帯の指定が不正です: %s",
+	"waiting %v before starting":                                                                           "開始まで %v 待ちます",
+	"skipping the export: %s is in a blackout window":                                                      "エクスポートを実行しません: %s は実行しない時間帯です",
+	"Server-side encryption: 'AES256' (SSE-S3) or 'aws:kms' (SSE-KMS, implied by -sse-kms-key-id)":         "サーバー側暗号化: 'AES256' (SSE-S3) または 'aws:kms' (SSE-KMS, -sse-kms-key-id 指定時はこちら)",
+	"-sse-kms-key-id needs -sse aws:kms":                                                                   "-sse-kms-key-id には -sse aws:kms が必要です",
+	"unknown server-side encryption: %s":                                                                   "不明なサーバー側暗号化です: %s",
+	"Storage class of the objects, e.g. 'STANDARD_IA', 'INTELLIGENT_TIERING' or 'GLACIER_IR' (default: the bucket's)": "オブジェクトのストレージクラス (例: 'STANDARD_IA', 'INTELLIGENT_TIERING', 'GLACIER_IR') (デフォルト: バケットの設定)",
+	"unknown storage class: %s": "不明なストレージクラスです: %s",
+	"How long to wait out kintone maintenance (503) before failing, 0 to fail at once":                                                     "kintoneのメンテナンス(503)の終了を待つ最大時間。0 ですぐに失敗する",
+	"kintone is still in maintenance after %v: %v":                                                                                         "%v 待ってもkintoneはメンテナンス中です: %v",
+	"kintone is in maintenance, retrying in %v":                                                                                            "kintoneはメンテナンス中です。%v 後に再試行します",
+	"Canned ACL of the objects, e.g. 'bucket-owner-full-control' (default: none, or bucket-owner-full-control for cross-account delivery)": "オブジェクトの既定ACL (例: 'bucket-owner-full-control') (デフォルト: なし。アカウントをまたぐ配信では bucket-owner-full-control)",
+	"-acl cannot be used with BucketOwnerEnforced, which disables ACLs":                                                                    "-acl はACLが無効な BucketOwnerEnforced とは同時に指定できません",
+	"unknown ACL: %s": "不明なACLです: %s",
+	"Add a $hash column, a SHA-256 of the record's exported values, to tell changed records by": "出力する値のSHA-256を $hash 列として追加し、変更されたレコードを判別できるようにする",
+	"Tags of the objects, e.g. 'app=123,env=prod,pii=true'":                                     "オブジェクトのタグ (例: 'app=123,env=prod,pii=true')",
+	"invalid tag: %s":                    "タグが不正です: %s",
+	"an object can have at most 10 tags": "オブジェクトのタグは10個までです",
+	"Command to pipe the CSV or JSON output through before the upload, e.g. 'gpg --encrypt -r ops@example.com'": "アップロード前にCSVまたはJSONの出力を通すコマンド (例: 'gpg --encrypt -r ops@example.com')",
+	"could not start -pipe command: %v":                                                                                                          "-pipe のコマンドを開始できません: %v",
+	"-pipe command failed: %v":                                                                                                                   "-pipe のコマンドが失敗しました: %v",
+	"-chunk-records and -chunk-time cannot be combined with -pipe":                                                                               "-chunk-records, -chunk-time は -pipe と同時に指定できません",
+	"Print a job config for the app to start from":                                                                                               "アプリのジョブ設定のひな形を出力する",
+	"Endpoint of an S3-compatible store such as MinIO or Wasabi, e.g. https://minio.example.com:9000":                                            "MinIOやWasabiなどS3互換ストレージのエンドポイント (例: https://minio.example.com:9000)",
+	"Address the bucket in the path instead of the host name, as most S3-compatible stores need":                                                 "バケットをホスト名ではなくパスで指定する (多くのS3互換ストレージで必要)",
+	"Retries of a failed S3 request, with exponential backoff":                                                                                   "失敗したS3リクエストを指数バックオフで再試行する回数",
+	"upload to s3://%s/%s failed: %v":                                                                                                            "s3://%s/%s へのアップロードに失敗しました: %v",
+	"Print a presigned GET URL of the export valid this long, e.g. 24h":                                                                          "この期間有効なエクスポートの署名付きGET URLを出力する (例: 24h)",
+	"URL to POST the presigned URL to as JSON":                                                                                                   "署名付きURLをJSONでPOSTするURL",
+	"-presign can be at most %v":                                                                                                                 "-presign は最大 %v です",
+	"-presign-webhook needs -presign":                                                                                                            "-presign-webhook には -presign が必要です",
+	"webhook %s answered %s: %s":                                                                                                                 "Webhook %s の応答が %s でした: %s",
+	"Shape of the completion messages: 'tool' (default) or 's3', like an S3 ObjectCreated event notification":                                    "完了メッセージの形式: 'tool'(デフォルト) または 's3' (S3のObjectCreatedイベント通知と同じ形)",
+	"unknown event format: %s":                                                                                                                   "不明なイベント形式です: %s",
+	"kintone requests per second shared by all processes exporting from the domain (default: no limit)":                                          "ドメインからエクスポートする全プロセスで共有する、kintoneへの毎秒のリクエスト数 (デフォルト: 制限なし)",
+	"Where the processes share the -rate-limit: dynamodb://table (default: -state if in DynamoDB)":                                               "-rate-limit を共有する場所: dynamodb://table (デフォルト: DynamoDBの -state)",
+	"-rate-limit needs -rate-limit-store dynamodb://table, or a -state in DynamoDB":                                                              "-rate-limit には -rate-limit-store dynamodb://table かDynamoDBの -state が必要です",
+	"Upload through the S3 Transfer Acceleration endpoint, which the bucket must have enabled":                                                   "S3 Transfer Accelerationのエンドポイント経由でアップロードする (バケットで有効にしておく必要がある)",
+	"-s3-accelerate cannot be combined with -s3-endpoint or -s3-force-path-style":                                                                "-s3-accelerate は -s3-endpoint, -s3-force-path-style と同時に指定できません",
+	"Leave out the records matching this rule, e.g. 'privacy_flag = \"opt-out\"' (repeatable)":                                                   "このルールに一致するレコードを出力しない (例: 'privacy_flag = \"opt-out\"') (複数指定可)",
+	"Mask every value but $id and $revision of the records matching this rule (repeatable)":                                                      "このルールに一致するレコードの $id と $revision 以外の値をマスクする (複数指定可)",
+	"Value of the fields of masked records":                                                                                                      "マスクしたレコードのフィールドの値",
+	"redaction rule must be expression = expression or expression != expression: %s":                                                             "除外ルールは 式 = 式 または 式 != 式 の形式で指定してください: %s",
+	"redaction rule %s: %v":                                                                                                                      "除外ルール %s: %v",
+	"redaction rule %s: unexpected %q":                                                                                                           "除外ルール %s: 予期しない %q があります",
+	"Write JSON byte-identical for identical data: records by $id, sorted keys, stable numbers and UTC times":                                    "同じデータから同じバイト列のJSONを出力する: $id 順のレコード, ソートしたキー, 安定した数値表記, UTCの日時",
+	"-canonical-json needs -o json":                                                                                                              "-canonical-json には -o json が必要です",
+	"checksum mismatch: sent %s, S3 stored %s":                                                                                                   "チェックサムが一致しません: 送信 %s、S3 に保存 %s",
+	"Send a SHA-256 of each upload for S3 to verify and store with the object; turn off for stores without additional checksums":                 "アップロードごとに SHA-256 を送り、S3 で検証してオブジェクトと共に保存します。追加チェックサムに対応しないストアではオフにしてください",
+	"Put a sha256sum-style <key>.sha256 next to the export to verify downloads by":                                                               "ダウンロードの検証用に sha256sum 形式の <key>.sha256 をエクスポートの隣に置きます",
+	"-create-bucket cannot create a bucket in another account (-s3-owner)":                                                                       "-create-bucket は他のアカウントのバケット (-bucket-owner) を作成できません",
+	"created bucket s3://%s":                                                                                                                     "バケット s3://%s を作成しました",
+	"Create the bucket in the region, with all public access blocked, when it does not exist":                                                    "バケットが存在しない場合、パブリックアクセスをすべてブロックしてリージョンに作成します",
+	"not an S3 access point ARN: %s":                                                                                                             "S3 アクセスポイントの ARN ではありません: %s",
+	"an access point cannot be used with -s3-endpoint or -s3-force-path-style":                                                                   "アクセスポイントは -s3-endpoint や -s3-force-path-style と併用できません",
+	"an access point cannot be used with -s3-accelerate":                                                                                         "アクセスポイントは -s3-accelerate と併用できません",
+	"-create-bucket cannot create an access point":                                                                                               "-create-bucket はアクセスポイントを作成できません",
+	"-presign is not supported with a Multi-Region Access Point":                                                                                 "-presign はマルチリージョンアクセスポイントでは使用できません",
+	"-json-metadata and -json-document-records need -o json":                                                                                     "-json-metadata と -json-document-records には -o json が必要です",
+	"-json-document-records must be positive":                                                                                                    "-json-document-records は正の数を指定してください",
+	"-json-document-records cannot be combined with -split-by, -partition-by, -chunk-records, -chunk-time, -append or -pipe":                     "-json-document-records は -split-by、-partition-by、-chunk-records、-chunk-time、-append、-pipe と併用できません",
+	"Add a metadata block to JSON documents: domain, app id, query, export time, kintone's count and run id":                                     "JSON ドキュメントにメタデータ (ドメイン、アプリ ID、クエリ、エクスポート時刻、kintone の件数、実行 ID) を追加します",
+	"Write JSON as documents of at most this many records, each its own object: <key without extension>/part-00000-<run id>-<attempt>.json, ...": "JSON をこの件数以下のドキュメントに分け、それぞれ別のオブジェクトに書き込みます: <拡張子を除いたキー>/part-00000-<実行 ID>-<試行回数>.json, ...",
+	"-envelope-kms-key-id cannot be combined with -chunk-records or -chunk-time":                                                                 "-envelope-kms-key-id は -chunk-records や -chunk-time と併用できません",
+	"-envelope-kms-key-id does not apply to -o arrow, which is written to standard output":                                                       "-o arrow は標準出力に書き込むため -envelope-kms-key-id は使えません",
+	"too large to encrypt":                "暗号化するには大きすぎます",
+	"s3://%s/%s is not encrypted with %s": "s3://%s/%s は %s で暗号化されていません",
+	"invalid %s: %s":                      "%s が不正です: %s",
+	"encrypted object is truncated":       "暗号化されたオブジェクトが途中で切れています",
+	"segment %d: %v":                      "セグメント %d: %v",
+	"usage: decrypt <key>":                "使い方: decrypt <key>",
+	"Encrypt each object before the upload with a data key of this KMS key, kept encrypted in the object metadata; read them with the decrypt command": "アップロード前に、この KMS キーのデータキーで各オブジェクトを暗号化します。データキーは暗号化してオブジェクトのメタデータに保存します。decrypt コマンドで復号できます",
+	"invalid retention: %s":                                                                                                                       "保持期間が不正です: %s",
+	"-object-lock-mode must be GOVERNANCE or COMPLIANCE: %s":                                                                                      "-object-lock-mode は GOVERNANCE か COMPLIANCE を指定してください: %s",
+	"-object-lock-mode needs -object-lock-retain":                                                                                                 "-object-lock-mode には -object-lock-retain が必要です",
+	"-object-lock-mode cannot be combined with -chunk-records or -chunk-time":                                                                     "-object-lock-mode は -chunk-records や -chunk-time と併用できません",
+	"-object-lock-mode needs -s3-checksum":                                                                                                        "-object-lock-mode には -s3-checksum が必要です",
+	"-object-lock-retain is in the past: %s":                                                                                                      "-object-lock-retain が過去の日時です: %s",
+	"Object Lock mode of the objects, GOVERNANCE or COMPLIANCE; the bucket must have Object Lock enabled":                                         "オブジェクトのオブジェクトロックモード (GOVERNANCE または COMPLIANCE)。バケットでオブジェクトロックを有効にしておく必要があります",
+	"How long Object Lock retains the objects: days, a duration or a date, e.g. 2555d or 2031-12-31":                                              "オブジェクトロックでオブジェクトを保持する期間: 日数、時間または日付 (例: 2555d、2031-12-31)",
+	"invalid -replicate-to bucket: %s":                                                                                                            "-replicate-to のバケットが不正です: %s",
+	"-replicate-to is the export's own bucket: %s":                                                                                                "-replicate-to にエクスポート先のバケットが指定されています: %s",
+	"-replicate-to cannot be combined with -chunk-records or -chunk-time":                                                                         "-replicate-to は -chunk-records や -chunk-time と併用できません",
+	"replication to s3://%s failed after %d of %d objects: %v":                                                                                    "s3://%s への複製が %d / %d オブジェクトで失敗しました: %v",
+	"replicated %d objects to s3://%s":                                                                                                            "%d 個のオブジェクトを s3://%s に複製しました",
+	"replication failed to %d of %d buckets":                                                                                                      "%d / %d のバケットへの複製に失敗しました",
+	"Copy the export's objects to this bucket as well, e.g. 'dr-bucket@us-west-2' (repeatable)":                                                   "エクスポートしたオブジェクトをこのバケットにもコピーします (例: 'dr-bucket@us-west-2'、複数指定可)",
+	"-latest-key and -latest-pointer need an export into one object, not -split-by, -partition-by or -json-document-records":                      "-latest-key と -latest-pointer は 1 つのオブジェクトへのエクスポートでのみ使えます (-split-by、-partition-by、-json-document-records は不可)",
+	"-latest-key and -latest-pointer need an object key of their own":                                                                             "-latest-key と -latest-pointer には専用のオブジェクトキーが必要です",
+	"s3://%s/%s now has the export of s3://%s/%s":                                                                                                 "s3://%s/%s を s3://%s/%s のエクスポートに更新しました",
+	"Copy each complete export to this key too, e.g. 'latest/{appId}.{ext}', for consumers who want the newest one":                               "完了したエクスポートをこのキーにもコピーします (例: 'latest/{appId}.{ext}')。最新のエクスポートだけが必要な利用者向けです",
+	"Put a JSON object at this key telling the key of the newest complete export":                                                                 "最新の完了したエクスポートのキーを示す JSON オブジェクトをこのキーに置きます",
+	"-if-not-exists must be 'fail' or 'suffix': %s":                                                                                               "-if-not-exists には 'fail' か 'suffix' を指定してください: %s",
+	"-if-not-exists needs an export into one object, not -split-by, -partition-by, -json-document-records or -append":                             "-if-not-exists は 1 つのオブジェクトへのエクスポートでのみ使えます (-split-by、-partition-by、-json-document-records、-append は不可)",
+	"s3://%s/%s exists, exporting to s3://%s/%s":                                                                                                  "s3://%s/%s が存在するため s3://%s/%s にエクスポートします",
+	"s3://%s/%s already exists (-if-not-exists)":                                                                                                  "s3://%s/%s は既に存在します (-if-not-exists)",
+	"s3://%s/%s and %d suffixed keys already exist":                                                                                               "s3://%s/%s と番号付きの %d 個のキーが既に存在します",
+	"the key was written by another run during the export (-if-not-exists)":                                                                       "エクスポート中に別の実行がキーに書き込みました (-if-not-exists)",
+	"When the object key exists: 'fail', or 'suffix' to export to key-1, key-2, ... (default: overwrite)":                                         "オブジェクトキーが存在する場合: 'fail' で失敗、'suffix' で key-1, key-2, ... にエクスポート (デフォルト: 上書き)",
+	"-keep must be positive":                                                                                                                      "-keep は正の数を指定してください",
+	"-keep needs an export into one object, not -split-by, -partition-by, -json-document-records or -append":                                      "-keep は 1 つのオブジェクトへのエクスポートでのみ使えます (-split-by、-partition-by、-json-document-records、-append は不可)",
+	"-keep needs {date} or {timestamp} in the object key, or -if-not-exists suffix":                                                               "-keep にはオブジェクトキーに {date} か {timestamp} を含めるか、-if-not-exists suffix が必要です",
+	"could not delete s3://%s/%s: %s":                                                                                                             "s3://%s/%s を削除できませんでした: %s",
+	"kept the newest %d exports of s3://%s/%s":                                                                                                    "最新 %d 件のエクスポートを残しました: s3://%s/%s",
+	"Keep only this many of the newest exports of the object key, whose {date} or {timestamp} differ, deleting older ones after a successful run": "オブジェクトキーの {date} や {timestamp} が異なるエクスポートのうち最新のこの件数だけを残し、成功した実行の後で古いものを削除します",
+	"Upload the options of the drop-down, radio button, check box and multi-choice fields next to the export (<key>.options.csv)":                 "ドロップダウン、ラジオボタン、チェックボックス、複数選択フィールドの選択肢をエクスポートの隣にアップロードします (<key>.options.csv)",
+	"-truncate-text must be positive":                                                                                                             "-truncate-text は正の数を指定してください",
+	"-truncate-text cannot be combined with -chunk-records, -chunk-time, -append or -o arrow":                                                     "-truncate-text は -chunk-records、-chunk-time、-append、-o arrow と併用できません",
+	"Cut text and rich text values longer than this many characters, putting the full values next to the export (<key>.overflow.jsonl)":           "この文字数を超える文字列やリッチエディターの値を切り詰め、元の値をエクスポートの隣に置きます (<key>.overflow.jsonl)",
+	"Write a JSON manifest of the objects of the export, their sizes, record counts and checksums, once all are uploaded (<key>.manifest.json, or manifest.json under the prefix of -split-by and -json-document-records)": "すべてのアップロード後に、エクスポートのオブジェクトとそのサイズ、レコード数、チェックサムの JSON マニフェストを書き込みます (<key>.manifest.json、-split-by と -json-document-records ではプレフィックス下の manifest.json)",
+	"manifest of %d objects written to s3://%s/%s":                                                              "%d 個のオブジェクトのマニフェストを s3://%s/%s に書き込みました",
+	"Attempt number of a retried job, put into the part keys with the run id so attempts never mix their parts": "再試行されたジョブの試行回数です。実行 ID とともにパートのキーに入れ、試行ごとのパートが混ざらないようにします",
+	"-attempt must be 1 or more":                                                                                "-attempt は 1 以上を指定してください",
+	"could not list the parts of snapshot %s: %v":                                                               "スナップショット %s のパートを一覧できませんでした: %v",
+	"-compress must be 'gzip': %s":                                                                              "-compress には 'gzip' を指定してください: %s",
+	"-compress needs -o csv or -o json":                                                                         "-compress には -o csv か -o json が必要です",
+	"Compress the CSV or JSON before upload: 'gzip' ({ext} becomes csv.gz or json.gz)":                          "アップロード前に CSV や JSON を圧縮します: 'gzip' ({ext} は csv.gz や json.gz になります)",
+	"-u, -p, -U, -P, -t, -sign-key, KINTONE_API_TOKEN, KINTONE_TO_S3_ACCESSKEY, KINTONE_TO_S3_SECRET and -azure-connection-string may be secret references:": "-u, -p, -U, -P, -t, -sign-key, KINTONE_API_TOKEN, KINTONE_TO_S3_ACCESSKEY, KINTONE_TO_S3_SECRET, -azure-connection-string にはシークレットの参照を指定できます:",
+	"secret %s: %v":                    "シークレット %s: %v",
+	"secret %s: not a JSON object: %v": "シークレット %s: JSON オブジェクトではありません: %v",
+	"secret %s: no field %s":           "シークレット %s: フィールド %s がありません",
+	"%s is not set":                    "%s が設定されていません",
+	"Vault needs VAULT_TOKEN, or VAULT_ROLE_ID and VAULT_SECRET_ID":                                                                   "Vault には VAULT_TOKEN、または VAULT_ROLE_ID と VAULT_SECRET_ID が必要です",
+	"%s has no AWS credentials":                                                                                                       "%s に AWS の認証情報がありません",
+	"AWS credentials from Vault %s, valid for %s":                                                                                     "Vault の %s から AWS の認証情報を取得しました (有効期間 %s)",
+	"Take the AWS credentials from this path of Vault's AWS secrets engine, e.g. 'aws/creds/kintone-export'":                          "Vault の AWS シークレットエンジンのこのパスから AWS の認証情報を取得します (例: 'aws/creds/kintone-export')",
+	"-output must be '-', 'local:<path>' or 'az://<container>/<blob>': %s":                                                            "-output には '-'、'local:<パス>'、'az://<コンテナー>/<BLOB>' のいずれかを指定してください: %s",
+	"-output needs -o csv or -o json":                                                                                                 "-output には -o csv か -o json が必要です",
+	"-output writes one file, it cannot be combined with -split-by, -partition-by, -json-document-records, -chunk-records or -append": "-output は 1 つのファイルに書き込むため、-split-by、-partition-by、-json-document-records、-chunk-records、-append と併用できません",
+	"Write the output to standard output ('-'), a local file ('local:/path/to/records.csv') or Azure Blob Storage ('az://container/records.csv') instead of S3": "出力を S3 ではなく標準出力 ('-')、ローカルファイル ('local:/path/to/records.csv')、Azure Blob Storage ('az://container/records.csv') に書き込みます",
+	"invalid snapshot tag: %s (letters, digits, '.', '_' and '-', up to 64)":                                                                                    "スナップショットのタグが不正です: %s (英数字、'.'、'_'、'-' で 64 文字まで)",
+	"run %s wrote more than one object, see %s":                                                                                                                 "実行 %s は複数のオブジェクトを書き込みました。%s を参照してください",
+	"Tag the export with this label, e.g. 'pre-migration', in the run history and object metadata to find it by with runs tag and runs download (repeatable)":   "エクスポートにこのラベル (例: 'pre-migration') を付け、実行履歴とオブジェクトのメタデータに記録します。runs tag と runs download で探せます (複数指定可)",
+	"-output az:// needs a container and a blob name: %s":                                                                                                       "-output az:// にはコンテナー名と BLOB 名が必要です: %s",
+	"the Azure connection string has neither AccountName nor BlobEndpoint":                                                                                      "Azure の接続文字列に AccountName も BlobEndpoint もありません",
+	"invalid AccountKey in the Azure connection string: %v":                                                                                                     "Azure の接続文字列の AccountKey が不正です: %v",
+	"the Azure connection string has neither AccountKey nor SharedAccessSignature":                                                                              "Azure の接続文字列に AccountKey も SharedAccessSignature もありません",
+	"managed identity token: %s: %s":                                                                                                                            "マネージド ID のトークン: %s: %s",
+	"-output az:// needs -azure-connection-string or -azure-account":                                                                                            "-output az:// には -azure-connection-string か -azure-account が必要です",
+	"Connection string of the Azure storage account of -output az://, with AccountKey or SharedAccessSignature":                                                 "-output az:// の Azure ストレージアカウントの接続文字列です (AccountKey か SharedAccessSignature を含むもの)",
+	"Azure storage account of -output az://, written to with the managed identity (AZURE_CLIENT_ID for a user-assigned one)":                                    "-output az:// の Azure ストレージアカウントです。マネージド ID で書き込みます (ユーザー割り当ての場合は AZURE_CLIENT_ID)",
+	"-schema-breaking must be 'fail' or 'warn': %s":                                                                                                             "-schema-breaking には 'fail' か 'warn' を指定してください: %s",
+	"-schema-contract needs -o csv or -o json":                                                                                                                  "-schema-contract には -o csv か -o json が必要です",
+	"-schema-contract glue:// needs a database and a table: %s":                                                                                                 "-schema-contract glue:// にはデータベース名とテーブル名が必要です: %s",
+	"column %s is gone":                                                      "列 %s がなくなりました",
+	"column %s is %s, the table has %s":                                      "列 %s は %s ですが、テーブルでは %s です",
+	"column %s is %s, the contract has %s":                                   "列 %s は %s ですが、契約では %s です",
+	"no schema contract at %s, writing the export's columns as the contract": "%s にスキーマ契約がないため、エクスポートの列を契約として書き込みます",
+	"columns not in the schema contract: %s":                                 "スキーマ契約にない列: %s",
+	"breaking change against %s: %s":                                         "%s に対する互換性のない変更: %s",
+	"%d breaking changes against the schema contract %s":                     "%d 件の互換性のない変更がスキーマ契約 %s に対してあります",
+	"Check the export's columns against this contract before the upload: a JSON file, s3://bucket/key (written from the export when missing) or glue://database/table": "アップロード前にエクスポートの列をこの契約と照合します: JSON ファイル、s3://bucket/key (ない場合はエクスポートから書き込みます)、glue://database/table",
+	"What a breaking change against -schema-contract does: 'fail' or 'warn'":                                                                                           "-schema-contract に対する互換性のない変更があったときの動作: 'fail' か 'warn'",
+	"-firehose-stream needs -o json":    "-firehose-stream には -o json が必要です",
+	"-firehose-stream sends UTF-8 only": "-firehose-stream は UTF-8 でのみ送信します",
+	"-firehose-stream cannot be combined with -split-by, -partition-by, -json-document-records, -chunk-records, -append, -output, -pipe, -compress, -envelope-kms-key-id or -truncate-text": "-firehose-stream は -split-by、-partition-by、-json-document-records、-chunk-records、-append、-output、-pipe、-compress、-envelope-kms-key-id、-truncate-text と併用できません",
+	"Firehose did not take %d records: %s":                       "Firehose が %d 件のレコードを受け付けませんでした: %s",
+	"record %d is larger than the 1000 KiB of a Firehose record": "レコード %d は Firehose のレコードの上限 1000 KiB を超えています",
+	"%d records sent to Firehose stream %s":                      "%d 件のレコードを Firehose ストリーム %s に送信しました",
+	"Push the records as NDJSON into this Kinesis Data Firehose delivery stream instead of writing an object (-o json)": "オブジェクトを書き込む代わりに、レコードを NDJSON としてこの Kinesis Data Firehose 配信ストリームに送ります (-o json)",
+	"could not download %s, queued for retry-failures: %v":                                                              "%s をダウンロードできなかったため、retry-failures 用に記録しました: %v",
+	"retry-failures needs the -b directory of the export":                                                               "retry-failures にはエクスポートの -b ディレクトリが必要です",
+	"no failed attachments in %s":                                                                                       "%s に失敗した添付ファイルはありません",
+	"could not download %s: %v":                                                                                         "%s をダウンロードできませんでした: %v",
+	"downloaded %s":                                                                                                     "%s をダウンロードしました",
+	"%d of %d attachments failed again":                                                                                 "%d 件 (全 %d 件中) の添付ファイルが再び失敗しました",
+	"Queue an attachment which fails to download in the -b directory for retry-failures instead of failing the export": "ダウンロードに失敗した添付ファイルでエクスポートを失敗させず、retry-failures 用に -b ディレクトリに記録します",
+	"Download the attachments queued by -queue-attachment-failures again":                                              "-queue-attachment-failures で記録した添付ファイルを再びダウンロードする",
+	"URL of an SQS queue to send a message with the bucket, key, record count and run to after the upload":             "アップロード後にバケット、キー、レコード数、実行の情報を含むメッセージを送るSQSキューのURL",
+	"-sqs-queue-url needs an export to S3":                                          "-sqs-queue-url はS3へのエクスポートでのみ使えます",
+	"-sqs-queue-url cannot be combined with -split-by or -json-document-records":    "-sqs-queue-url は -split-by、-json-document-records と併用できません",
+	"SQS message to %s: %v":                                                         "%s へのSQSメッセージ: %v",
+	"sent SQS message %s":                                                           "SQSメッセージ %s を送信しました",
+	"ARN of an SNS topic to publish the result of each run to, succeeded or failed": "成功・失敗にかかわらず各実行の結果を発行するSNSトピックのARN",
+	"could not publish to %s: %v":                                                   "%s に発行できませんでした: %v",
+	"Name or ARN of an EventBridge event bus to put a kintone-to-s3.export.completed or .failed event of each run on": "各実行の kintone-to-s3.export.completed または .failed イベントを送るEventBridgeイベントバスの名前またはARN",
+	"could not put the event on %s: %v": "%s にイベントを送れませんでした: %v",
+	"Create or update this Glue table (<database>/<table>) over the CSV export after the upload, with its partitions, for Athena": "アップロード後、CSVエクスポートを読むこのGlueテーブル（<データベース>/<テーブル>）をパーティションとともに作成または更新する（Athena用）",
+	"-glue-table needs <database>/<table>: %s":                                                     "-glue-table には <データベース>/<テーブル> を指定してください: %s",
+	"-glue-table needs -o csv and -e utf-8":                                                        "-glue-table には -o csv と -e utf-8 が必要です",
+	"-glue-table cannot be combined with -output, -firehose-stream, -pipe or -envelope-kms-key-id": "-glue-table は -output、-firehose-stream、-pipe、-envelope-kms-key-id と併用できません",
+	"-glue-table needs a key under a prefix of its own, e.g. -k exports/{appId}/records.csv":       "-glue-table には専用のプレフィックス配下のキーが必要です（例: -k exports/{appId}/records.csv）",
+	"Glue table %s: %v":                                        "Glueテーブル %s: %v",
+	"Glue table %s reads s3://%s/%s":                           "Glueテーブル %s は s3://%s/%s を読みます",
+	"Glue partition %s: %s":                                    "Glueパーティション %s: %s",
+	"Print the Athena CREATE EXTERNAL TABLE of the CSV export": "CSVエクスポートのAthena用 CREATE EXTERNAL TABLE を表示する",
+	"usage: schema athena [<database>/<table>]":                "使い方: schema athena [<データベース>/<テーブル>]",
+	"schema athena needs -o csv":                               "schema athena には -o csv が必要です",
+	"Write a Redshift manifest of the CSV export and a COPY into this table (<schema>.<table>) next to it": "CSVエクスポートのRedshiftマニフェストと、このテーブル（<スキーマ>.<テーブル>）へのCOPYを隣に書き込む",
+	"IAM role ARN of the COPY (default: the cluster's default role)":                                       "COPYに使うIAMロールのARN（既定: クラスターのデフォルトロール）",
+	"-redshift-iam-role needs -redshift-table":                                                             "-redshift-iam-role には -redshift-table が必要です",
+	"-redshift-table needs -o csv and -e utf-8":                                                            "-redshift-table には -o csv と -e utf-8 が必要です",
+	"-redshift-table cannot be combined with -output, -firehose-stream, -pipe or -envelope-kms-key-id":     "-redshift-table は -output、-firehose-stream、-pipe、-envelope-kms-key-id と併用できません",
+	"Redshift COPY of %d objects written to s3://%s/%s":                                                    "%d 個のオブジェクトのRedshift COPYを s3://%s/%s に書き込みました",
+	"Export a date range window by window into its partitions, with checkpoints":                           "日付の範囲を期間ごとにそれぞれのパーティションへエクスポートする（チェックポイント付き）",
+	"Window of the backfill command: 'day' (default) or 'month'":                                           "backfill コマンドの期間: 'day'（既定）または 'month'",
+	"the backfill command cannot be combined with -backfill":                                               "backfill コマンドは -backfill と併用できません",
+	"usage: backfill <date field> <from> <to>":                                                             "使い方: backfill <日付フィールド> <開始日> <終了日>",
+	"backfill needs -state for its checkpoints":                                                            "backfill にはチェックポイント用の -state が必要です",
+	"-window must be 'day' or 'month': %s":                                                                 "-window は 'day' または 'month' です: %s",
+	"%s is before %s":                                                                                      "%s は %s より前です",
+	"no field %s in the app":                                                                               "アプリにフィールド %s がありません",
+	"%s is not a date or date and time field":                                                              "%s は日付または日時のフィールドではありません",
+	"the windows would overwrite %s; put {date} into -k or use -partition-by dt or -append":                "期間ごとのエクスポートが %s を上書きします。-k に {date} を入れるか、-partition-by dt または -append を使ってください",
+	"%s is exported already, skipped":                                                                      "%s はエクスポート済みのためスキップしました",
+	"backfilling %s into s3://%s/%s":                                                                       "%s を s3://%s/%s にエクスポートしています",
+	"%s failed: %v":                                                                                        "%s が失敗しました: %v",
+	"%d of %d windows failed; run backfill again to retry them":                                            "%d / %d 個の期間が失敗しました。backfill を再実行すると再試行します",
+	"the start and length of substr must be integers of 0 or more: %s":                                     "substr の開始位置と長さは0以上の整数です: %s",
+	"-dedupe-by cannot take the subtable %s":                                                               "-dedupe-by にはテーブル %s を指定できません",
+	"-dedupe-by cannot take %s in the subtable %s":                                                         "-dedupe-by にはテーブル %[2]s 内のフィールド %[1]s を指定できません",
+	"-dedupe-by: no field %s in the app":                                                                   "-dedupe-by: アプリにフィールド %s がありません",
+	"snapshot %s was started by an older version, restarting it":                                           "スナップショット %s は古いバージョンで開始されたため、最初からやり直します",
+	"snapshot %s needs more than %d parts to be joined; export larger chunks":                              "スナップショット %s の結合には %d を超えるパートが必要です。チャンクを大きくしてください",
+	"-o arrow cannot be combined with -split-by, -partition-by, -chunk-records, -chunk-time, -append, -pin-revisions, -manifest, -firehose-stream or -output": "-o arrow は -split-by、-partition-by、-chunk-records、-chunk-time、-append、-pin-revisions、-manifest、-firehose-stream、-output と併用できません",
+	"-max-memory must be more than the %d bytes of the upload's part buffers, (-upload-queue + 2) x -part-size":                                               "-max-memory はアップロードのパートバッファ (-upload-queue + 2) x -part-size の %d バイトより大きくしてください",
+	"s3://%s/%s needs more than %d parts of %d bytes; raise -part-size":                                                                                       "s3://%s/%s には %d 個を超える %d バイトのパートが必要です。-part-size を大きくしてください",
+	"the lock expired and was taken over by another run":                                                                                                      "ロックの期限が切れ、別の実行に引き継がれました",
+	"S3":                                     "S3",
+	"the records do not go to S3":            "レコードは S3 に出力されません",
+	"unexpected response from %s: %v":        "%s から想定外の応答がありました: %v",
+	"unexpected response from %s: no events": "%s から想定外の応答がありました: events がありません",
+	"the first month of %s must be a number from 1 to 12":     "%s の期首月は 1 から 12 の数値にしてください",
+	"the first month of %s must be a number from 1 to 12: %s": "%s の期首月は 1 から 12 の数値にしてください: %s",
+	"s3://%s/%s: %v": "s3://%s/%s: %v",
+	"Write an object exported with -envelope-kms-key-id, decrypted, to standard output": "-envelope-kms-key-id でエクスポートしたオブジェクトを復号して標準出力に書き出す",
+	"app %d is routed by rule %d of %s to s3://%s/%s":                                   "アプリ %d は %[3]s のルール %[2]d により s3://%[4]s/%[5]s に出力されます",
+	"-partition-by takes at most one field, and not with -split-by: %s":                 "-partition-by に指定できるフィールドは1つまでで、-split-by とは同時に指定できません: %s",
+	"a subtable is named %s, which is the table of the records":                         "テーブル %s はレコードのテーブルと同じ名前です",
+	"Query string":                     "クエリ文字列",
+	"Field names (comma separated)":    "フィールドコード(カンマ区切り)",
+	"Input file path":                  "入力ファイルのパス",
+	"Delete all records before insert": "登録前に全レコードを削除する",
+	"Character encoding: 'utf-8'(default), 'utf-16', 'utf-16be-with-signature', 'utf-16le-with-signature', 'sjis' (or 'cp932'), 'euc-jp', 'iso-2022-jp' or 'gb18030'": "文字コード: 'utf-8'(デフォルト), 'utf-16', 'utf-16be-with-signature', 'utf-16le-with-signature', 'sjis'('cp932'), 'euc-jp', 'iso-2022-jp' または 'gb18030'",
+	"Characters the encoding cannot represent: 'fail', 'replace'(default) with -encoding-placeholder, or 'report' to replace and list the records":                    "文字コードで表せない文字の扱い: 'fail'(失敗), 'replace'(デフォルト, -encoding-placeholder に置き換え), 'report'(置き換えてレコードを一覧する)",
+	"Replacement for characters the encoding cannot represent":                                    "文字コードで表せない文字の置き換え文字",
+	"Only warn when the records exported differ from kintone's count for the query":               "出力したレコード数がクエリに対するkintoneの件数と異なっても警告のみとする",
+	"Fetch the records changed during the export again, so the output is one consistent snapshot": "エクスポート中に変更されたレコードを取得し直し、出力を一貫したスナップショットにする",
+	"Upload a JSON Schema of the records next to a JSON export (<key>.schema.json)":               "JSON出力と並べてレコードのJSON Schemaをアップロードする (<key>.schema.json)",
+	"Write $id, $revision and numeric fields without quotes in CSV":                               "CSVで $id, $revision と数値のフィールドを引用符で囲まずに出力する",
+	"Attachment file directory":                                                                                                                                 "添付ファイルの保存先ディレクトリ",
+	"Expected bucket owner account ID (for cross-account delivery)":                                                                                             "バケット所有者のアカウントID(別アカウントへの配信用)",
+	"Object ownership of the bucket: 'BucketOwnerEnforced', 'BucketOwnerPreferred' or 'ObjectWriter'":                                                           "バケットのオブジェクト所有者設定: 'BucketOwnerEnforced', 'BucketOwnerPreferred' または 'ObjectWriter'",
+	"KMS key ID or ARN for server-side encryption (may belong to the bucket owner's account)":                                                                   "サーバー側暗号化に使うKMSキーのIDまたはARN(バケット所有者のアカウントのキーも可)",
+	"Verify S3 write permission with a probe object before exporting":                                                                                           "エクスポート前にテスト用オブジェクトでS3への書き込み権限を確認する",
+	"Run history location (s3://bucket/prefix)":                                                                                                                 "実行履歴の保存先(s3://bucket/prefix)",
+	"Step Functions task token to report the run result to":                                                                                                     "実行結果を報告するStep Functionsのタスクトークン",
+	"Interval of Step Functions task heartbeats":                                                                                                                "Step Functionsへのハートビートの間隔",
+	"Run state location: a local directory, s3://bucket/prefix or dynamodb://table":                                                                             "実行状態の保存先: ローカルディレクトリ, s3://bucket/prefix または dynamodb://table",
+	"Expiry of the per-app run lock":                                                                                                                            "アプリごとの実行ロックの有効期限",
+	"Keep the app's field schema in the state store for this long (0 disables the cache)":                                                                       "フィールド定義を実行状態の保存先にキャッシュする期間(0でキャッシュしない)",
+	"Fetch the field schema even if a cached one is still valid":                                                                                                "キャッシュが有効でもフィールド定義を取得し直す",
+	"Computed column as name=expression, e.g. 'full_name=concat(姓, \" \", 名)' (repeatable)":                                                                     "計算列を 名前=式 の形で指定 例: 'full_name=concat(姓, \" \", 名)' (複数指定可)",
+	"JSON config file of option values, keyed by option name, or the name of a job config in the directory of KINTONE_TO_S3_JOBS":                               "オプション名をキーとしたJSON形式の設定ファイル、または KINTONE_TO_S3_JOBS のディレクトリにあるジョブ設定の名前",
+	"Export only $id and these fields (comma separated) into a narrow file to merge into an earlier export":                                                     "$id とこれらのフィールド(カンマ区切り)のみを、以前の出力に結合するための別ファイルに出力する",
+	"Export each value of this field (a drop-down, radio button or low-cardinality text) into its own object, in parallel":                                      "このフィールド(ドロップダウン, ラジオボタン, 値の種類が少ない文字列)の値ごとに別のオブジェクトへ並行して出力する",
+	"Values of -split-by exported at a time":                                                                                                                    "-split-by で同時に出力する値の数",
+	"Drop duplicate records with the same values of these fields (comma separated), keeping the newest revision":                                                "これらのフィールド(カンマ区切り)の値が同じレコードを重複とみなし、最新のリビジョンのみ出力する",
+	"Directory to create the per-run work directory in (default: the system temp directory)":                                                                    "実行ごとの作業ディレクトリを作成する場所(デフォルト: システムの一時ディレクトリ)",
+	"Keep the per-run work directory for debugging":                                                                                                             "デバッグ用に実行ごとの作業ディレクトリを残す",
+	"Memory for the upload's part buffers, buffered pages and held output, e.g. 512MB; output held beyond it is spilled to the work directory (0 is unlimited)": "アップロードのパートバッファ、取得したページと保持する出力に使うメモリの上限 例: 512MB 超えた出力は作業ディレクトリに書き出す(0で無制限)",
+	"Serve net/http/pprof on this address, e.g. :6060":                                                                                                          "このアドレスで net/http/pprof を公開する 例: :6060",
+	"Write a CPU profile of the run to this file":                                                                                                               "実行中のCPUプロファイルをこのファイルに書き出す",
+	"Write a heap profile to this file on exit":                                                                                                                 "終了時にヒーププロファイルをこのファイルに書き出す",
+	"Idle HTTP connections kept for reuse across all hosts":                                                                                                     "再利用のために保持するHTTP接続の数(全ホスト合計)",
+	"Idle HTTP connections kept for reuse per host":                                                                                                             "再利用のために保持するHTTP接続の数(ホストごと)",
+	"Maximum HTTP connections per host (0 is unlimited)":                                                                                                        "ホストごとのHTTP接続数の上限(0で無制限)",
+	"Close idle HTTP connections after this long":                                                                                                               "使われていないHTTP接続を閉じるまでの時間",
+	"Export at most about this many records per run and resume from -state on the next run":                                                                     "1回の実行でエクスポートするレコード数の目安 続きは次回の実行で -state から再開する",
+	"Stop fetching after this long and resume from -state on the next run":                                                                                      "この時間が経過したら取得を止め、続きは次回の実行で -state から再開する",
+	"Add the output as a new part file under today's date partition instead of replacing the object":                                                            "オブジェクトを置き換えず、今日の日付のパーティションに新しいパートファイルとして出力を追加する",
+	"Asymmetric KMS key to sign the checksums of the output with":                                                                                               "出力のチェックサムに署名する非対称KMSキー",
+	"KMS signing algorithm, e.g. 'RSASSA_PSS_SHA_256' or 'ECDSA_SHA_256'":                                                                                       "KMSの署名アルゴリズム 例: 'RSASSA_PSS_SHA_256', 'ECDSA_SHA_256'",
+	"PEM private key file (RSA, ECDSA or Ed25519) to sign the checksums of the output with":                                                                     "出力のチェックサムに署名するPEM形式の秘密鍵ファイル(RSA, ECDSA, Ed25519)",
+	"Also export the audit log events of the app (needs an administrator's login)":                                                                              "アプリの監査ログも出力する(管理者のログイン名が必要)",
+	"Export the audit log events of this long before the run":                                                                                                   "実行前のこの期間の監査ログを出力する",
+	"Path of the cybozu.com audit log API":                                                                                                                      "cybozu.com の監査ログAPIのパス",
+	"Size of the parts the output is uploaded in, at least 5MB":                                                                                                 "出力をアップロードする単位(パート)のサイズ 5MB以上",
+	"Parts waiting for upload while the export goes on; each holds -part-size of memory":                                                                        "エクスポート中にアップロードを待つパートの数 1つあたり -part-size 分のメモリを使う",
+	"Message language: 'ja' or 'en'":                                                                                                                            "メッセージの言語: 'ja' または 'en'",
+
+	// prompts
+	"Password: ":                      "パスワード: ",
+	"Basic authentication password: ": "Basic認証のパスワード: ",
+
+	// commands
+	"unknown command: %s": "不明なコマンドです: %s",
+	"usage: runs list | runs show <run id> | runs tag <tag> | runs download <run id or tag>": "使い方: runs list | runs show <実行ID> | runs tag <タグ> | runs download <実行IDまたはタグ>",
+	"usage: runs tag <tag>":                                    "使い方: runs tag <タグ>",
+	"usage: runs download <run id or tag>":                     "使い方: runs download <実行IDまたはタグ>",
+	"usage: runs show <run id>":                                "使い方: runs show <実行ID>",
+	"unknown runs command: %s":                                 "runs の不明なサブコマンドです: %s",
+	"run not found: %s":                                        "実行履歴が見つかりません: %s",
+	"run history is not configured (-history)":                 "実行履歴の保存先が設定されていません(-history)",
+	"ID\tAPP\tSTATUS\tSTARTED\tDURATION\tRECORDS\tDESTINATION": "ID\tアプリ\t状態\t開始日時\t所要時間\tレコード数\t出力先",
+
+	"Usage: %s [command] [options]": "使い方: %s [コマンド] [オプション]",
+	"Commands:":                     "コマンド:",
+	"Options:":                      "オプション:",
+	"Examples:":                     "例:",
+	"Export the app's records to S3 (the default command)": "アプリのレコードをS3へエクスポートする(デフォルトのコマンド)",
+	"Check kintone and S3 access without exporting":        "エクスポートせずにkintoneとS3へのアクセスを確認する",
+	"Show the run history":                                 "実行履歴を表示する",
+	"Print a shell completion script":                      "シェル補完スクリプトを出力する",
+	"Show help for a command":                              "コマンドのヘルプを表示する",
+	"usage: completion bash | zsh | fish | powershell":     "使い方: completion bash | zsh | fish | powershell",
+	"unsupported shell: %s":                                "対応していないシェルです: %s",
+	"usage: __complete jobs | profiles":                    "使い方: __complete jobs | profiles",
+	"unknown completion: %s":                               "不明な補完の種類です: %s",
+	"Take the AWS credentials from this profile of the shared credentials file instead of KINTONE_TO_S3_ACCESSKEY and KINTONE_TO_S3_SECRET": "KINTONE_TO_S3_ACCESSKEY と KINTONE_TO_S3_SECRET の代わりに、共有認証情報ファイルのこのプロファイルから AWS の認証情報を取得します",
+
+	// preflight
+	"kintone reachable (%s)":                        "kintoneへの接続 (%s)",
+	"kintone record read":                           "kintoneのレコード閲覧",
+	"kintone file read":                             "kintoneの添付ファイルのダウンロード",
+	"no attachment to download":                     "ダウンロードできる添付ファイルがありません",
+	"S3 client":                                     "S3クライアント",
+	"S3 reachable (%s)":                             "S3への接続 (%s)",
+	"S3 write (s3://%s)":                            "S3への書き込み (s3://%s)",
+	"S3 write with KMS key %s (s3://%s)":            "KMSキー %s を使ったS3への書き込み (s3://%s)",
+	"%d of %d preflight checks failed":              "%[2]d 件中 %[1]d 件のチェックに失敗しました",
+	"pre-flight PutObject to s3://%s/%s failed: %v": "s3://%s/%s へのテスト書き込みに失敗しました: %v",
+
+	// throughput
+	"fetch":                            "取得",
+	"serialize":                        "変換",
+	"download":                         "添付ファイル",
+	"upload":                           "アップロード",
+	"%s: %.1f MB in %.1fs (%.2f MB/s)": "%s: %.1f MB / %.1f秒 (%.2f MB/秒)",
+	"%s: %d records, %.1f MB in %.1fs (%.0f records/s, %.2f MB/s)": "%s: %d 件, %.1f MB / %.1f秒 (%.0f 件/秒, %.2f MB/秒)",
+
+	// errors and warnings
+	"%s: unknown option: %s":     "%s: 不明なオプションです: %s",
+	"bucket name is missing: %s": "バケット名がありません: %s",
+	"not an s3:// URL: %s":       "s3:// で始まるURLではありません: %s",
+	"table name is missing: %s":  "テーブル名がありません: %s",
+	"%d records had characters which cannot be encoded in %s, replaced with %q":    "%d 件のレコードに %s で表せない文字があり、%q に置き換えました",
+	"%d records exported but kintone counted %d for the query":                     "%d 件のレコードを出力しましたが、クエリに対するkintoneの件数は %d 件です",
+	"S3 object key; {appId}, {domain}, {date}, {timestamp} and {ext} are expanded": "S3のオブジェクトキー。{appId}, {domain}, {date}, {timestamp}, {ext} は展開される",
+	"unknown placeholder in the object key: %s":                                    "オブジェクトキーに不明なプレースホルダがあります: %s",
+	"S3 object key (same as -k)":                                                   "S3のオブジェクトキー (-k と同じ)",
+	"invalid S3 object key: %q":                                                    "S3のオブジェクトキーが不正です: %q",
+	"-json-schema needs -o json":                                                   "-json-schema には -o json が必要です",
+	"-pin-revisions cannot be combined with -o json":                               "-pin-revisions は -o json と同時に指定できません",
+	"-chunk-records and -chunk-time cannot be combined with -o json":               "-chunk-records, -chunk-time は -o json と同時に指定できません",
+	"-pin-revisions cannot be combined with -chunk-records or -chunk-time":         "-pin-revisions は -chunk-records, -chunk-time と同時に指定できません",
+	"%d records changed during the export, fetching them again":                    "エクスポート中に %d 件のレコードが変更されたため、取得し直します",
+	"unknown -encoding-errors mode: %s":                                            "不明な -encoding-errors の指定です: %s",
+	"record %d: %q cannot be encoded in %s":                                        "レコード %d: %q は %s で表せません",
+	"record %d: replaced %s which cannot be encoded in %s":                         "レコード %d: %[3]s で表せない %[2]s を置き換えました",
+	"unknown encoding: %s":                                                         "不明な文字コードです: %s",
+	"unknown object ownership: %s":                                                 "不明なオブジェクト所有者設定です: %s",
+	"heartbeat interval must be positive":                                          "ハートビートの間隔は正の値を指定してください",
+	"state is locked by another run":                                               "別の実行がロックを保持しています",
+	"could not read schema cache: %v":                                              "フィールド定義のキャッシュを読み込めませんでした: %v",
+	"ignoring broken schema cache: %v":                                             "壊れたフィールド定義のキャッシュを無視します: %v",
+	"could not write schema cache: %v":                                             "フィールド定義のキャッシュを保存できませんでした: %v",
+	"invalid size: %s":                                                             "サイズの指定が正しくありません: %s",
+	"pprof listening on %s":                                                        "pprof を %s で公開しています",
+	"pprof server stopped: %v":                                                     "pprof サーバーが停止しました: %v",
+	"could not create CPU profile: %v":                                             "CPUプロファイルを作成できませんでした: %v",
+	"could not create heap profile: %v":                                            "ヒーププロファイルを作成できませんでした: %v",
+	"interrupted by %v":                                                            "%v により中断されました",
+	"keeping work directory %s":                                                    "作業ディレクトリ %s を残します",
+	"could not remove work directory %s: %v":                                       "作業ディレクトリ %s を削除できませんでした: %v",
+	"could not release lock: %v":                                                   "ロックを解放できませんでした: %v",
+	"could not remove probe object s3://%s/%s: %v":                                 "テスト用オブジェクト s3://%s/%s を削除できませんでした: %v",
+	"could not report task result: %v":                                             "タスクの結果を報告できませんでした: %v",
+	"could not save run history: %v":                                               "実行履歴を保存できませんでした: %v",
+	"could not send task heartbeat: %v":                                            "タスクのハートビートを送信できませんでした: %v",
+	"-append cannot be combined with -chunk-records or -chunk-time":                "-append は -chunk-records, -chunk-time と同時に指定できません",
+	"-sign-kms-key-id and -sign-key cannot be used together":                       "-sign-kms-key-id と -sign-key は同時に指定できません",
+	"no PEM private key in %s":                                                     "%s にPEM形式の秘密鍵がありません",
+	"unsupported private key in %s":                                                "%s は対応していない種類の秘密鍵です",
+	"unsupported private key":                                                      "対応していない種類の秘密鍵です",
+	"-audit-log needs an administrator's login (-u), not an API token":             "-audit-log にはAPIトークンではなく管理者のログイン名(-u)が必要です",
+	"-backfill cannot be combined with -c":                                         "-backfill と -c は同時に指定できません",
+	"-split-by cannot be combined with -chunk-records, -chunk-time or -append":     "-split-by は -chunk-records, -chunk-time, -append と同時に指定できません",
+	"unknown field: %s":                                                            "不明なフィールドです: %s",
+	"cannot split by a field of type %s: %s":                                       "%[1]s 型のフィールドでは分割できません: %[2]s",
+	"-chunk-records and -chunk-time need -state":                                   "-chunk-records と -chunk-time には -state が必要です",
+	"the query of a chunked export must not have order by, limit or offset":        "分割エクスポートのクエリには order by, limit, offset を指定できません",
+	"the query changed, restarting snapshot %s":                                    "クエリが変更されたため、スナップショット %s を最初からやり直します",
+	"snapshot %s: part %d exported, %d records so far":                             "スナップショット %s: パート %d をエクスポートしました(累計 %d 件)",
+	"snapshot %s: complete, %d records in %d parts":                                "スナップショット %s: 完了しました(%d 件, %d パート)",
+	"could not abort upload of s3://%s/%s: %v":                                     "s3://%s/%s のアップロードを中止できませんでした: %v",
+	"could not remove part s3://%s/%s: %v":                                         "パート s3://%s/%s を削除できませんでした: %v",
+	"derived column %s: %v":                                                        "計算列 %s: %v",
+	"derived column %s: unexpected %q":                                             "計算列 %s: 予期しない文字列です %q",
+	"derived column must be name=expression: %s":                                   "計算列は 名前=式 の形で指定してください: %s",
+	"missing ) after arguments of %s":                                              "%s の引数の後に ) がありません",
+	"unexpected %q in arguments of %s":                                             "%[2]s の引数に予期しない文字列があります %[1]q",
+	"unexpected %q":                                                                "予期しない文字列です %q",
+	"unexpected end of expression":                                                 "式が途中で終わっています",
+	"unknown function: %s":                                                         "不明な関数です: %s",
+	"unterminated string":                                                          "文字列が閉じられていません",
+	"wrong number of arguments for %s":                                             "%s の引数の数が正しくありません",
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"log"
+	"strings"
+	"time"
+)
+
+// the -latest-pointer object, telling where the newest export is
+type latestPointer struct {
+	Bucket     string    `json:"bucket"`
+	Key        string    `json:"key"`
+	RunId      string    `json:"run_id"`
+	Records    uint64    `json:"records"`
+	Bytes      int64     `json:"bytes"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+func setupLatest(latestKey, latestPointerKey string) error {
+	if latestKey == "" && latestPointerKey == "" {
+		return nil
+	}
+	if config.splitBy != "" || partitionColumns != nil || config.jsonDocumentRecords > 0 {
+		return errors.New(T("-latest-key and -latest-pointer need an export into one object, not -split-by, -partition-by or -json-document-records"))
+	}
+	for _, k := range []*string{&latestKey, &latestPointerKey} {
+		if *k == "" {
+			continue
+		}
+		key, err := expandKey(*k)
+		if err != nil {
+			return err
+		}
+		*k = strings.TrimPrefix(key, "/")
+		if *k == "" || strings.HasSuffix(*k, "/") || *k == config.key {
+			return errors.New(T("-latest-key and -latest-pointer need an object key of their own"))
+		}
+	}
+	config.latestKey, config.latestPointer = latestKey, latestPointerKey
+	return nil
+}
+
+// point the latest alias at key, once the export is complete. CopyObject
+// and PutObject replace an object at once, so readers see the previous
+// export or this one, never a mix.
+func updateLatest(svc *s3.S3, key string) error {
+	if config.latestKey != "" {
+		if err := copyObject(svc, svc, config.bucketName, config.latestKey, key); err != nil {
+			return err
+		}
+		log.Printf(T("s3://%s/%s now has the export of s3://%s/%s"), config.bucketName, config.latestKey, config.bucketName, key)
+	}
+
+	if config.latestPointer != "" {
+		records := run.Records
+		if chunk != nil {
+			records = chunk.Records
+		}
+		data, err := json.MarshalIndent(&latestPointer{
+			Bucket:     config.bucketName,
+			Key:        key,
+			RunId:      run.Id,
+			Records:    records,
+			Bytes:      run.Bytes,
+			ExportedAt: run.StartedAt.UTC(),
+		}, "", "  ")
+		if err != nil {
+			return err
+		}
+		input := newPutObjectInput(config.latestPointer, bytes.NewReader(data))
+		input.ContentType = aws.String("application/json")
+		if _, err := svc.PutObject(input); err != nil {
+			return err
+		}
+	}
+	return nil
+}
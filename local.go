@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/kintone/go-kintone"
+	"io"
+	"os"
+	"strings"
+)
+
+// -output: "-" for standard output, local:<path> for a file,
+// az://<container>/<blob> for Azure Blob Storage, or "" for S3
+const LOCAL_OUTPUT_PREFIX = "local:"
+
+func localOutput() bool {
+	return config.output != ""
+}
+
+// whether the records go to the S3 bucket, not to -output, Firehose or
+// the Arrow stream
+func s3Output() bool {
+	return !localOutput() && config.firehoseStream == "" && config.format != "arrow"
+}
+
+func validateOutputOptions() error {
+	if config.output == "" || config.output == "-" {
+		return nil
+	}
+	if strings.HasPrefix(config.output, AZURE_OUTPUT_PREFIX) {
+		if _, _, err := parseAzureOutput(config.output); err != nil {
+			return err
+		}
+		if config.azureConnectionString == "" && config.azureAccount == "" {
+			return errors.New(T("-output az:// needs -azure-connection-string or -azure-account"))
+		}
+	} else if !strings.HasPrefix(config.output, LOCAL_OUTPUT_PREFIX) || config.output == LOCAL_OUTPUT_PREFIX {
+		return fmt.Errorf(T("-output must be '-', 'local:<path>' or 'az://<container>/<blob>': %s"), config.output)
+	}
+	if config.format != "csv" && config.format != "json" {
+		return errors.New(T("-output needs -o csv or -o json"))
+	}
+	if config.splitBy != "" || partitionColumns != nil || config.jsonDocumentRecords > 0 || chunked() || config.appendMode {
+		return errors.New(T("-output writes one file, it cannot be combined with -split-by, -partition-by, -json-document-records, -chunk-records or -append"))
+	}
+	return nil
+}
+
+// write the records to standard output, a local file or Azure Blob Storage
+// instead of S3, for pipelines without a bucket and for trying the options
+// out
+func exportLocal(app *kintone.App) error {
+	startRun()
+	run.Destination = config.output
+
+	err := func() error {
+		unlock, err := openState()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		if err := createWorkdir(); err != nil {
+			return err
+		}
+		defer removeWorkdir()
+
+		if run.TotalCount, err = fetchTotalCount(); err != nil {
+			return err
+		}
+
+		var out io.Writer = os.Stdout
+		var file *os.File
+		var blob *azureBlobWriter
+		path := strings.TrimPrefix(config.output, LOCAL_OUTPUT_PREFIX)
+		if strings.HasPrefix(config.output, AZURE_OUTPUT_PREFIX) {
+			if blob, err = newAzureBlobWriter(config.output); err != nil {
+				return err
+			}
+			out = blob
+		} else if config.output != "-" {
+			// written next to the file and renamed, so a failed run leaves
+			// the previous file in place
+			if file, err = os.Create(path + ".tmp"); err != nil {
+				return err
+			}
+			defer os.Remove(path + ".tmp")
+			defer file.Close()
+			out = file
+		}
+		counter := &countingWriter{w: out}
+		out = counter
+		gz := compressWriter(out)
+		if gz != nil {
+			out = gz
+		}
+		writer := bufio.NewWriter(out)
+
+		var records uint64
+		if config.format == "json" {
+			err = writeJson(app, config.query, writer, &records)
+		} else {
+			err = writeCsv(app, config.query, writer, &records)
+		}
+		if err == nil {
+			err = writer.Flush()
+		}
+		if err == nil && gz != nil {
+			err = gz.Close()
+		}
+		run.Bytes = counter.n
+		addStage(STAGE_SERIALIZE, 0, 0, counter.n)
+		if err != nil {
+			return err
+		}
+		// the file and the blob are only put in place with the right count
+		if err := reconcileRecordCount(); err != nil {
+			return err
+		}
+		if file != nil {
+			if err := file.Close(); err != nil {
+				return err
+			}
+			if err := os.Rename(path+".tmp", path); err != nil {
+				return err
+			}
+		}
+		if blob != nil {
+			blob.metadata = provenanceMetadata(true)
+			if err := blob.Close(); err != nil {
+				return err
+			}
+		}
+
+		if config.fileDir != "" {
+			return publishAttachments()
+		}
+		return nil
+	}()
+	endRun(err)
+	return err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
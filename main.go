@@ -5,50 +5,155 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/howeyc/gopass"
 	"github.com/kintone/go-kintone"
 	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
 	"golang.org/x/text/encoding/unicode"
 	"golang.org/x/text/transform"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 type Configure struct {
-	login             string
-	password          string
-	basicAuthUser     string
-	basicAuthPassword string
-	apiToken          string
-	domain            string
-	basic             string
-	format            string
-	query             string
-	appId             uint64
-	fields            []string
-	filePath          string
-	deleteAll         bool
-	encoding          string
-	guestSpaceId      uint64
-	fileDir           string
-	accessKey         string
-	secretAccessKey   string
-	region            string
-	bucketName        string
+	login                   string
+	password                string
+	basicAuthUser           string
+	basicAuthPassword       string
+	apiToken                string
+	domain                  string
+	basic                   string
+	format                  string
+	query                   string
+	appId                   uint64
+	fields                  []string
+	filePath                string
+	deleteAll               bool
+	encoding                string
+	guestSpaceId            uint64
+	fileDir                 string
+	accessKey               string
+	secretAccessKey         string
+	sessionToken            string
+	awsProfile              string
+	vaultAwsCreds           string
+	region                  string
+	bucketName              string
+	bucketOwner             string
+	objectOwnership         string
+	sseKmsKeyId             string
+	sse                     string
+	storageClass            string
+	acl                     string
+	recordHash              bool
+	tagging                 string
+	pipe                    string
+	s3Endpoint              string
+	uploadRetries           int
+	presign                 time.Duration
+	presignWebhook          string
+	eventFormat             string
+	rateLimit               float64
+	rateLimitStore          string
+	redactDrop              []*redactRule
+	redactMask              []*redactRule
+	redactMaskValue         string
+	canonicalJSON           bool
+	s3Checksum              bool
+	sha256Sidecar           bool
+	createBucket            bool
+	jsonMetadata            bool
+	jsonDocumentRecords     int
+	envelopeKmsKeyId        string
+	objectLockMode          string
+	objectLockRetainUntil   time.Time
+	replicas                []replicaTarget
+	latestKey               string
+	latestPointer           string
+	ifNotExists             string
+	keyTemplate             string
+	keep                    int
+	optionCatalog           bool
+	manifest                bool
+	attempt                 int
+	compress                string
+	output                  string
+	azureConnectionString   string
+	azureAccount            string
+	schemaContract          string
+	schemaBreaking          string
+	firehoseStream          string
+	queueAttachmentFailures bool
+	sqsQueueURL             string
+	snsTopicArn             string
+	eventBus                string
+	glueTable               string
+	redshiftTable           string
+	redshiftIamRole         string
+	window                  string
+	snapshotTags            stringList
+	truncateText            int
+	s3ForcePathStyle        bool
+	s3Accelerate            bool
+	maintenanceWait         time.Duration
+	probe                   bool
+	history                 string
+	taskToken               string
+	taskHeartbeat           time.Duration
+	state                   string
+	lockTTL                 time.Duration
+	schemaTTL               time.Duration
+	refreshSchema           bool
+	dedupeBy                []string
+	derived                 []*derivedColumn
+	workdirBase             string
+	keepWorkdir             bool
+	maxMemory               int64
+	pprofAddr               string
+	cpuProfile              string
+	memProfile              string
+	maxIdleConns            int
+	maxIdleConnsPerHost     int
+	maxConnsPerHost         int
+	idleConnTimeout         time.Duration
+	chunkRecords            int
+	chunkTime               time.Duration
+	partSize                int64
+	uploadQueue             int
+	appendMode              bool
+	signKmsKeyId            string
+	signAlgorithm           string
+	signKeyFile             string
+	auditLog                bool
+	auditLogSince           time.Duration
+	auditLogPath            string
+	key                     string
+	splitBy                 string
+	splitParallel           int
+	encodingErrors          string
+	encodingPlaceholder     string
+	allowCountMismatch      bool
+	pinRevisions            bool
+	unquotedNumbers         bool
+	jsonSchema              bool
+	startJitter             time.Duration
+	blackouts               []*blackoutWindow
 }
 
 var config Configure
 
+// run state between invocations, nil unless -state is given
+var state StateStore
+
 const IMPORT_ROW_LIMIT = 100
 const EXPORT_ROW_LIMIT = 500
 
@@ -87,10 +192,27 @@ func (p Columns) Less(i, j int) bool {
 }
 
 func getFields(app *kintone.App) (map[string]*kintone.FieldInfo, error) {
-	fields, err := app.Fields()
+	useCache := state != nil && config.schemaTTL > 0
+	if useCache && !config.refreshSchema {
+		if fields := loadCachedFields(); fields != nil {
+			return fields, nil
+		}
+	}
+
+	var fields map[string]*kintone.FieldInfo
+	err := waitMaintenance(func() (err error) {
+		fields, err = app.Fields()
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
+
+	if useCache {
+		if err := saveCachedFields(fields); err != nil {
+			log.Printf(T("could not write schema cache: %v"), err)
+		}
+	}
 	return fields, nil
 }
 
@@ -130,54 +252,299 @@ func getColumn(code string, fields map[string]*kintone.FieldInfo) *Column {
 	return &column
 }
 
+// the BOM goes only at the start of the output, which the later parts of
+// a chunked export continue
+func bomPolicy() unicode.BOMPolicy {
+	if chunk != nil && chunk.Parts > 0 {
+		return unicode.IgnoreBOM
+	}
+	return unicode.ExpectBOM
+}
+
 func getEncoding() encoding.Encoding {
 	switch config.encoding {
 	case "utf-16":
 		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
 	case "utf-16be-with-signature":
-		return unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM)
+		return unicode.UTF16(unicode.BigEndian, bomPolicy())
 	case "utf-16le-with-signature":
-		return unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM)
+		return unicode.UTF16(unicode.LittleEndian, bomPolicy())
 	case "euc-jp":
 		return japanese.EUCJP
-	case "sjis":
+	case "sjis", "cp932", "windows-31j":
+		// the x/text Shift JIS is the Windows code page 932 variant
 		return japanese.ShiftJIS
+	case "iso-2022-jp":
+		return japanese.ISO2022JP
+	case "gb18030":
+		return simplifiedchinese.GB18030
 	default:
 		return nil
 	}
 }
 
+var encodings = []string{"utf-8", "utf-16", "utf-16be-with-signature", "utf-16le-with-signature", "sjis", "cp932", "windows-31j", "euc-jp", "iso-2022-jp", "gb18030"}
+
+func validateEncoding(name string) error {
+	for _, e := range encodings {
+		if e == name {
+			return nil
+		}
+	}
+	return fmt.Errorf(T("unknown encoding: %s"), name)
+}
+
 func main() {
 	var colNames string
+	var dedupeNames string
+	var configFile string
+	var derivedDefs stringList
+	var backfillNames string
+	var s3Key string
+	var objectLockMode, objectLockRetain string
+	var replicateDefs stringList
+	var latestKey, latestPointer string
+	var partitionBy string
+	var routesFile string
+	var blackoutDefs stringList
+	var s3Tags string
+	var redactDropDefs stringList
+	var redactMaskDefs stringList
+
+	// an optional command comes before the flags
+	command := ""
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command = args[0]
+		args = args[1:]
+	}
+
+	flag.StringVar(&config.login, "u", "", T("Login name"))
+	flag.StringVar(&config.password, "p", "", T("Password"))
+	flag.StringVar(&config.basicAuthUser, "U", "", T("Basic authentication user name"))
+	flag.StringVar(&config.basicAuthPassword, "P", "", T("Basic authentication password"))
+	flag.StringVar(&config.domain, "d", "", T("Domain name"))
+	flag.StringVar(&config.apiToken, "t", "", T("API token"))
+	flag.StringVar(&config.awsProfile, "aws-profile", "", T("Take the AWS credentials from this profile of the shared credentials file instead of KINTONE_TO_S3_ACCESSKEY and KINTONE_TO_S3_SECRET"))
+	flag.StringVar(&config.vaultAwsCreds, "vault-aws-creds", os.Getenv("KINTONE_TO_S3_VAULT_AWS_CREDS"), T("Take the AWS credentials from this path of Vault's AWS secrets engine, e.g. 'aws/creds/kintone-export'"))
+	flag.Uint64Var(&config.appId, "a", 0, T("App ID"))
+	flag.Uint64Var(&config.guestSpaceId, "g", 0, T("Guest Space ID"))
+	flag.StringVar(&config.compress, "compress", "", T("Compress the CSV or JSON before upload: 'gzip' ({ext} becomes csv.gz or json.gz)"))
+	flag.StringVar(&config.firehoseStream, "firehose-stream", os.Getenv("KINTONE_TO_S3_FIREHOSE_STREAM"), T("Push the records as NDJSON into this Kinesis Data Firehose delivery stream instead of writing an object (-o json)"))
+	flag.StringVar(&config.output, "output", "", T("Write the output to standard output ('-'), a local file ('local:/path/to/records.csv') or Azure Blob Storage ('az://container/records.csv') instead of S3"))
+	flag.StringVar(&config.azureConnectionString, "azure-connection-string", os.Getenv("AZURE_STORAGE_CONNECTION_STRING"), T("Connection string of the Azure storage account of -output az://, with AccountKey or SharedAccessSignature"))
+	flag.StringVar(&config.azureAccount, "azure-account", os.Getenv("AZURE_STORAGE_ACCOUNT"), T("Azure storage account of -output az://, written to with the managed identity (AZURE_CLIENT_ID for a user-assigned one)"))
+	flag.StringVar(&config.format, "o", "csv", T("Output format: 'json', 'csv'(default), 'sqlite' or 'arrow' (an Arrow IPC stream to stdout)"))
+	flag.StringVar(&config.query, "q", "", T("Query string"))
+	flag.StringVar(&colNames, "c", "", T("Field names (comma separated)"))
+	flag.StringVar(&config.filePath, "f", "", T("Input file path"))
+	flag.BoolVar(&config.deleteAll, "D", false, T("Delete all records before insert"))
+	flag.StringVar(&config.encoding, "e", "utf-8", T("Character encoding: 'utf-8'(default), 'utf-16', 'utf-16be-with-signature', 'utf-16le-with-signature', 'sjis' (or 'cp932'), 'euc-jp', 'iso-2022-jp' or 'gb18030'"))
+	flag.StringVar(&config.encodingErrors, "encoding-errors", ENCODING_ERRORS_REPLACE, T("Characters the encoding cannot represent: 'fail', 'replace'(default) with -encoding-placeholder, or 'report' to replace and list the records"))
+	flag.StringVar(&config.encodingPlaceholder, "encoding-placeholder", "?", T("Replacement for characters the encoding cannot represent"))
+	flag.BoolVar(&config.allowCountMismatch, "allow-count-mismatch", false, T("Only warn when the records exported differ from kintone's count for the query"))
+	flag.BoolVar(&config.pinRevisions, "pin-revisions", false, T("Fetch the records changed during the export again, so the output is one consistent snapshot"))
+	flag.BoolVar(&config.unquotedNumbers, "unquoted-numbers", false, T("Write $id, $revision and numeric fields without quotes in CSV"))
+	flag.StringVar(&config.pipe, "pipe", "", T("Command to pipe the CSV or JSON output through before the upload, e.g. 'gpg --encrypt -r ops@example.com'"))
+	flag.Var(&redactDropDefs, "redact-drop", T("Leave out the records matching this rule, e.g. 'privacy_flag = \"opt-out\"' (repeatable)"))
+	flag.Var(&redactMaskDefs, "redact-mask", T("Mask every value but $id and $revision of the records matching this rule (repeatable)"))
+	flag.StringVar(&config.redactMaskValue, "redact-mask-value", "***", T("Value of the fields of masked records"))
+	flag.BoolVar(&config.recordHash, "record-hash", false, T("Add a $hash column, a SHA-256 of the record's exported values, to tell changed records by"))
+	flag.BoolVar(&config.canonicalJSON, "canonical-json", false, T("Write JSON byte-identical for identical data: records by $id, sorted keys, stable numbers and UTC times"))
+	flag.BoolVar(&config.s3Checksum, "s3-checksum", true, T("Send a SHA-256 of each upload for S3 to verify and store with the object; turn off for stores without additional checksums"))
+	flag.BoolVar(&config.sha256Sidecar, "sha256-sidecar", false, T("Put a sha256sum-style <key>.sha256 next to the export to verify downloads by"))
+	flag.BoolVar(&config.jsonMetadata, "json-metadata", false, T("Add a metadata block to JSON documents: domain, app id, query, export time, kintone's count and run id"))
+	flag.IntVar(&config.jsonDocumentRecords, "json-document-records", 0, T("Write JSON as documents of at most this many records, each its own object: <key without extension>/part-00000-<run id>-<attempt>.json, ..."))
+	flag.IntVar(&config.truncateText, "truncate-text", 0, T("Cut text and rich text values longer than this many characters, putting the full values next to the export (<key>.overflow.jsonl)"))
+	flag.BoolVar(&config.manifest, "manifest", false, T("Write a JSON manifest of the objects of the export, their sizes, record counts and checksums, once all are uploaded (<key>.manifest.json, or manifest.json under the prefix of -split-by and -json-document-records)"))
+	flag.BoolVar(&config.optionCatalog, "option-catalog", false, T("Upload the options of the drop-down, radio button, check box and multi-choice fields next to the export (<key>.options.csv)"))
+	flag.BoolVar(&config.jsonSchema, "json-schema", false, T("Upload a JSON Schema of the records next to a JSON export (<key>.schema.json)"))
+	flag.StringVar(&config.fileDir, "b", "", T("Attachment file directory"))
+	flag.BoolVar(&config.queueAttachmentFailures, "queue-attachment-failures", false, T("Queue an attachment which fails to download in the -b directory for retry-failures instead of failing the export"))
+	defaultKey := os.Getenv("KINTONE_TO_S3_KEY")
+	if defaultKey == "" {
+		defaultKey = S3_KEY
+	}
+	flag.StringVar(&s3Key, "k", defaultKey, T("S3 object key; {appId}, {domain}, {date}, {timestamp} and {ext} are expanded"))
+	flag.StringVar(&s3Key, "s3-key", defaultKey, T("S3 object key (same as -k)"))
+	flag.StringVar(&config.bucketOwner, "s3-owner", os.Getenv("KINTONE_TO_S3_BUCKET_OWNER"), T("Expected bucket owner account ID (for cross-account delivery)"))
+	flag.StringVar(&config.objectOwnership, "s3-ownership", os.Getenv("KINTONE_TO_S3_OBJECT_OWNERSHIP"), T("Object ownership of the bucket: 'BucketOwnerEnforced', 'BucketOwnerPreferred' or 'ObjectWriter'"))
+	flag.StringVar(&config.sse, "sse", os.Getenv("KINTONE_TO_S3_SSE"), T("Server-side encryption: 'AES256' (SSE-S3) or 'aws:kms' (SSE-KMS, implied by -sse-kms-key-id)"))
+	flag.StringVar(&config.acl, "acl", os.Getenv("KINTONE_TO_S3_ACL"), T("Canned ACL of the objects, e.g. 'bucket-owner-full-control' (default: none, or bucket-owner-full-control for cross-account delivery)"))
+	flag.DurationVar(&config.presign, "presign", 0, T("Print a presigned GET URL of the export valid this long, e.g. 24h"))
+	flag.StringVar(&config.presignWebhook, "presign-webhook", os.Getenv("KINTONE_TO_S3_PRESIGN_WEBHOOK"), T("URL to POST the presigned URL to as JSON"))
+	flag.StringVar(&config.sqsQueueURL, "sqs-queue-url", os.Getenv("KINTONE_TO_S3_SQS_QUEUE_URL"), T("URL of an SQS queue to send a message with the bucket, key, record count and run to after the upload"))
+	flag.StringVar(&config.snsTopicArn, "sns-topic-arn", os.Getenv("KINTONE_TO_S3_SNS_TOPIC_ARN"), T("ARN of an SNS topic to publish the result of each run to, succeeded or failed"))
+	flag.StringVar(&config.eventBus, "event-bus", os.Getenv("KINTONE_TO_S3_EVENT_BUS"), T("Name or ARN of an EventBridge event bus to put a kintone-to-s3.export.completed or .failed event of each run on"))
+	flag.StringVar(&config.glueTable, "glue-table", os.Getenv("KINTONE_TO_S3_GLUE_TABLE"), T("Create or update this Glue table (<database>/<table>) over the CSV export after the upload, with its partitions, for Athena"))
+	flag.StringVar(&config.redshiftTable, "redshift-table", os.Getenv("KINTONE_TO_S3_REDSHIFT_TABLE"), T("Write a Redshift manifest of the CSV export and a COPY into this table (<schema>.<table>) next to it"))
+	flag.StringVar(&config.redshiftIamRole, "redshift-iam-role", os.Getenv("KINTONE_TO_S3_REDSHIFT_IAM_ROLE"), T("IAM role ARN of the COPY (default: the cluster's default role)"))
+	flag.StringVar(&config.window, "window", WINDOW_DAY, T("Window of the backfill command: 'day' (default) or 'month'"))
+	flag.StringVar(&config.eventFormat, "event-format", EVENT_FORMAT_TOOL, T("Shape of the completion messages: 'tool' (default) or 's3', like an S3 ObjectCreated event notification"))
+	defaultAttempt, _ := strconv.Atoi(os.Getenv("KINTONE_TO_S3_ATTEMPT"))
+	if defaultAttempt == 0 {
+		defaultAttempt = 1
+	}
+	flag.IntVar(&config.attempt, "attempt", defaultAttempt, T("Attempt number of a retried job, put into the part keys with the run id so attempts never mix their parts"))
+	flag.IntVar(&config.uploadRetries, "upload-retries", 5, T("Retries of a failed S3 request, with exponential backoff"))
+	flag.StringVar(&config.s3Endpoint, "s3-endpoint", os.Getenv("KINTONE_TO_S3_ENDPOINT"), T("Endpoint of an S3-compatible store such as MinIO or Wasabi, e.g. https://minio.example.com:9000"))
+	flag.BoolVar(&config.s3ForcePathStyle, "s3-force-path-style", os.Getenv("KINTONE_TO_S3_FORCE_PATH_STYLE") != "", T("Address the bucket in the path instead of the host name, as most S3-compatible stores need"))
+	flag.BoolVar(&config.createBucket, "create-bucket", false, T("Create the bucket in the region, with all public access blocked, when it does not exist"))
+	flag.BoolVar(&config.s3Accelerate, "s3-accelerate", os.Getenv("KINTONE_TO_S3_ACCELERATE") != "", T("Upload through the S3 Transfer Acceleration endpoint, which the bucket must have enabled"))
+	flag.StringVar(&s3Tags, "s3-tags", os.Getenv("KINTONE_TO_S3_TAGS"), T("Tags of the objects, e.g. 'app=123,env=prod,pii=true'"))
+	flag.IntVar(&config.keep, "keep", 0, T("Keep only this many of the newest exports of the object key, whose {date} or {timestamp} differ, deleting older ones after a successful run"))
+	flag.StringVar(&config.ifNotExists, "if-not-exists", "", T("When the object key exists: 'fail', or 'suffix' to export to key-1, key-2, ... (default: overwrite)"))
+	flag.StringVar(&latestKey, "latest-key", os.Getenv("KINTONE_TO_S3_LATEST_KEY"), T("Copy each complete export to this key too, e.g. 'latest/{appId}.{ext}', for consumers who want the newest one"))
+	flag.StringVar(&latestPointer, "latest-pointer", os.Getenv("KINTONE_TO_S3_LATEST_POINTER"), T("Put a JSON object at this key telling the key of the newest complete export"))
+	flag.StringVar(&config.schemaContract, "schema-contract", os.Getenv("KINTONE_TO_S3_SCHEMA_CONTRACT"), T("Check the export's columns against this contract before the upload: a JSON file, s3://bucket/key (written from the export when missing) or glue://database/table"))
+	flag.StringVar(&config.schemaBreaking, "schema-breaking", SCHEMA_BREAKING_FAIL, T("What a breaking change against -schema-contract does: 'fail' or 'warn'"))
+	flag.Var(&config.snapshotTags, "snapshot-tag", T("Tag the export with this label, e.g. 'pre-migration', in the run history and object metadata to find it by with runs tag and runs download (repeatable)"))
+	flag.Var(&replicateDefs, "replicate-to", T("Copy the export's objects to this bucket as well, e.g. 'dr-bucket@us-west-2' (repeatable)"))
+	flag.StringVar(&objectLockMode, "object-lock-mode", os.Getenv("KINTONE_TO_S3_OBJECT_LOCK_MODE"), T("Object Lock mode of the objects, GOVERNANCE or COMPLIANCE; the bucket must have Object Lock enabled"))
+	flag.StringVar(&objectLockRetain, "object-lock-retain", os.Getenv("KINTONE_TO_S3_OBJECT_LOCK_RETAIN"), T("How long Object Lock retains the objects: days, a duration or a date, e.g. 2555d or 2031-12-31"))
+	flag.StringVar(&config.storageClass, "storage-class", os.Getenv("KINTONE_TO_S3_STORAGE_CLASS"), T("Storage class of the objects, e.g. 'STANDARD_IA', 'INTELLIGENT_TIERING' or 'GLACIER_IR' (default: the bucket's)"))
+	flag.StringVar(&config.envelopeKmsKeyId, "envelope-kms-key-id", os.Getenv("KINTONE_TO_S3_ENVELOPE_KMS_KEY_ID"), T("Encrypt each object before the upload with a data key of this KMS key, kept encrypted in the object metadata; read them with the decrypt command"))
+	flag.StringVar(&config.sseKmsKeyId, "sse-kms-key-id", os.Getenv("KINTONE_TO_S3_SSE_KMS_KEY_ID"), T("KMS key ID or ARN for server-side encryption (may belong to the bucket owner's account)"))
+	flag.BoolVar(&config.probe, "s3-probe", false, T("Verify S3 write permission with a probe object before exporting"))
+	flag.StringVar(&config.history, "history", os.Getenv("KINTONE_TO_S3_HISTORY"), T("Run history location (s3://bucket/prefix)"))
+	flag.StringVar(&config.taskToken, "sfn-task-token", os.Getenv("KINTONE_TO_S3_TASK_TOKEN"), T("Step Functions task token to report the run result to"))
+	flag.DurationVar(&config.taskHeartbeat, "sfn-heartbeat", time.Minute, T("Interval of Step Functions task heartbeats"))
+	flag.StringVar(&config.state, "state", os.Getenv("KINTONE_TO_S3_STATE"), T("Run state location: a local directory, s3://bucket/prefix or dynamodb://table"))
+	flag.DurationVar(&config.lockTTL, "lock-ttl", 6*time.Hour, T("Expiry of the per-app run lock"))
+	flag.DurationVar(&config.schemaTTL, "schema-ttl", 0, T("Keep the app's field schema in the state store for this long (0 disables the cache)"))
+	flag.BoolVar(&config.refreshSchema, "refresh-schema", false, T("Fetch the field schema even if a cached one is still valid"))
+	flag.Var(&derivedDefs, "derive", T("Computed column as name=expression, e.g. 'full_name=concat(姓, \" \", 名)' (repeatable)"))
+	flag.StringVar(&configFile, "config", os.Getenv("KINTONE_TO_S3_CONFIG"), T("JSON config file of option values, keyed by option name, or the name of a job config in the directory of KINTONE_TO_S3_JOBS"))
+	flag.StringVar(&config.workdirBase, "workdir", os.Getenv("KINTONE_TO_S3_WORKDIR"), T("Directory to create the per-run work directory in (default: the system temp directory)"))
+	flag.BoolVar(&config.keepWorkdir, "keep-workdir", false, T("Keep the per-run work directory for debugging"))
+	flag.Var(&byteSize{&config.maxMemory}, "max-memory", T("Memory for the upload's part buffers, buffered pages and held output, e.g. 512MB; output held beyond it is spilled to the work directory (0 is unlimited)"))
+	flag.StringVar(&config.pprofAddr, "pprof", "", T("Serve net/http/pprof on this address, e.g. :6060"))
+	flag.StringVar(&config.cpuProfile, "cpuprofile", "", T("Write a CPU profile of the run to this file"))
+	flag.StringVar(&config.memProfile, "memprofile", "", T("Write a heap profile to this file on exit"))
+	flag.IntVar(&config.maxIdleConns, "http-max-idle-conns", 100, T("Idle HTTP connections kept for reuse across all hosts"))
+	flag.IntVar(&config.maxIdleConnsPerHost, "http-max-idle-conns-per-host", 16, T("Idle HTTP connections kept for reuse per host"))
+	flag.IntVar(&config.maxConnsPerHost, "http-max-conns-per-host", 0, T("Maximum HTTP connections per host (0 is unlimited)"))
+	flag.DurationVar(&config.idleConnTimeout, "http-idle-timeout", 90*time.Second, T("Close idle HTTP connections after this long"))
+	flag.IntVar(&config.chunkRecords, "chunk-records", 0, T("Export at most about this many records per run and resume from -state on the next run"))
+	flag.DurationVar(&config.chunkTime, "chunk-time", 0, T("Stop fetching after this long and resume from -state on the next run"))
+	flag.BoolVar(&config.appendMode, "append", false, T("Add the output as a new part file under today's date partition instead of replacing the object"))
+	flag.StringVar(&config.signKmsKeyId, "sign-kms-key-id", os.Getenv("KINTONE_TO_S3_SIGN_KMS_KEY_ID"), T("Asymmetric KMS key to sign the checksums of the output with"))
+	flag.StringVar(&config.signAlgorithm, "sign-algorithm", "RSASSA_PSS_SHA_256", T("KMS signing algorithm, e.g. 'RSASSA_PSS_SHA_256' or 'ECDSA_SHA_256'"))
+	flag.StringVar(&config.signKeyFile, "sign-key", "", T("PEM private key file (RSA, ECDSA or Ed25519) to sign the checksums of the output with"))
+	flag.BoolVar(&config.auditLog, "audit-log", false, T("Also export the audit log events of the app (needs an administrator's login)"))
+	flag.DurationVar(&config.auditLogSince, "audit-log-since", 24*time.Hour, T("Export the audit log events of this long before the run"))
+	flag.StringVar(&config.auditLogPath, "audit-log-path", "/api/v1/audit/logs.json", T("Path of the cybozu.com audit log API"))
+	config.partSize = 8 << 20
+	flag.Var(&byteSize{&config.partSize}, "part-size", T("Size of the parts the output is uploaded in, at least 5MB"))
+	flag.IntVar(&config.uploadQueue, "upload-queue", 2, T("Parts waiting for upload while the export goes on; each holds -part-size of memory"))
+	flag.StringVar(&lang, "lang", lang, T("Message language: 'ja' or 'en'"))
+	flag.StringVar(&backfillNames, "backfill", "", T("Export only $id and these fields (comma separated) into a narrow file to merge into an earlier export"))
+	flag.Float64Var(&config.rateLimit, "rate-limit", 0, T("kintone requests per second shared by all processes exporting from the domain (default: no limit)"))
+	flag.StringVar(&config.rateLimitStore, "rate-limit-store", os.Getenv("KINTONE_TO_S3_RATE_LIMIT_STORE"), T("Where the processes share the -rate-limit: dynamodb://table (default: -state if in DynamoDB)"))
+	flag.DurationVar(&config.maintenanceWait, "maintenance-wait", 2*time.Hour, T("How long to wait out kintone maintenance (503) before failing, 0 to fail at once"))
+	flag.DurationVar(&config.startJitter, "start-jitter", 0, T("Wait a random time up to this long before starting, e.g. 10m"))
+	flag.Var(&blackoutDefs, "blackout", T("Skip the run when it starts in this window, e.g. '01:00-03:00' or 'mon-fri 09:00-18:00' (repeatable)"))
+	flag.StringVar(&routesFile, "routes", os.Getenv("KINTONE_TO_S3_ROUTES"), T("JSON file of rules routing apps by ID or name to a bucket, prefix, format and KMS key"))
+	flag.StringVar(&partitionBy, "partition-by", "", T("Write under Hive-style prefixes of these columns (comma separated): app_id, dt and at most one field"))
+	flag.StringVar(&config.splitBy, "split-by", "", T("Export each value of this field (a drop-down, radio button or low-cardinality text) into its own object, in parallel"))
+	flag.IntVar(&config.splitParallel, "split-parallel", 4, T("Values of -split-by exported at a time"))
+	flag.StringVar(&dedupeNames, "dedupe-by", "", T("Drop duplicate records with the same values of these fields (comma separated), keeping the newest revision"))
+
+	flag.Usage = printUsage
+	flag.CommandLine.Parse(args)
+
+	if configFile != "" {
+		if err := loadConfigFile(jobConfigPath(configFile)); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	flag.StringVar(&config.login, "u", "", "Login name")
-	flag.StringVar(&config.password, "p", "", "Password")
-	flag.StringVar(&config.basicAuthUser, "U", "", "Basic authentication user name")
-	flag.StringVar(&config.basicAuthPassword, "P", "", "Basic authentication password")
-	flag.StringVar(&config.domain, "d", "", "Domain name")
-	flag.StringVar(&config.apiToken, "t", "", "API token")
-	flag.Uint64Var(&config.appId, "a", 0, "App ID")
-	flag.Uint64Var(&config.guestSpaceId, "g", 0, "Guest Space ID")
-	flag.StringVar(&config.format, "o", "csv", "Output format: 'json' or 'csv'(default)")
-	flag.StringVar(&config.query, "q", "", "Query string")
-	flag.StringVar(&colNames, "c", "", "Field names (comma separated)")
-	flag.StringVar(&config.filePath, "f", "", "Input file path")
-	flag.BoolVar(&config.deleteAll, "D", false, "Delete all records before insert")
-	flag.StringVar(&config.encoding, "e", "utf-8", "Character encoding: 'utf-8'(default), 'utf-16', 'utf-16be-with-signature', 'utf-16le-with-signature', 'sjis' or 'euc-jp'")
-	flag.StringVar(&config.fileDir, "b", "", "Attachment file directory")
-
-	flag.Parse()
+	if command != "" && command != COMPLETE_COMMAND && findCommand(command) == nil {
+		fmt.Fprintf(os.Stderr, T("unknown command: %s")+"\n", command)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// commands which need neither kintone nor the app
+	switch command {
+	case "help":
+		if err := helpCommand(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "completion":
+		if err := completionCommand(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case COMPLETE_COMMAND:
+		if err := completeCommand(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	config.accessKey = os.Getenv("KINTONE_TO_S3_ACCESSKEY")
 	config.secretAccessKey = os.Getenv("KINTONE_TO_S3_SECRET")
 	config.region = os.Getenv("KINTONE_TO_S3_REGION")
 	config.bucketName = os.Getenv("KINTONE_TO_S3_BUCKETNAME")
+	if bucketName, err := expandTemplate(config.bucketName); err != nil {
+		log.Fatal(err)
+	} else {
+		config.bucketName = bucketName
+	}
 
 	config.domain = os.Getenv("KINTONE_DOMAIN")
 	config.apiToken = os.Getenv("KINTONE_API_TOKEN")
 	appId, _ := strconv.ParseUint(os.Getenv("KINTONE_APP_ID"), 10, 64)
 	config.appId = appId
 
+	if err := resolveSecrets(); err != nil {
+		log.Fatal(err)
+	}
+
+	if command == "runs" {
+		// flags may also follow the runs sub command
+		runsArgs := flag.Args()
+		if len(runsArgs) > 0 {
+			flag.CommandLine.Parse(runsArgs[1:])
+			runsArgs = append(runsArgs[:1], flag.Args()...)
+		}
+		if err := runsCommand(runsArgs); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if command == "decrypt" {
+		if err := decryptCommand(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// flags may also follow the kind of schema
+	schemaArgs := flag.Args()
+	if command == "schema" && len(schemaArgs) > 0 {
+		flag.CommandLine.Parse(schemaArgs[1:])
+		schemaArgs = append(schemaArgs[:1], flag.Args()...)
+	}
+
+	// and the date field and range of backfill
+	var backfillArgs []string
+	if command == "backfill" {
+		rest := flag.Args()
+		for len(rest) > 0 {
+			if strings.HasPrefix(rest[0], "-") {
+				flag.CommandLine.Parse(rest)
+				rest = flag.Args()
+				continue
+			}
+			backfillArgs = append(backfillArgs, rest[0])
+			rest = rest[1:]
+		}
+	}
+
 	if config.appId == 0 || (config.apiToken == "" && (config.domain == "" || config.login == "")) {
 		flag.PrintDefaults()
 		return
@@ -187,6 +554,126 @@ func main() {
 		config.domain += ".cybozu.com"
 	}
 
+	// before the options are validated, a rule may change the format
+	if routesFile != "" {
+		key, err := applyRoutes(routesFile, s3Key)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s3Key = key
+	}
+
+	config.encoding = strings.ToLower(config.encoding)
+	if err := validateEncoding(config.encoding); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateEncodingErrors(config.encodingErrors); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateObjectOwnership(config.objectOwnership); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateRateLimitOptions(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateEventFormat(config.eventFormat); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validatePresignOptions(); err != nil {
+		log.Fatal(err)
+	}
+
+	if config.s3Accelerate && (config.s3Endpoint != "" || config.s3ForcePathStyle) {
+		log.Fatal(T("-s3-accelerate cannot be combined with -s3-endpoint or -s3-force-path-style"))
+	}
+
+	if err := validateACL(config.acl); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateCreateBucketOptions(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateAccessPointOptions(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateSSE(config.sse); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateStorageClass(config.storageClass); err != nil {
+		log.Fatal(err)
+	}
+
+	if tagging, err := parseTags(s3Tags); err != nil {
+		log.Fatal(err)
+	} else {
+		config.tagging = tagging
+	}
+
+	if config.taskToken != "" && config.taskHeartbeat <= 0 {
+		log.Fatal(T("heartbeat interval must be positive"))
+	}
+
+	if chunked() {
+		if err := validateChunkOptions(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if config.auditLog {
+		if err := validateAuditLogOptions(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if config.appendMode {
+		if err := validateAppendOptions(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if config.splitBy != "" {
+		if err := validateSplitOptions(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if config.pinRevisions {
+		if err := validatePinOptions(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if config.jsonSchema {
+		if err := validateJSONSchemaOptions(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if config.canonicalJSON && config.format != "json" {
+		log.Fatal(T("-canonical-json needs -o json"))
+	}
+
+	if config.format == "sqlite" {
+		if err := validateSqliteOptions(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := validateCompressOptions(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateMemoryOptions(); err != nil {
+		log.Fatal(err)
+	}
+
 	if colNames != "" {
 		config.fields = strings.Split(colNames, ",")
 		for i, field := range config.fields {
@@ -194,18 +681,140 @@ func main() {
 		}
 	}
 
+	if key, err := expandKey(s3Key); err != nil {
+		log.Fatal(err)
+	} else {
+		config.key = strings.TrimPrefix(key, "/")
+		config.keyTemplate = s3Key
+	}
+	if config.key == "" || strings.HasSuffix(config.key, "/") {
+		log.Fatalf(T("invalid S3 object key: %q"), s3Key)
+	}
+	if backfillNames != "" {
+		if command == "backfill" {
+			log.Fatal(T("the backfill command cannot be combined with -backfill"))
+		}
+		codes := strings.Split(backfillNames, ",")
+		for i, code := range codes {
+			codes[i] = strings.TrimSpace(code)
+		}
+		if err := setupBackfill(codes, colNames); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if partitionBy != "" {
+		if err := setupPartitions(partitionBy); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := validateJsonDocumentOptions(); err != nil {
+		log.Fatal(err)
+	}
+	if config.format == "arrow" {
+		if err := validateArrowOptions(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := validateOutputOptions(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateSnapshotTags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateSchemaContractOptions(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateFirehoseOptions(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateSqsOptions(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateGlueOptions(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateRedshiftOptions(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateEnvelopeOptions(); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupObjectLock(objectLockMode, objectLockRetain); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateIfNotExists(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateKeepOptions(); err != nil {
+		log.Fatal(err)
+	}
+	if config.attempt < 1 {
+		log.Fatal(T("-attempt must be 1 or more"))
+	}
+	if err := validateTruncateOptions(); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupLatest(latestKey, latestPointer); err != nil {
+		log.Fatal(err)
+	}
+	if replicas, err := parseReplicaTargets(replicateDefs); err != nil {
+		log.Fatal(err)
+	} else {
+		config.replicas = replicas
+	}
+
+	if blackouts, err := parseBlackouts(blackoutDefs); err != nil {
+		log.Fatal(err)
+	} else {
+		config.blackouts = blackouts
+	}
+
+	if rules, err := parseRedactRules(redactDropDefs); err != nil {
+		log.Fatal(err)
+	} else {
+		config.redactDrop = rules
+	}
+	if rules, err := parseRedactRules(redactMaskDefs); err != nil {
+		log.Fatal(err)
+	} else {
+		config.redactMask = rules
+	}
+
+	for _, def := range derivedDefs {
+		column, err := parseDerivedColumn(def)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config.derived = append(config.derived, column)
+	}
+
+	if config.fileDir != "" {
+		// the os package only applies the \\?\ long path prefix on Windows to absolute paths
+		fileDir, err := filepath.Abs(config.fileDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config.fileDir = fileDir
+	}
+
+	if dedupeNames != "" {
+		config.dedupeBy = strings.Split(dedupeNames, ",")
+		for i, field := range config.dedupeBy {
+			config.dedupeBy[i] = strings.TrimSpace(field)
+		}
+	}
+
 	var app *kintone.App
 
 	if config.basicAuthUser != "" && config.basicAuthPassword == "" {
-		fmt.Printf("Basic authentication password: ")
-		pass, _ := gopass.GetPasswd()
+		// the prompts go to stderr, stdout may be the output
+		pass, _ := gopass.GetPasswdPrompt(T("Basic authentication password: "), false, os.Stdin, os.Stderr)
 		config.basicAuthPassword = string(pass)
 	}
 
 	if config.apiToken == "" {
 		if config.password == "" {
-			fmt.Printf("Password: ")
-			pass, _ := gopass.GetPasswd()
+			pass, _ := gopass.GetPasswdPrompt(T("Password: "), false, os.Stdin, os.Stderr)
 			config.password = string(pass)
 		}
 
@@ -225,66 +834,399 @@ func main() {
 		}
 	}
 
+	app.Client = sharedHTTPClient()
+
 	if config.basicAuthUser != "" {
 		app.SetBasicAuth(config.basicAuthUser, config.basicAuthPassword)
 	}
 
-	var b bytes.Buffer
-	writer := bufio.NewWriter(&b)
+	if config.rateLimit > 0 {
+		var err error
+		if limiter, err = newRateLimiter(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	stopProfiling := startProfiling()
+
+	if command != "preflight" && command != "template" && command != "schema" && !waitForStart() {
+		stopProfiling()
+		return
+	}
 
+	// a bad key fails here, before there is a task left waiting for its result
 	var err error
-	err = writeCsv(app, writer)
-	//if config.filePath == "" {
-	//	if config.format == "json" {
-	//		err = writeJson(app, os.Stdout)
-	//	} else {
-	//		err = writeCsv(app, os.Stdout)
-	//	}
-	//} else {
-	//	var file *os.File
-	//	file, err = os.Open(config.filePath)
-	//	if err == nil {
-	//		defer file.Close()
-	//		err = readCsv(app, file)
-	//	}
-	//}
+	if exportSigner, err = newSigner(); err != nil {
+		stopProfiling()
+		log.Fatal(err)
+	}
+	if config.envelopeKmsKeyId != "" {
+		if envelopeKMS, err = newKMSClient(); err != nil {
+			stopProfiling()
+			log.Fatal(err)
+		}
+	}
+
+	// the task is reported once, whichever command or output ran
+	var task *taskReporter
+	if config.taskToken != "" {
+		if task, err = startTask(); err != nil {
+			stopProfiling()
+			log.Fatal(err)
+		}
+	}
+
+	switch {
+	case command == "preflight":
+		err = preflight(app)
+	case command == "template":
+		err = templateCommand(app)
+	case command == "schema":
+		err = schemaCommand(app, schemaArgs)
+	case command == "backfill":
+		err = backfillCommand(app, backfillArgs)
+	case command == "retry-failures":
+		err = retryFailuresCommand(app)
+	case config.format == "arrow":
+		err = exportArrow(app, os.Stdout)
+	case localOutput():
+		err = exportLocal(app)
+	case config.firehoseStream != "":
+		err = exportFirehose(app)
+	default:
+		err = export(app)
+	}
+	if task != nil {
+		task.finish(err)
+	}
+	stopProfiling()
 	if err != nil {
 		log.Fatal(err)
 	}
+}
+
+func export(app *kintone.App) error {
+	svc, err := newS3Client()
+	if err != nil {
+		return err
+	}
+
+	if len(config.replicas) > 0 {
+		trackWrites(svc)
+	}
+
+	startRun()
+	run.Destination = "s3://" + config.bucketName + "/" + config.key
+	err = exportToS3(app, svc)
+	if err == nil && run.Status != RUN_FAILED && len(config.replicas) > 0 {
+		err = replicate(svc)
+	}
+	endRun(err)
+	return err
+}
+
+func exportToS3(app *kintone.App, svc *s3.S3) error {
+	unlock, err := openState()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if config.createBucket {
+		if err := ensureBucket(svc); err != nil {
+			return err
+		}
+	}
+
+	if config.ifNotExists != "" {
+		if err := claimKey(svc); err != nil {
+			return err
+		}
+	}
+
+	if config.probe {
+		if err := probeBucket(svc, config.key); err != nil {
+			return err
+		}
+	}
+
+	if config.schemaContract != "" {
+		if err := checkSchemaContract(app); err != nil {
+			return err
+		}
+	}
+
+	if err := createWorkdir(); err != nil {
+		return err
+	}
+	defer removeWorkdir()
 
-	writer.Flush()
+	if chunked() {
+		if err := beginChunk(); err != nil {
+			return err
+		}
+	}
+
+	run.TotalCount, err = fetchTotalCount()
+	if err != nil {
+		return err
+	}
+
+	key := config.key
+	if config.format == "sqlite" {
+		err = exportSqlite(app, svc, key)
+	} else if config.jsonDocumentRecords > 0 {
+		err = exportJsonDocuments(app, svc)
+	} else if config.splitBy != "" {
+		err = exportSplit(app, svc)
+	} else {
+		key, err = exportSingle(app, svc)
+	}
+	if err != nil || run.Status == RUN_FAILED {
+		return err
+	}
+	addStage(STAGE_UPLOAD, 0, run.Records, 0)
+
+	if err := reconcileRecordCount(); err != nil {
+		return err
+	}
+
+	if config.jsonSchema {
+		if err := uploadJSONSchema(app, svc, config.key); err != nil {
+			return err
+		}
+	}
+
+	if config.optionCatalog {
+		if err := uploadOptionCatalog(app, svc, config.key); err != nil {
+			return err
+		}
+	}
+
+	if err := uploadOverflow(svc, config.key); err != nil {
+		return err
+	}
+
+	// the access history goes into the same drop, once the records are complete
+	if config.auditLog && (chunk == nil || chunk.complete) {
+		auditKey := key
+		if chunk != nil {
+			auditKey = config.key
+		}
+		if err := exportAuditLog(svc, auditKey); err != nil {
+			return err
+		}
+	}
+
+	// a split export has many objects, a chunked one none until the last part
+	if config.presign > 0 && config.splitBy == "" && config.jsonDocumentRecords == 0 && (chunk == nil || chunk.complete) {
+		if chunk != nil {
+			key = config.key
+		}
+		if err := publishPresignedURL(svc, key); err != nil {
+			return err
+		}
+	}
+
+	if config.fileDir != "" {
+		if err := publishAttachments(); err != nil {
+			return err
+		}
+	}
+
+	// the manifest and the alias only when everything of the export is in place
+	if chunk == nil || chunk.complete {
+		if chunk != nil {
+			key = config.key
+		}
+		if config.manifest {
+			if err := putManifest(svc, key); err != nil {
+				return err
+			}
+		}
+		if config.redshiftTable != "" {
+			if err := putRedshiftCopy(app, svc, key); err != nil {
+				return err
+			}
+		}
+		if config.glueTable != "" {
+			if err := registerGlueTable(app, svc); err != nil {
+				return err
+			}
+		}
+		if config.sqsQueueURL != "" {
+			if err := sendSqsMessage(svc, key); err != nil {
+				return err
+			}
+		}
+		if config.splitBy == "" {
+			if err := updateLatest(svc, key); err != nil {
+				return err
+			}
+			if config.keep > 0 {
+				return pruneExports(svc)
+			}
+		}
+	}
+	return nil
+}
+
+// export into one object: config.key, a part of a chunked export or a
+// part of the day's partition. returns the key written.
+func exportSingle(app *kintone.App, svc *s3.S3) (string, error) {
+	key := config.key
+	var partition *partitionManifest
+	var err error
+	if chunk != nil {
+		key = chunkPartKey(chunk.Snapshot, chunk.Parts)
+	} else if config.appendMode {
+		partition, err = loadPartitionManifest(svc)
+		if err != nil {
+			return "", err
+		}
+		key, err = nextPartKey(svc, partition)
+		if err != nil {
+			return "", err
+		}
+		run.Destination = "s3://" + config.bucketName + "/" + key
+	}
+
+	upload, err := exportObject(app, svc, config.query, key)
+	if err != nil {
+		return key, err
+	}
+
+	if partition != nil {
+		if err := savePartitionManifest(svc, partition, key); err != nil {
+			return "", err
+		}
+	}
+
+	if chunk != nil {
+		if err := endChunk(svc, upload); err != nil {
+			return "", err
+		}
+	}
+	return key, nil
+}
+
+// write the records of the query to key and sign the object
+func exportObject(app *kintone.App, svc *s3.S3, query string, key string) (*uploadPipeline, error) {
+	// full parts are uploaded while the rest is still being written; the
+	// parts in flight are bounded by -upload-queue
+	upload := newUploadPipeline(svc, key)
+	if chunk == nil {
+		upload.metadata = provenanceMetadata
+	} else {
+		// the sum of the snapshot so far, carried on by this part
+		h, err := chunk.resumeHash()
+		if err != nil {
+			return nil, err
+		}
+		upload.hash = h
+	}
+	upload.ifNoneMatch = config.ifNotExists != "" && key == config.key
+
+	var out io.Writer = upload
+	env, err := encryptUpload(upload)
+	if err != nil {
+		upload.Abort()
+		return nil, err
+	}
+	if env != nil {
+		out = env
+	}
+	gz := compressWriter(out)
+	if gz != nil {
+		out = gz
+	}
+	var hook *pipeHook
+	if config.pipe != "" {
+		if hook, err = startPipe(config.pipe, out); err != nil {
+			upload.Abort()
+			return nil, err
+		}
+		out = hook
+	}
+	writer := bufio.NewWriter(out)
+
+	var records uint64
+	if config.format == "json" {
+		err = writeJson(app, query, writer, &records)
+	} else {
+		err = writeCsv(app, query, writer, &records)
+	}
+	if err != nil {
+		if hook != nil {
+			hook.Kill()
+		}
+		upload.Abort()
+		return nil, err
+	}
 
 	// S3へのアップロード
-	sess, err := session.NewSession()
-	svc := s3.New(sess, &aws.Config{
-		Credentials: credentials.NewStaticCredentials(config.accessKey, config.secretAccessKey, ""),
-		Region:      aws.String(config.region),
-	})
-	_, err = svc.PutObject(&s3.PutObjectInput{
-		Bucket: aws.String(config.bucketName),
-		Key:    aws.String("golang-kintone-to-s3.csv"),
-		ACL:    aws.String("public-read"),
-		Body:   bytes.NewReader(b.Bytes()),
-	})
+	err = writer.Flush()
+	if hook != nil {
+		if err != nil {
+			hook.Kill()
+		} else if err = hook.Close(); err != nil {
+			upload.Abort()
+			return nil, err
+		}
+	}
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+	if err == nil && env != nil {
+		err = env.Close()
+	}
+	if err == nil && config.splitBy == "" {
+		// the object of the whole run is only completed with the right count
+		if err := reconcileRecordCount(); err != nil {
+			upload.Abort()
+			return nil, err
+		}
+	}
+	if err == nil {
+		err = upload.Close()
+	} else {
+		upload.Abort()
+	}
+	atomic.AddInt64(&run.Bytes, upload.Len())
+	addStage(STAGE_SERIALIZE, 0, 0, upload.Len())
 	if err != nil {
-		log.Println(err.Error())
+		return nil, uploadError(key, err)
 	}
 
+	if exportSigner != nil && chunk == nil {
+		if err := signObject(svc, key, upload.Len(), upload.Sum()); err != nil {
+			return nil, err
+		}
+	}
+	if config.sha256Sidecar && chunk == nil {
+		if err := putChecksumSidecar(svc, key, upload.Sum()); err != nil {
+			return nil, err
+		}
+	}
+	if chunk == nil {
+		addManifestObject(key, upload.Len(), records, upload.Sum())
+	}
+	return upload, nil
 }
 
-func getRecords(app *kintone.App, fields []string, offset int64) ([]*kintone.Record, bool, error) {
+func getRecords(app *kintone.App, query string, fields []string, offset int64) ([]*kintone.Record, bool, error) {
 
 	r := regexp.MustCompile(`limit\s+\d+`)
-	if r.MatchString(config.query) {
-		records, err := app.GetRecords(fields, config.query)
+	if r.MatchString(query) {
+		records, err := fetchRecords(app, fields, query)
 
 		if err != nil {
 			return nil, true, err
 		}
 		return records, true, nil
 	} else {
-		newQuery := config.query + fmt.Sprintf(" limit %v offset %v", EXPORT_ROW_LIMIT, offset)
-		records, err := app.GetRecords(fields, newQuery)
+		newQuery := query + fmt.Sprintf(" limit %v offset %v", EXPORT_ROW_LIMIT, offset)
+		records, err := fetchRecords(app, fields, newQuery)
 
 		if err != nil {
 			return nil, true, err
@@ -293,6 +1235,38 @@ func getRecords(app *kintone.App, fields []string, offset int64) ([]*kintone.Rec
 	}
 }
 
+// fields to request from kintone: the columns, plus what the dedupe
+// filter and derived columns read
+func fetchFields() []string {
+	if config.fields == nil {
+		return nil
+	}
+
+	extra := make([]string, 0)
+	if len(config.dedupeBy) > 0 || chunk != nil {
+		extra = append(extra, "$id")
+	}
+	for _, d := range config.derived {
+		extra = append(extra, d.expr.fields()...)
+	}
+	extra = append(extra, redactFields()...)
+
+	fields := append([]string{}, config.fields...)
+	for _, code := range extra {
+		found := false
+		for _, f := range fields {
+			if f == code {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fields = append(fields, code)
+		}
+	}
+	return fields
+}
+
 func getWriter(writer io.Writer) io.Writer {
 	encoding := getEncoding()
 	if encoding == nil {
@@ -301,33 +1275,118 @@ func getWriter(writer io.Writer) io.Writer {
 	return transform.NewWriter(writer, encoding.NewEncoder())
 }
 
-func writeJson(app *kintone.App, _writer io.Writer) error {
+// flush what the encoder holds back, e.g. the ISO-2022-JP return to ASCII
+func closeWriter(writer io.Writer) {
+	if c, ok := writer.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// records is set to the records written
+func writeJson(app *kintone.App, query string, _writer io.Writer, records *uint64) error {
+	open := func(part int) (io.Writer, error) {
+		return _writer, nil
+	}
+	end := func(n int) error {
+		*records += uint64(n)
+		return nil
+	}
+	return writeJsonDocuments(app, query, open, end)
+}
+
+// write the records as {"records": [...]} documents, opening another one
+// every -json-document-records records. end is called with the records of
+// each as it is complete.
+func writeJsonDocuments(app *kintone.App, query string, open func(part int) (io.Writer, error), end func(records int) error) error {
 	i := 0
-	offset := int64(0)
-	writer := getWriter(_writer)
+	part := 0
+	var writer io.Writer
+	begin := func() error {
+		w, err := open(part)
+		if err != nil {
+			return err
+		}
+		writer = getWriter(w)
+		return writeJsonHeader(writer, part)
+	}
+	finish := func() error {
+		fmt.Fprint(writer, "\n]}")
+		closeWriter(writer)
+		writer = nil
+		part += 1
+		return end(i)
+	}
+	defer func() {
+		if writer != nil {
+			closeWriter(writer)
+		}
+	}()
+
+	if config.canonicalJSON {
+		query = canonicalQuery(query)
+	}
 
-	fmt.Fprint(writer, "{\"records\": [\n")
-	for ; ; offset += EXPORT_ROW_LIMIT {
-		records, eof, err := getRecords(app, config.fields, offset)
+	duplicates, err := dedupeFilter(app, query)
+	if err != nil {
+		return err
+	}
+
+	pages := fetchPages(app, query, fetchFields())
+	defer pages.stop()
+
+	if err := begin(); err != nil {
+		return err
+	}
+	for {
+		records, err := pages.next()
 		if err != nil {
 			return err
 		}
+		if records == nil {
+			break
+		}
+		started := time.Now()
 		for _, record := range records {
+			if duplicates[record.Id()] {
+				atomic.AddUint64(&run.Duplicates, 1)
+				continue
+			}
+			if redactRecord(record) {
+				continue
+			}
+			if err := truncateRecord(record); err != nil {
+				return err
+			}
+			if config.jsonDocumentRecords > 0 && i == config.jsonDocumentRecords {
+				if err := finish(); err != nil {
+					return err
+				}
+				if err := begin(); err != nil {
+					return err
+				}
+				i = 0
+			}
 			if i > 0 {
 				fmt.Fprint(writer, ",\n")
 			}
+			for name, value := range evalDerived(record) {
+				record.Fields[name] = kintone.SingleLineTextField(value)
+			}
 			jsonArray, _ := record.MarshalJSON()
-			json := string(jsonArray)
-			fmt.Fprint(writer, json)
+			if config.canonicalJSON {
+				if jsonArray, err = canonicalJSON(jsonArray); err != nil {
+					return err
+				}
+			}
+			if err := writeEncoded(writer, jsonArray, record.Id()); err != nil {
+				return err
+			}
+			atomic.AddUint64(&run.Records, 1)
 			i += 1
 		}
-		if eof {
-			break
-		}
+		addStage(STAGE_SERIALIZE, time.Since(started), uint64(len(records)), 0)
 	}
-	fmt.Fprint(writer, "\n]}")
-
-	return nil
+	return finish()
 }
 
 func makeColumns(fields map[string]*kintone.FieldInfo) Columns {
@@ -415,10 +1474,11 @@ func hasSubTable(columns []*Column) bool {
 	return false
 }
 
-func writeCsv(app *kintone.App, _writer io.Writer) error {
+// records is set to the records written
+func writeCsv(app *kintone.App, query string, _writer io.Writer, records *uint64) error {
 	i := uint64(0)
-	offset := int64(0)
 	writer := getWriter(_writer)
+	defer closeWriter(writer)
 	var columns Columns
 
 	// retrieve field list
@@ -427,106 +1487,196 @@ func writeCsv(app *kintone.App, _writer io.Writer) error {
 		return err
 	}
 
+	duplicates, err := dedupeFilter(app, query)
+	if err != nil {
+		return err
+	}
+
+	// with -pin-revisions the rows are held back until the records changed
+	// during the export are fetched again
+	var pin *revisionPin
+	if config.pinRevisions {
+		pin = newRevisionPin()
+		defer pin.Close()
+	}
+
+	pages := fetchPages(app, query, fetchFields())
+	defer pages.stop()
+
 	hasTable := false
-	for ; ; offset += EXPORT_ROW_LIMIT {
-		records, eof, err := getRecords(app, config.fields, offset)
+	for {
+		records, err := pages.next()
 		if err != nil {
 			return err
 		}
+		if records == nil {
+			break
+		}
+		started := time.Now()
 
 		for _, record := range records {
+			if duplicates[record.Id()] {
+				atomic.AddUint64(&run.Duplicates, 1)
+				continue
+			}
+			if redactRecord(record) {
+				continue
+			}
+			if err := truncateRecord(record); err != nil {
+				return err
+			}
 			if i == 0 {
 				// write csv header
-				if config.fields == nil {
+				if chunk != nil && chunk.Columns != nil {
+					columns = makePartialColumns(fields, chunk.Columns)
+				} else if config.fields == nil {
 					columns = makeColumns(fields)
 				} else {
 					columns = makePartialColumns(fields, config.fields)
 				}
+				columns = append(columns, derivedColumns()...)
+				if chunk != nil && chunk.Columns == nil {
+					chunk.Columns = chunkColumnCodes(columns)
+				}
 				//sort.Sort(columns)
-				j := 0
 				hasTable = hasSubTable(columns)
-				if hasTable {
-					fmt.Fprint(writer, "*")
-					j++
-				}
-				for _, f := range columns {
-					if j > 0 {
-						fmt.Fprint(writer, ",")
+				// the later parts of a chunked export continue the first one
+				if chunk == nil || chunk.Parts == 0 {
+					header := getRowBuffer()
+					j := 0
+					if hasTable {
+						header.WriteByte('*')
+						j++
+					}
+					for _, f := range columns {
+						if j > 0 {
+							header.WriteByte(',')
+						}
+						writeQuoted(header, f.Code)
+						j++
+					}
+					header.WriteString("\r\n")
+					var err error
+					if pin != nil {
+						pin.setHeader(header.Bytes(), columns, hasTable)
+					} else {
+						err = writeEncoded(writer, header.Bytes(), 0)
+					}
+					putRowBuffer(header)
+					if err != nil {
+						return err
 					}
-					fmt.Fprint(writer, "\""+f.Code+"\"")
-					j++
 				}
-				fmt.Fprint(writer, "\r\n")
 			}
 			rowId := record.Id()
 			if rowId == 0 {
 				rowId = i
 			}
 
-			// determine subtable's row count
-			rowNum := getSubTableRowCount(record, columns)
-
-			for j := 0; j < rowNum; j++ {
-				k := 0
-				if hasTable {
-					if j == 0 {
-						fmt.Fprint(writer, "*")
-					}
-					k++
+			// render all rows of the record, then write them at once
+			row := getRowBuffer()
+			rows, err := renderRecord(app, record, columns, hasTable, rowId, row)
+			if err == nil {
+				if pin != nil {
+					err = pin.add(record, row.Bytes(), rows)
+				} else {
+					err = writeEncoded(writer, row.Bytes(), record.Id())
 				}
+			}
+			putRowBuffer(row)
+			if err != nil {
+				return err
+			}
+			atomic.AddUint64(&run.Rows, rows)
+			i++
+			atomic.AddUint64(&run.Records, 1)
+		}
+		addStage(STAGE_SERIALIZE, time.Since(started), uint64(len(records)), 0)
+	}
 
-				for _, f := range columns {
-					if k > 0 {
-						fmt.Fprint(writer, ",")
-					}
+	if pin != nil {
+		err := pin.finish(app, query, writer)
+		*records = i - pin.dropped
+		return err
+	}
+	*records = i
+	return nil
+}
 
-					if f.Code == "$id" {
-						fmt.Fprintf(writer, "\"%d\"", record.Id())
-					} else if f.Code == "$revision" {
-						fmt.Fprintf(writer, "\"%d\"", record.Revision())
-					} else if f.Type == kintone.FT_SUBTABLE {
-						table := record.Fields[f.Code].(kintone.SubTableField)
-						if j < len(table) {
-							fmt.Fprintf(writer, "\"%d\"", table[j].Id())
-						}
-					} else if f.IsSubField {
-						table := record.Fields[f.Table].(kintone.SubTableField)
-						if j < len(table) {
-							subField := table[j].Fields[f.Code]
-							if f.Type == kintone.FT_FILE {
-								dir := fmt.Sprintf("%s-%d-%d", f.Code, rowId, j)
-								err := downloadFile(app, subField, dir)
-								if err != nil {
-									return err
-								}
-							}
-							fmt.Fprint(writer, "\""+escapeCol(toString(subField, "\n"))+"\"")
-						}
-					} else {
-						field := record.Fields[f.Code]
-						if field != nil {
-							if j == 0 && f.Type == kintone.FT_FILE {
-								dir := fmt.Sprintf("%s-%d", f.Code, rowId)
-								err := downloadFile(app, field, dir)
-								if err != nil {
-									return err
-								}
-							}
-							fmt.Fprint(writer, "\""+escapeCol(toString(field, "\n"))+"\"")
-						}
-					}
-					k++
-				}
-				fmt.Fprint(writer, "\r\n")
+// render the CSV rows of a record into row: one per subtable row, or one
+func renderRecord(app *kintone.App, record *kintone.Record, columns Columns, hasTable bool, rowId uint64, row *bytes.Buffer) (uint64, error) {
+	// determine subtable's row count
+	rowNum := getSubTableRowCount(record, columns)
+	derivedValues := evalDerived(record)
+
+	for j := 0; j < rowNum; j++ {
+		k := 0
+		if hasTable {
+			if j == 0 {
+				row.WriteByte('*')
 			}
-			i++
+			k++
 		}
-		if eof {
-			break
+
+		for _, f := range columns {
+			if k > 0 {
+				row.WriteByte(',')
+			}
+			value, ok, err := cellValue(app, record, f, j, rowId, derivedValues)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				writeValue(row, f, value)
+			}
+			k++
 		}
+		row.WriteString("\r\n")
 	}
+	return uint64(rowNum), nil
+}
 
-	return nil
+// the value of column f in the j-th row of a record, and whether the row
+// has a value there at all. attachments are downloaded on the way.
+func cellValue(app *kintone.App, record *kintone.Record, f *Column, j int, rowId uint64, derivedValues map[string]string) (string, bool, error) {
+	if f.Code == "$id" {
+		return strconv.FormatUint(record.Id(), 10), true, nil
+	} else if f.Code == "$revision" {
+		return strconv.FormatInt(record.Revision(), 10), true, nil
+	} else if f.Type == FT_DERIVED {
+		return derivedValues[f.Code], true, nil
+	} else if f.Type == kintone.FT_SUBTABLE {
+		table := record.Fields[f.Code].(kintone.SubTableField)
+		if j < len(table) {
+			return strconv.FormatUint(table[j].Id(), 10), true, nil
+		}
+	} else if f.IsSubField {
+		table := record.Fields[f.Table].(kintone.SubTableField)
+		if j < len(table) {
+			subField := table[j].Fields[f.Code]
+			if f.Type == kintone.FT_FILE {
+				dir := fmt.Sprintf("%s-%d-%d", f.Code, rowId, j)
+				err := downloadFile(app, subField, dir)
+				if err != nil {
+					return "", false, err
+				}
+			}
+			return toString(subField, "\n"), true, nil
+		}
+	} else {
+		field := record.Fields[f.Code]
+		if field != nil {
+			if j == 0 && f.Type == kintone.FT_FILE {
+				dir := fmt.Sprintf("%s-%d", f.Code, rowId)
+				err := downloadFile(app, field, dir)
+				if err != nil {
+					return "", false, err
+				}
+			}
+			return toString(field, "\n"), true, nil
+		}
+	}
+	return "", false, nil
 }
 
 func downloadFile(app *kintone.App, field interface{}, dir string) error {
@@ -543,50 +1693,50 @@ func downloadFile(app *kintone.App, field interface{}, dir string) error {
 		return nil
 	}
 
-	fileDir := fmt.Sprintf("%s%c%s", config.fileDir, os.PathSeparator, dir)
+	fileDir := filepath.Join(attachmentStagingDir(), dir)
 	if err := os.MkdirAll(fileDir, 0777); err != nil {
 		return err
 	}
 
+	// files of the same name, or made the same by safeFileName, get a suffix;
+	// names are compared ignoring case, as Windows does
+	used := make(map[string]bool, len(v))
 	for idx, file := range v {
-		path := fmt.Sprintf("%s%c%s", fileDir, os.PathSeparator, file.Name)
-		data, err := app.Download(file.FileKey)
-		if err != nil {
-			return err
-		}
-
-		fo, err := os.Create(path)
-		if err != nil {
-			return err
+		safe := safeFileName(file.Name)
+		name := safe
+		for n := 1; used[strings.ToLower(name)]; n++ {
+			name = suffixedKey(safe, n)
 		}
-		defer fo.Close()
-
-		// make a buffer to keep chunks that are read
-		buf := make([]byte, 256*1024)
-		for {
-			// read a chunk
-			n, err := data.Reader.Read(buf)
-			if err != nil && err != io.EOF {
-				return err
-			}
-			if n == 0 {
-				break
-			}
-
-			// write a chunk
-			if _, err := fo.Write(buf[:n]); err != nil {
+		used[strings.ToLower(name)] = true
+		path := filepath.Join(fileDir, name)
+		if err := downloadAttachment(app, file.FileKey, path); err != nil {
+			if !config.queueAttachmentFailures {
 				return err
 			}
+			queueAttachmentFailure(file.FileKey, dir, name, err)
 		}
 
-		v[idx].Name = fmt.Sprintf("%s%c%s", dir, os.PathSeparator, file.Name)
+		v[idx].Name = filepath.Join(dir, name)
 	}
 
 	return nil
 }
 
-func escapeCol(s string) string {
-	return strings.Replace(s, "\"", "\"\"", -1)
+func saveFile(path string, r io.Reader) (int64, error) {
+	fo, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+
+	buf := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(buf)
+
+	n, err := io.CopyBuffer(fo, r, *buf)
+	if err != nil {
+		fo.Close()
+		return n, err
+	}
+	return n, fo.Close()
 }
 
 func getType(f interface{}) string {
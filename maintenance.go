@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"github.com/kintone/go-kintone"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	MAINTENANCE_FIRST_WAIT = 30 * time.Second
+	MAINTENANCE_MAX_WAIT   = 10 * time.Minute
+)
+
+// kintone answers 503 while it is in maintenance
+func inMaintenance(err error) bool {
+	switch e := err.(type) {
+	case *kintone.AppError:
+		return e.HTTPStatusCode == http.StatusServiceUnavailable
+	case *restError:
+		return e.code == http.StatusServiceUnavailable
+	}
+	return false
+}
+
+// call a kintone API under the shared -rate-limit, waiting out a
+// maintenance window for up to
+// -maintenance-wait with growing pauses. the export carries on from the
+// page it was fetching, as if the window had not been there.
+func waitMaintenance(call func() error) error {
+	deadline := time.Now().Add(config.maintenanceWait)
+	wait := MAINTENANCE_FIRST_WAIT
+	for {
+		if limiter != nil {
+			if err := limiter.wait(); err != nil {
+				return err
+			}
+		}
+		err := call()
+		if err == nil || !inMaintenance(err) {
+			return err
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return fmt.Errorf(T("kintone is still in maintenance after %v: %v"), config.maintenanceWait, err)
+		}
+		log.Printf(T("kintone is in maintenance, retrying in %v"), wait)
+		time.Sleep(wait)
+		if wait *= 2; wait > MAINTENANCE_MAX_WAIT {
+			wait = MAINTENANCE_MAX_WAIT
+		}
+	}
+}
+
+func fetchRecords(app *kintone.App, fields []string, query string) ([]*kintone.Record, error) {
+	var records []*kintone.Record
+	err := waitMaintenance(func() (err error) {
+		records, err = app.GetRecords(fields, query)
+		return err
+	})
+	return records, err
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"log"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// an object of the export, as listed in the manifest
+type manifestObject struct {
+	Key     string `json:"key"`
+	Bytes   int64  `json:"bytes"`
+	Records uint64 `json:"records"`
+	Sha256  string `json:"sha256"`
+}
+
+// the -manifest object, written once every object of the run is in place
+type manifest struct {
+	RunId         string           `json:"run_id"`
+	Attempt       int              `json:"attempt"`
+	Domain        string           `json:"domain"`
+	AppId         uint64           `json:"app_id"`
+	Query         string           `json:"query"`
+	SchemaVersion string           `json:"schema_version"`
+	ExportedAt    time.Time        `json:"exported_at"`
+	Records       uint64           `json:"records"`
+	Objects       []manifestObject `json:"objects"`
+}
+
+// the objects written by the run so far
+var manifestObjects struct {
+	sync.Mutex
+	list []manifestObject
+}
+
+func addManifestObject(key string, bytes int64, records uint64, sum []byte) {
+	if !config.manifest && config.redshiftTable == "" {
+		return
+	}
+	manifestObjects.Lock()
+	defer manifestObjects.Unlock()
+	manifestObjects.list = append(manifestObjects.list, manifestObject{
+		Key:     key,
+		Bytes:   bytes,
+		Records: records,
+		Sha256:  hex.EncodeToString(sum),
+	})
+}
+
+// <key without extension>.manifest.json
+func manifestKey(key string) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + ".manifest.json"
+}
+
+// the revision of the app's form, which changes with every field change
+func fetchFormRevision() (string, error) {
+	params := url.Values{}
+	params.Set("app", strconv.FormatUint(config.appId, 10))
+	data, err := kintoneGet(kintoneAPIPath("app/form/fields"), params)
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		Revision string `json:"revision"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return "", err
+	}
+	return response.Revision, nil
+}
+
+// list the objects of the run for loaders to pick up at once. an export
+// into many objects has manifest.json under their prefix.
+func putManifest(svc *s3.S3, key string) error {
+	revision, err := fetchFormRevision()
+	if err != nil {
+		return err
+	}
+
+	manifestObjects.Lock()
+	objects := append([]manifestObject(nil), manifestObjects.list...)
+	manifestObjects.Unlock()
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	records := uint64(0)
+	for _, obj := range objects {
+		records += obj.Records
+	}
+
+	data, err := json.MarshalIndent(&manifest{
+		RunId:         run.Id,
+		Attempt:       run.Attempt,
+		Domain:        config.domain,
+		AppId:         config.appId,
+		Query:         config.query,
+		SchemaVersion: revision,
+		ExportedAt:    run.StartedAt.UTC(),
+		Records:       records,
+		Objects:       objects,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	prefix := "s3://" + config.bucketName + "/"
+	if strings.HasSuffix(run.Destination, "/") && strings.HasPrefix(run.Destination, prefix) {
+		key = strings.TrimPrefix(run.Destination, prefix) + "manifest.json"
+	} else {
+		key = manifestKey(key)
+	}
+	input := newPutObjectInput(key, bytes.NewReader(data))
+	input.ContentType = aws.String("application/json")
+	if _, err := svc.PutObject(input); err != nil {
+		return err
+	}
+	if exportSigner != nil {
+		if err := putSignature(svc, key, data); err != nil {
+			return err
+		}
+	}
+	log.Printf(T("manifest of %d objects written to s3://%s/%s"), len(objects), config.bucketName, key)
+	return nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/kintone/go-kintone"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// the part buffers of the uploads to S3 at a time: the parts queued, the
+// one being uploaded and the one being filled
+func partBufferMemory() int64 {
+	if !s3Output() {
+		return 0
+	}
+	queue := config.uploadQueue
+	if queue < 1 {
+		queue = 1
+	}
+	uploads := 1
+	if config.splitBy != "" && config.splitParallel > 1 {
+		uploads = config.splitParallel
+	}
+	return int64(queue+2) * int64(uploadPartSize()) * int64(uploads)
+}
+
+// what -max-memory leaves for pages and spilled output once the part
+// buffers are set aside; 0 is unlimited
+func bufferMemory() int64 {
+	if config.maxMemory == 0 {
+		return 0
+	}
+	return config.maxMemory - partBufferMemory()
+}
+
+func validateMemoryOptions() error {
+	if config.maxMemory > 0 && config.maxMemory <= partBufferMemory() {
+		return fmt.Errorf(T("-max-memory must be more than the %d bytes of the upload's part buffers, (-upload-queue + 2) x -part-size"), partBufferMemory())
+	}
+	return nil
+}
+
+// bytes of buffered data the run may hold at once. a single item larger
+// than the whole budget is still let through when nothing else is held.
+type memoryBudget struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int64
+	used   int64
+	closed bool
+}
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	b := &memoryBudget{limit: limit}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// block until n bytes fit in the budget. returns false if the budget was closed.
+func (b *memoryBudget) Acquire(n int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for !b.closed && b.limit > 0 && b.used > 0 && b.used+n > b.limit {
+		b.cond.Wait()
+	}
+	b.used += n
+	return !b.closed
+}
+
+func (b *memoryBudget) Release(n int64) {
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+func (b *memoryBudget) Close() {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+type fetchedPage struct {
+	records []*kintone.Record
+	size    int64
+	err     error
+}
+
+// fetches the following pages while the current one is serialized, but
+// only as far ahead as the memory budget allows
+type pageFetcher struct {
+	pages   chan *fetchedPage
+	done    chan struct{}
+	budget  *memoryBudget
+	current *fetchedPage
+}
+
+// rough size of a page: one record marshaled, times the record count
+func estimatePageSize(records []*kintone.Record) int64 {
+	if len(records) == 0 {
+		return 0
+	}
+	data, _ := records[0].MarshalJSON()
+	return int64(len(data)) * int64(len(records))
+}
+
+func fetchPages(app *kintone.App, query string, fields []string) *pageFetcher {
+	f := &pageFetcher{
+		pages:  make(chan *fetchedPage, 1),
+		done:   make(chan struct{}),
+		budget: newMemoryBudget(bufferMemory() / 2),
+	}
+
+	go func() {
+		defer close(f.pages)
+
+		estimate := int64(0)
+		for offset := int64(0); ; offset += EXPORT_ROW_LIMIT {
+			// wait for room for a page like the last one before fetching
+			if !f.budget.Acquire(estimate) {
+				return
+			}
+			started := time.Now()
+			var records []*kintone.Record
+			var eof bool
+			var err error
+			if chunk != nil {
+				records, eof, err = getRecordsAfter(app, fields, chunk.AfterId)
+			} else {
+				records, eof, err = getRecords(app, query, fields, offset)
+			}
+			page := &fetchedPage{records: records, size: estimatePageSize(records), err: err}
+			addStage(STAGE_FETCH, time.Since(started), uint64(len(records)), page.size)
+			f.budget.Release(estimate - page.size)
+			estimate = page.size
+
+			if chunk != nil && err == nil {
+				// only read by the writer after the channel is closed
+				if n := len(records); n > 0 {
+					chunk.AfterId = records[n-1].Id()
+				}
+				chunk.fetched += uint64(len(records))
+				chunk.complete = eof
+			}
+
+			select {
+			case f.pages <- page:
+			case <-f.done:
+				return
+			}
+			if eof || err != nil || (chunk != nil && chunk.full()) {
+				return
+			}
+		}
+	}()
+	return f
+}
+
+// the next page, or nil after the last one
+func (f *pageFetcher) next() ([]*kintone.Record, error) {
+	// the caller is done with the previous page when it asks for the next one
+	if f.current != nil {
+		f.budget.Release(f.current.size)
+		f.current = nil
+	}
+
+	if err := interruption(); err != nil {
+		return nil, err
+	}
+	var page *fetchedPage
+	var ok bool
+	select {
+	case page, ok = <-f.pages:
+	case <-interrupted:
+		return nil, interruptErr
+	}
+	if !ok {
+		return nil, nil
+	}
+	f.current = page
+	if page.err != nil {
+		return nil, page.err
+	}
+	if page.records == nil {
+		page.records = make([]*kintone.Record, 0)
+	}
+	return page.records, nil
+}
+
+func (f *pageFetcher) stop() {
+	close(f.done)
+	f.budget.Close()
+}
+
+// keeps the output in memory up to limit bytes, then in a file in the work directory
+type spillBuffer struct {
+	limit int64
+	mem   bytes.Buffer
+	file  *os.File
+	size  int64
+}
+
+func newSpillBuffer(limit int64) *spillBuffer {
+	return &spillBuffer{limit: limit}
+}
+
+func (s *spillBuffer) Write(p []byte) (int, error) {
+	if s.file == nil && s.limit > 0 && int64(s.mem.Len()+len(p)) > s.limit {
+		file, err := ioutil.TempFile(workdir, "spill-")
+		if err != nil {
+			return 0, err
+		}
+		if _, err := file.Write(s.mem.Bytes()); err != nil {
+			file.Close()
+			return 0, err
+		}
+		s.mem = bytes.Buffer{}
+		s.file = file
+	}
+
+	var n int
+	var err error
+	if s.file != nil {
+		n, err = s.file.Write(p)
+	} else {
+		n, err = s.mem.Write(p)
+	}
+	s.size += int64(n)
+	return n, err
+}
+
+func (s *spillBuffer) Len() int64 {
+	return s.size
+}
+
+func (s *spillBuffer) ReadAt(p []byte, off int64) (int, error) {
+	if s.file != nil {
+		return s.file.ReadAt(p, off)
+	}
+	if off >= int64(s.mem.Len()) {
+		return 0, io.EOF
+	}
+	n := copy(p, s.mem.Bytes()[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// read the whole output from the start
+func (s *spillBuffer) Reader() (io.ReadSeeker, error) {
+	if s.file == nil {
+		return bytes.NewReader(s.mem.Bytes()), nil
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return s.file, nil
+}
+
+func (s *spillBuffer) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"log"
+	"path"
+	"strings"
+)
+
+// what -if-not-exists does when the key is taken
+const (
+	IF_NOT_EXISTS_FAIL   = "fail"
+	IF_NOT_EXISTS_SUFFIX = "suffix"
+)
+
+// keys tried by -if-not-exists suffix before giving up
+const MAX_KEY_SUFFIX = 1000
+
+func validateIfNotExists() error {
+	switch config.ifNotExists {
+	case "":
+		return nil
+	case IF_NOT_EXISTS_FAIL, IF_NOT_EXISTS_SUFFIX:
+	default:
+		return fmt.Errorf(T("-if-not-exists must be 'fail' or 'suffix': %s"), config.ifNotExists)
+	}
+	if config.splitBy != "" || partitionColumns != nil || config.jsonDocumentRecords > 0 || config.appendMode {
+		return errors.New(T("-if-not-exists needs an export into one object, not -split-by, -partition-by, -json-document-records or -append"))
+	}
+	return nil
+}
+
+// export.csv, export-1.csv, export-2.csv, ...
+func suffixedKey(key string, n int) string {
+	if n == 0 {
+		return key
+	}
+	ext := path.Ext(key)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(key, ext), n, ext)
+}
+
+// find the key to write before the records are fetched. the upload itself
+// is conditional too, so a run racing for the same key fails rather than
+// replacing the other's export.
+func claimKey(svc *s3.S3) error {
+	for n := 0; n < MAX_KEY_SUFFIX; n++ {
+		key := suffixedKey(config.key, n)
+		exists, err := objectExists(svc, key)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if key != config.key {
+				log.Printf(T("s3://%s/%s exists, exporting to s3://%s/%s"), config.bucketName, config.key, config.bucketName, key)
+				config.key = key
+				run.Destination = "s3://" + config.bucketName + "/" + key
+			}
+			return nil
+		}
+		if config.ifNotExists == IF_NOT_EXISTS_FAIL {
+			return fmt.Errorf(T("s3://%s/%s already exists (-if-not-exists)"), config.bucketName, key)
+		}
+	}
+	return fmt.Errorf(T("s3://%s/%s and %d suffixed keys already exist"), config.bucketName, config.key, MAX_KEY_SUFFIX-1)
+}
+
+// a conditional write which found the key taken
+func keyTakenError(err error) error {
+	if aerr, ok := err.(awserr.RequestFailure); ok && aerr.StatusCode() == 412 {
+		return errors.New(T("the key was written by another run during the export (-if-not-exists)"))
+	}
+	return err
+}
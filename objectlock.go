@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the retain-until date of -object-lock-retain: a number of days, a
+// duration or a date, e.g. 2555d, 720h, 2031-12-31 or 2031-12-31T00:00:00Z
+func parseRetainUntil(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil && days > 0 {
+			return now.AddDate(0, 0, days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return now.Add(d), nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf(T("invalid retention: %s"), s)
+}
+
+func setupObjectLock(mode, retain string) error {
+	if mode == "" && retain == "" {
+		return nil
+	}
+	if mode != s3.ObjectLockModeGovernance && mode != s3.ObjectLockModeCompliance {
+		return fmt.Errorf(T("-object-lock-mode must be GOVERNANCE or COMPLIANCE: %s"), mode)
+	}
+	if retain == "" {
+		return errors.New(T("-object-lock-mode needs -object-lock-retain"))
+	}
+	if chunked() {
+		// the parts could not be removed once they are joined
+		return errors.New(T("-object-lock-mode cannot be combined with -chunk-records or -chunk-time"))
+	}
+	if !config.s3Checksum {
+		// S3 takes a locked object only with a checksum of its content
+		return errors.New(T("-object-lock-mode needs -s3-checksum"))
+	}
+
+	now := time.Now()
+	until, err := parseRetainUntil(retain, now)
+	if err != nil {
+		return err
+	}
+	if !until.After(now) {
+		return fmt.Errorf(T("-object-lock-retain is in the past: %s"), retain)
+	}
+	config.objectLockMode = mode
+	config.objectLockRetainUntil = until.UTC()
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/kintone/go-kintone"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// <key without extension>.options.csv
+func optionCatalogKey(key string) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + ".options.csv"
+}
+
+func hasOptions(field *kintone.FieldInfo) bool {
+	switch field.Type {
+	case kintone.FT_SINGLE_SELECT, kintone.FT_RADIO, kintone.FT_CHECK_BOX, kintone.FT_MULTI_SELECT:
+		return true
+	}
+	return false
+}
+
+// one row per option of the drop-down, radio button, check box and
+// multi-choice fields, subtable fields included, in the form's order
+func writeOptionCatalog(fields map[string]*kintone.FieldInfo, w *csv.Writer) error {
+	if err := w.Write([]string{"field_code", "field_label", "field_type", "table", "option", "order"}); err != nil {
+		return err
+	}
+	write := func(field *kintone.FieldInfo, table string) error {
+		for i, option := range field.Options {
+			row := []string{field.Code, field.Label, field.Type, table, option, strconv.Itoa(i + 1)}
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	codes := make([]string, 0, len(fields))
+	for code := range fields {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		field := fields[code]
+		if hasOptions(field) {
+			if err := write(field, ""); err != nil {
+				return err
+			}
+		}
+		if field.Type == kintone.FT_SUBTABLE {
+			for i := range field.Fields {
+				if hasOptions(&field.Fields[i]) {
+					if err := write(&field.Fields[i], field.Code); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// put the option catalog next to the export, in its encoding
+func uploadOptionCatalog(app *kintone.App, svc *s3.S3, key string) error {
+	fields, err := getFields(app)
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	writer := getWriter(&b)
+	if err := writeOptionCatalog(fields, csv.NewWriter(writer)); err != nil {
+		return err
+	}
+	closeWriter(writer)
+
+	catalogKey := optionCatalogKey(key)
+	input := newPutObjectInput(catalogKey, bytes.NewReader(b.Bytes()))
+	input.ContentType = aws.String("text/csv; charset=" + charset())
+	if _, err := svc.PutObject(input); err != nil {
+		return err
+	}
+	if exportSigner != nil {
+		return putSignature(svc, catalogKey, b.Bytes())
+	}
+	return nil
+}
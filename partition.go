@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	PARTITION_APP_ID = "app_id"
+	PARTITION_DATE   = "dt"
+)
+
+// the columns of -partition-by in the order of the prefixes
+var partitionColumns []string
+
+// -partition-by app_id,dt,Status writes the export under
+// app_id=123/dt=2024-05-01/Status=完了/part-0000.csv next to the key, so
+// crawlers see a Hive-style partitioned table. a field column splits the
+// export like -split-by.
+func setupPartitions(columns string) error {
+	if config.appendMode {
+		return errors.New(T("-partition-by cannot be combined with -append"))
+	}
+	for _, column := range strings.Split(columns, ",") {
+		column = strings.TrimSpace(column)
+		switch column {
+		case "":
+			continue
+		case PARTITION_APP_ID, PARTITION_DATE:
+		default:
+			if config.splitBy != "" {
+				return fmt.Errorf(T("-partition-by takes at most one field, and not with -split-by: %s"), column)
+			}
+			config.splitBy = column
+		}
+		partitionColumns = append(partitionColumns, column)
+	}
+	if config.splitBy == "" {
+		config.key = partitionKey("")
+		return nil
+	}
+	return validateSplitOptions()
+}
+
+func partitionValue(value string) string {
+	if value == "" {
+		return EMPTY_PARTITION
+	}
+	return url.PathEscape(value)
+}
+
+// the directory of the partitioned table, where the key would have been
+func partitionBase() string {
+	dir := path.Dir(config.key)
+	if dir == "." {
+		return ""
+	}
+	return dir + "/"
+}
+
+// the key of the object holding value of the field column, if there is one
+func partitionKey(value string) string {
+	var b strings.Builder
+	b.WriteString(partitionBase())
+	for _, column := range partitionColumns {
+		switch column {
+		case PARTITION_APP_ID:
+			b.WriteString(PARTITION_APP_ID + "=" + strconv.FormatUint(config.appId, 10))
+		case PARTITION_DATE:
+			b.WriteString(PARTITION_DATE + "=" + templateTime.Format("2006-01-02"))
+		default:
+			b.WriteString(column + "=" + partitionValue(value))
+		}
+		b.WriteString("/")
+	}
+	b.WriteString("part-0000" + path.Ext(config.key))
+	return b.String()
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kintone/go-kintone"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// verification passes before the rows are written out anyway
+const PIN_ROUNDS = 3
+
+const PIN_FETCH_LIMIT = 100
+
+func validatePinOptions() error {
+	if chunked() {
+		return errors.New(T("-pin-revisions cannot be combined with -chunk-records or -chunk-time"))
+	}
+	if config.format == "json" {
+		return errors.New(T("-pin-revisions cannot be combined with -o json"))
+	}
+	return nil
+}
+
+// where the rows of a record are held, and the revision they show
+type pinnedRecord struct {
+	revision int64
+	offset   int64
+	length   int64
+	rows     uint64
+}
+
+// holds the rendered rows of the export, indexed by record, until the
+// records changed since they were fetched are fetched again. the rows are
+// kept as UTF-8 and encoded only when written out.
+type revisionPin struct {
+	spill    *spillBuffer
+	header   []byte
+	columns  Columns
+	hasTable bool
+	records  map[uint64]*pinnedRecord
+	order    []uint64
+	// rows rendered again for the records changed during the export
+	replaced map[uint64][]byte
+	// records deleted or no longer matching by the end
+	dropped uint64
+}
+
+func newRevisionPin() *revisionPin {
+	return &revisionPin{
+		spill:    newSpillBuffer(bufferMemory() / 2),
+		records:  make(map[uint64]*pinnedRecord),
+		order:    make([]uint64, 0),
+		replaced: make(map[uint64][]byte),
+	}
+}
+
+func (p *revisionPin) Close() error {
+	return p.spill.Close()
+}
+
+func (p *revisionPin) setHeader(header []byte, columns Columns, hasTable bool) {
+	p.header = append([]byte(nil), header...)
+	p.columns = columns
+	p.hasTable = hasTable
+}
+
+func (p *revisionPin) add(record *kintone.Record, data []byte, rows uint64) error {
+	offset := p.spill.Len()
+	if _, err := p.spill.Write(data); err != nil {
+		return err
+	}
+	p.records[record.Id()] = &pinnedRecord{
+		revision: record.Revision(),
+		offset:   offset,
+		length:   int64(len(data)),
+		rows:     rows,
+	}
+	p.order = append(p.order, record.Id())
+	return nil
+}
+
+func subUint64(addr *uint64, n uint64) {
+	if n > 0 {
+		atomic.AddUint64(addr, ^(n - 1))
+	}
+}
+
+// leave the record out of the output
+func (p *revisionPin) remove(id uint64) {
+	pinned := p.records[id]
+	delete(p.records, id)
+	delete(p.replaced, id)
+	subUint64(&run.Records, 1)
+	subUint64(&run.Rows, pinned.rows)
+	p.dropped += 1
+}
+
+// the record was deleted or no longer matches the query
+func (p *revisionPin) drop(id uint64) {
+	p.remove(id)
+	atomic.AddUint64(&run.Vanished, 1)
+}
+
+// the revisions of the records matching the query now
+func currentRevisions(app *kintone.App, query string) (map[uint64]int64, error) {
+	cond := queryCondition(query)
+	revisions := make(map[uint64]int64)
+	afterId := uint64(0)
+	for {
+		if err := interruption(); err != nil {
+			return nil, err
+		}
+		q := andQuery(cond, fmt.Sprintf("$id > %d", afterId)) + fmt.Sprintf(" order by $id asc limit %d", EXPORT_ROW_LIMIT)
+		records, err := fetchRecords(app, []string{"$id", "$revision"}, q)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			revisions[record.Id()] = record.Revision()
+			afterId = record.Id()
+		}
+		if len(records) < EXPORT_ROW_LIMIT {
+			return revisions, nil
+		}
+	}
+}
+
+func (p *revisionPin) refetch(app *kintone.App, ids []uint64) error {
+	for start := 0; start < len(ids); start += PIN_FETCH_LIMIT {
+		if err := interruption(); err != nil {
+			return err
+		}
+		end := start + PIN_FETCH_LIMIT
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch := make([]string, 0, end-start)
+		for _, id := range ids[start:end] {
+			batch = append(batch, strconv.FormatUint(id, 10))
+		}
+
+		q := fmt.Sprintf("$id in (%s) limit %d", strings.Join(batch, ","), PIN_FETCH_LIMIT)
+		records, err := fetchRecords(app, fetchFields(), q)
+		if err != nil {
+			return err
+		}
+
+		found := make(map[uint64]bool, len(records))
+		for _, record := range records {
+			pinned := p.records[record.Id()]
+			if pinned == nil {
+				continue
+			}
+			// a record matching a drop rule by now is left out, counted as
+			// redacted rather than vanished
+			if redactRecord(record) {
+				p.remove(record.Id())
+				found[record.Id()] = true
+				continue
+			}
+			if err := truncateRecord(record); err != nil {
+				return err
+			}
+			row := getRowBuffer()
+			rows, err := renderRecord(app, record, p.columns, p.hasTable, record.Id(), row)
+			if err != nil {
+				putRowBuffer(row)
+				return err
+			}
+			p.replaced[record.Id()] = append([]byte(nil), row.Bytes()...)
+			putRowBuffer(row)
+
+			subUint64(&run.Rows, pinned.rows)
+			atomic.AddUint64(&run.Rows, rows)
+			pinned.revision = record.Revision()
+			pinned.rows = rows
+			found[record.Id()] = true
+		}
+		for _, id := range ids[start:end] {
+			if !found[id] {
+				p.drop(id)
+			}
+		}
+	}
+	atomic.AddUint64(&run.Refetched, uint64(len(ids)))
+	return nil
+}
+
+// fetch the changed records again until the revisions hold still, then
+// write the rows in their original order
+func (p *revisionPin) finish(app *kintone.App, query string, writer io.Writer) error {
+	for round := 0; round < PIN_ROUNDS && len(p.records) > 0; round++ {
+		revisions, err := currentRevisions(app, query)
+		if err != nil {
+			return err
+		}
+
+		changed := make([]uint64, 0)
+		for _, id := range p.order {
+			pinned := p.records[id]
+			if pinned == nil {
+				continue
+			}
+			revision, ok := revisions[id]
+			if !ok {
+				p.drop(id)
+			} else if revision != pinned.revision {
+				changed = append(changed, id)
+			}
+		}
+		if len(changed) == 0 {
+			break
+		}
+
+		log.Printf(T("%d records changed during the export, fetching them again"), len(changed))
+		if err := p.refetch(app, changed); err != nil {
+			return err
+		}
+	}
+	return p.write(writer)
+}
+
+func (p *revisionPin) write(writer io.Writer) error {
+	if p.header != nil {
+		if err := writeEncoded(writer, p.header, 0); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, 0)
+	for _, id := range p.order {
+		pinned := p.records[id]
+		if pinned == nil {
+			continue
+		}
+		data, ok := p.replaced[id]
+		if !ok {
+			if int64(cap(buf)) < pinned.length {
+				buf = make([]byte, pinned.length)
+			}
+			data = buf[:pinned.length]
+			if _, err := p.spill.ReadAt(data, pinned.offset); err != nil {
+				return err
+			}
+		}
+		if err := writeEncoded(writer, data, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// -pipe: the serialized output goes through an external command on its
+// way to S3, e.g. an encryptor. the command reads stdin and writes stdout.
+type pipeHook struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+func startPipe(command string, out io.Writer) (*pipeHook, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf(T("could not start -pipe command: %v"), err)
+	}
+	return &pipeHook{cmd: cmd, stdin: stdin}, nil
+}
+
+func (p *pipeHook) Write(b []byte) (int, error) {
+	return p.stdin.Write(b)
+}
+
+// end the input and wait until the command has written all of its output
+func (p *pipeHook) Close() error {
+	p.stdin.Close()
+	if err := p.cmd.Wait(); err != nil {
+		return fmt.Errorf(T("-pipe command failed: %v"), err)
+	}
+	return nil
+}
+
+func (p *pipeHook) Kill() {
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"github.com/kintone/go-kintone"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const COPY_BUFFER_SIZE = 256 * 1024
+
+// buffers reused across attachment downloads
+var copyBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, COPY_BUFFER_SIZE)
+		return &buf
+	},
+}
+
+// buffers reused for rendering the rows of a record
+var rowBufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func getRowBuffer() *bytes.Buffer {
+	buf := rowBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putRowBuffer(buf *bytes.Buffer) {
+	// don't keep the buffer of one huge record around
+	if buf.Cap() > 1024*1024 {
+		return
+	}
+	rowBufferPool.Put(buf)
+}
+
+// append s as a quoted CSV value, without building intermediate strings
+func writeQuoted(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for {
+		idx := strings.IndexByte(s, '"')
+		if idx < 0 {
+			break
+		}
+		buf.WriteString(s[:idx+1])
+		buf.WriteByte('"')
+		s = s[idx+1:]
+	}
+	buf.WriteString(s)
+	buf.WriteByte('"')
+}
+
+// column types whose values are numbers, except for a calculated field
+// formatted as a date or a record number with an app code prefix
+func numericColumn(f *Column) bool {
+	switch f.Type {
+	case kintone.FT_ID, kintone.FT_REVISION, kintone.FT_SUBTABLE, kintone.FT_DECIMAL, kintone.FT_CALC, kintone.FT_RECNUM:
+		return true
+	}
+	return false
+}
+
+// append a CSV value, unquoted if -unquoted-numbers is given and it is a number
+func writeValue(buf *bytes.Buffer, f *Column, s string) {
+	if config.unquotedNumbers && numericColumn(f) {
+		if _, err := strconv.ParseFloat(s, 64); err == nil || s == "" {
+			buf.WriteString(s)
+			return
+		}
+	}
+	writeQuoted(buf, s)
+}
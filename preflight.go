@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/kintone/go-kintone"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"time"
+)
+
+const PREFLIGHT_TIMEOUT = 10 * time.Second
+
+type checkResult struct {
+	name    string
+	err     error
+	skipped string
+}
+
+// run every check even if an earlier one fails, so one run reports all missing permissions
+func preflight(app *kintone.App) error {
+	results := make([]checkResult, 0)
+
+	results = append(results, checkResult{
+		name: fmt.Sprintf(T("kintone reachable (%s)"), config.domain),
+		err:  checkReachable(config.domain+":443", true),
+	})
+
+	results = append(results, checkResult{
+		name: T("kintone record read"),
+		err:  checkRecordRead(app),
+	})
+
+	fileCheck := checkResult{name: T("kintone file read")}
+	fileCheck.skipped, fileCheck.err = checkFileRead(app)
+	results = append(results, fileCheck)
+
+	if !s3Output() {
+		results = append(results, checkResult{name: T("S3"), skipped: T("the records do not go to S3")})
+	} else if svc, err := newS3Client(); err != nil {
+		results = append(results, checkResult{name: T("S3 client"), err: err})
+	} else {
+		endpoint, err := url.Parse(svc.Endpoint)
+		if err == nil {
+			// a -s3-endpoint such as MinIO may be plain http on a port of its own
+			err = checkReachable(endpointAddress(endpoint), endpoint.Scheme != "http")
+		}
+		results = append(results, checkResult{
+			name: fmt.Sprintf(T("S3 reachable (%s)"), svc.Endpoint),
+			err:  err,
+		})
+
+		name := fmt.Sprintf(T("S3 write (s3://%s)"), config.bucketName)
+		if config.sseKmsKeyId != "" {
+			name = fmt.Sprintf(T("S3 write with KMS key %s (s3://%s)"), config.sseKmsKeyId, config.bucketName)
+		}
+		results = append(results, checkResult{
+			name: name,
+			err:  probeBucket(svc, config.key),
+		})
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.skipped != "" {
+			fmt.Printf("[--] %s: %s\n", result.name, result.skipped)
+		} else if result.err != nil {
+			fmt.Printf("[NG] %s: %v\n", result.name, result.err)
+			failed++
+		} else {
+			fmt.Printf("[OK] %s\n", result.name)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf(T("%d of %d preflight checks failed"), failed, len(results))
+	}
+	return nil
+}
+
+// the host and port of the endpoint, the port by its scheme if not given
+func endpointAddress(endpoint *url.URL) string {
+	if endpoint.Port() != "" {
+		return endpoint.Host
+	}
+	port := "443"
+	if endpoint.Scheme == "http" {
+		port = "80"
+	}
+	return net.JoinHostPort(endpoint.Hostname(), port)
+}
+
+func checkReachable(address string, useTLS bool) error {
+	dialer := &net.Dialer{Timeout: PREFLIGHT_TIMEOUT}
+	if !useTLS {
+		conn, err := dialer.Dial("tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: host})
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func checkRecordRead(app *kintone.App) error {
+	_, err := app.GetRecords([]string{"$id"}, "limit 1")
+	return err
+}
+
+// download one attachment, if the app has a file field with any file
+func checkFileRead(app *kintone.App) (string, error) {
+	fields, err := getFields(app)
+	if err != nil {
+		return "", err
+	}
+
+	for _, val := range fields {
+		if val.Type != kintone.FT_FILE {
+			continue
+		}
+
+		query := fmt.Sprintf("%s is not empty limit 1", val.Code)
+		records, err := app.GetRecords([]string{val.Code}, query)
+		if err != nil {
+			return "", err
+		}
+		if len(records) == 0 {
+			continue
+		}
+
+		files, ok := records[0].Fields[val.Code].(kintone.FileField)
+		if !ok || len(files) == 0 {
+			continue
+		}
+
+		data, err := app.Download(files[0].FileKey)
+		if err != nil {
+			return "", err
+		}
+		if c, ok := data.Reader.(io.Closer); ok {
+			defer c.Close()
+		}
+		if _, err := io.CopyN(ioutil.Discard, data.Reader, 1); err != nil && err != io.EOF {
+			return "", err
+		}
+		return "", nil
+	}
+
+	return T("no attachment to download"), nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io/ioutil"
+	"time"
+)
+
+// the longest a SigV4 presigned URL may live
+const MAX_PRESIGN_TTL = 7 * 24 * time.Hour
+
+func validatePresignOptions() error {
+	if config.presign > MAX_PRESIGN_TTL {
+		return fmt.Errorf(T("-presign can be at most %v"), MAX_PRESIGN_TTL)
+	}
+	if config.presignWebhook != "" && config.presign <= 0 {
+		return errors.New(T("-presign-webhook needs -presign"))
+	}
+	return nil
+}
+
+// what is posted to -presign-webhook
+type presignedObject struct {
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+	RunId     string    `json:"run_id"`
+	Records   uint64    `json:"records"`
+}
+
+// print a GET URL of the exported object valid for -presign, for readers
+// without S3 credentials, and post it to -presign-webhook if given
+func publishPresignedURL(svc *s3.S3, key string) error {
+	req, _ := svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket:              aws.String(config.bucketName),
+		Key:                 aws.String(key),
+		ExpectedBucketOwner: newPutObjectInput(key, nil).ExpectedBucketOwner,
+	})
+	u, err := req.Presign(config.presign)
+	if err != nil {
+		return err
+	}
+	fmt.Println(u)
+
+	if config.presignWebhook == "" {
+		return nil
+	}
+	var message interface{} = &presignedObject{
+		Bucket:    config.bucketName,
+		Key:       key,
+		URL:       u,
+		ExpiresAt: time.Now().Add(config.presign).UTC(),
+		RunId:     run.Id,
+		Records:   run.Records,
+	}
+	if config.eventFormat == EVENT_FORMAT_S3 {
+		if message, err = newS3Event(svc, key); err != nil {
+			return err
+		}
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	resp, err := sharedHTTPClient().Post(config.presignWebhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf(T("webhook %s answered %s: %s"), config.presignWebhook, resp.Status, data)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// start the pprof server and CPU profile as requested; the returned
+// function stops the CPU profile and writes the heap profile
+func startProfiling() func() {
+	if config.pprofAddr != "" {
+		go func() {
+			log.Printf(T("pprof listening on %s"), config.pprofAddr)
+			if err := http.ListenAndServe(config.pprofAddr, nil); err != nil {
+				log.Printf(T("pprof server stopped: %v"), err)
+			}
+		}()
+	}
+
+	var cpuFile *os.File
+	if config.cpuProfile != "" {
+		file, err := os.Create(config.cpuProfile)
+		if err != nil {
+			log.Printf(T("could not create CPU profile: %v"), err)
+		} else if err := pprof.StartCPUProfile(file); err != nil {
+			log.Printf(T("could not create CPU profile: %v"), err)
+			file.Close()
+		} else {
+			cpuFile = file
+		}
+	}
+
+	return func() {
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			cpuFile.Close()
+		}
+
+		if config.memProfile != "" {
+			file, err := os.Create(config.memProfile)
+			if err != nil {
+				log.Printf(T("could not create heap profile: %v"), err)
+				return
+			}
+			defer file.Close()
+			// up-to-date statistics
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(file); err != nil {
+				log.Printf(T("could not create heap profile: %v"), err)
+			}
+		}
+	}
+}
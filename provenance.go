@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// set at build time: go build -ldflags "-X main.version=1.2.0"
+var version = "dev"
+
+// user metadata telling where an object came from. S3 metadata is ASCII,
+// so the query is URL-encoded. records and the end time are only known
+// once the object is complete; pass complete false before that, as for a
+// multipart upload, whose object keeps the metadata it was started with.
+// the run history has the count of every run.
+func provenanceMetadata(complete bool) map[string]*string {
+	started, records := run.StartedAt, run.Records
+	if chunk != nil {
+		// the joined object of the snapshot, written over several runs
+		started, records = chunk.StartedAt, chunk.Records
+	}
+
+	m := map[string]*string{
+		"kintone-domain": aws.String(config.domain),
+		"kintone-app-id": aws.String(strconv.FormatUint(config.appId, 10)),
+		"kintone-query":  aws.String(url.QueryEscape(config.query)),
+		"run-id":         aws.String(run.Id),
+		"started-at":     aws.String(started.UTC().Format(time.RFC3339)),
+		"tool-version":   aws.String(version),
+	}
+	if len(run.Tags) > 0 {
+		m["snapshot-tags"] = aws.String(strings.Join(run.Tags, ","))
+	}
+	if complete {
+		// the objects of a split export are written at once, the count is of them all
+		if config.splitBy == "" && config.jsonDocumentRecords == 0 {
+			m["records"] = aws.String(strconv.FormatUint(records, 10))
+		}
+		m["finished-at"] = aws.String(time.Now().UTC().Format(time.RFC3339))
+	}
+	return m
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// a token bucket of kintone requests shared by every process exporting
+// from the domain, kept in a DynamoDB item next to the -state items.
+// processes take a token with a conditional write, so two of them never
+// spend the same one.
+type rateLimiter struct {
+	svc   *dynamodb.DynamoDB
+	table string
+	key   string
+	rate  float64
+	burst float64
+}
+
+var limiter *rateLimiter
+
+func validateRateLimitOptions() error {
+	if config.rateLimit <= 0 {
+		return nil
+	}
+	if config.rateLimitStore == "" && strings.HasPrefix(config.state, "dynamodb://") {
+		config.rateLimitStore = config.state
+	}
+	if !strings.HasPrefix(config.rateLimitStore, "dynamodb://") {
+		return errors.New(T("-rate-limit needs -rate-limit-store dynamodb://table, or a -state in DynamoDB"))
+	}
+	return nil
+}
+
+func newRateLimiter() (*rateLimiter, error) {
+	table := strings.TrimPrefix(config.rateLimitStore, "dynamodb://")
+	if table == "" {
+		return nil, fmt.Errorf(T("table name is missing: %s"), config.rateLimitStore)
+	}
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	return &rateLimiter{
+		svc:   dynamodb.New(sess, newAwsConfig()),
+		table: table,
+		key:   "ratelimit/" + config.domain,
+		rate:  config.rateLimit,
+		burst: math.Max(1, config.rateLimit),
+	}, nil
+}
+
+func formatFloat(f float64) *string {
+	return aws.String(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+// wait until a token is free and take it
+func (l *rateLimiter) wait() error {
+	for {
+		out, err := l.svc.GetItem(&dynamodb.GetItemInput{
+			TableName:      aws.String(l.table),
+			Key:            map[string]*dynamodb.AttributeValue{"key": {S: aws.String(l.key)}},
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UnixNano() / int64(time.Millisecond)
+		tokens, refilled := l.burst, int64(0)
+		condition := "attribute_not_exists(#k)"
+		values := map[string]*dynamodb.AttributeValue{}
+		if out.Item != nil && out.Item["tokens"] != nil && out.Item["refilled"] != nil {
+			tokens, _ = strconv.ParseFloat(aws.StringValue(out.Item["tokens"].N), 64)
+			refilled, _ = strconv.ParseInt(aws.StringValue(out.Item["refilled"].N), 10, 64)
+			tokens = math.Min(l.burst, tokens+float64(now-refilled)*l.rate/1000)
+			condition = "refilled = :refilled"
+			values[":refilled"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(refilled, 10))}
+		}
+
+		if tokens < 1 {
+			time.Sleep(time.Duration((1 - tokens) / l.rate * float64(time.Second)))
+			continue
+		}
+
+		input := &dynamodb.PutItemInput{
+			TableName: aws.String(l.table),
+			Item: map[string]*dynamodb.AttributeValue{
+				"key":      {S: aws.String(l.key)},
+				"tokens":   {N: formatFloat(tokens - 1)},
+				"refilled": {N: aws.String(strconv.FormatInt(now, 10))},
+			},
+			ConditionExpression: aws.String(condition),
+		}
+		if len(values) > 0 {
+			input.ExpressionAttributeValues = values
+		} else {
+			input.ExpressionAttributeNames = map[string]*string{"#k": aws.String("key")}
+		}
+		_, err = l.svc.PutItem(input)
+		if err == nil {
+			return nil
+		}
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != dynamodb.ErrCodeConditionalCheckFailedException {
+			return err
+		}
+		// another process took a token first; look again
+	}
+}
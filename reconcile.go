@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+var queryLimitRegexp = regexp.MustCompile(`(?i)\b(limit|offset)\b`)
+
+// kintone's count of the records matching the query, or nil if the count
+// cannot be compared with the output: the query has its own limit or
+// offset, or the export is spread over several runs
+func fetchTotalCount() (*uint64, error) {
+	if queryLimitRegexp.MatchString(config.query) || chunked() {
+		return nil, nil
+	}
+
+	params := url.Values{}
+	params.Set("app", strconv.FormatUint(config.appId, 10))
+	params.Set("query", config.query+" limit 1")
+	params.Set("fields[0]", "$id")
+	params.Set("totalCount", "true")
+	data, err := kintoneGet(kintoneAPIPath("records"), params)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		TotalCount string `json:"totalCount"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, err
+	}
+	count, err := strconv.ParseUint(response.TotalCount, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &count, nil
+}
+
+// compare the records fetched with the count taken before the export.
+// the rows are not compared, a record with a subtable has a row per
+// subtable row. records left out by -redact-drop are in kintone's count.
+// each output calls it before the export is published where it can, so
+// a short export does not replace the last one.
+func reconcileRecordCount() error {
+	// a mismatch allowed by -allow-count-mismatch is reported once
+	if run.TotalCount == nil || run.CountMismatch {
+		return nil
+	}
+	fetched := run.Records + run.Duplicates + run.Vanished + run.Redacted
+	if fetched == *run.TotalCount {
+		return nil
+	}
+
+	run.CountMismatch = true
+	err := fmt.Errorf(T("%d records exported but kintone counted %d for the query"), fetched, *run.TotalCount)
+	if config.allowCountMismatch {
+		log.Println(err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"github.com/kintone/go-kintone"
+	"strings"
+	"sync/atomic"
+)
+
+// a condition on a record, e.g. privacy_flag = "opt-out". both sides are
+// expressions as in -derive.
+type redactRule struct {
+	left   derivedExpr
+	negate bool
+	right  derivedExpr
+}
+
+// the operator is read after the left expression, so = and != may appear
+// in its string literals
+func parseRedactRule(def string) (*redactRule, error) {
+	p := &exprParser{src: []rune(def)}
+	left, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf(T("redaction rule %s: %v"), def, err)
+	}
+
+	rule := &redactRule{left: left}
+	p.skipSpace()
+	rest := string(p.src[p.pos:])
+	switch {
+	case strings.HasPrefix(rest, "!="):
+		rule.negate = true
+		p.pos += 2
+	case strings.HasPrefix(rest, "="):
+		p.pos++
+	default:
+		return nil, fmt.Errorf(T("redaction rule must be expression = expression or expression != expression: %s"), def)
+	}
+
+	if rule.right, err = p.parse(); err != nil {
+		return nil, fmt.Errorf(T("redaction rule %s: %v"), def, err)
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, fmt.Errorf(T("redaction rule %s: unexpected %q"), def, string(p.src[p.pos:]))
+	}
+	return rule, nil
+}
+
+func parseRedactRules(defs []string) ([]*redactRule, error) {
+	rules := make([]*redactRule, 0, len(defs))
+	for _, def := range defs {
+		rule, err := parseRedactRule(def)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (r *redactRule) matches(record *kintone.Record) bool {
+	return (r.left.eval(record) == r.right.eval(record)) != r.negate
+}
+
+// field codes the rules read, to be fetched along with the exported ones
+func redactFields() []string {
+	codes := make([]string, 0)
+	for _, rules := range [][]*redactRule{config.redactDrop, config.redactMask} {
+		for _, r := range rules {
+			codes = append(codes, r.left.fields()...)
+			codes = append(codes, r.right.fields()...)
+		}
+	}
+	return codes
+}
+
+// replace every value of the record but its id and revision with the
+// -redact-mask-value, keeping the rows of its subtables. attachments are
+// masked too and so never downloaded.
+func maskRecord(record *kintone.Record) {
+	mask := kintone.SingleLineTextField(config.redactMaskValue)
+	for code, field := range record.Fields {
+		table, ok := field.(kintone.SubTableField)
+		if !ok {
+			record.Fields[code] = mask
+			continue
+		}
+		for _, row := range table {
+			for subCode := range row.Fields {
+				row.Fields[subCode] = mask
+			}
+		}
+	}
+}
+
+// apply the redaction rules before the record is serialized: true if it
+// is to be dropped, otherwise it may have been masked in place
+func redactRecord(record *kintone.Record) bool {
+	for _, r := range config.redactDrop {
+		if r.matches(record) {
+			atomic.AddUint64(&run.Redacted, 1)
+			return true
+		}
+	}
+	for _, r := range config.redactMask {
+		if r.matches(record) {
+			maskRecord(record)
+			atomic.AddUint64(&run.Masked, 1)
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/kintone/go-kintone"
+	"log"
+	"path"
+	"sort"
+	"strings"
+)
+
+func validateRedshiftOptions() error {
+	if config.redshiftTable == "" {
+		if config.redshiftIamRole != "" {
+			return errors.New(T("-redshift-iam-role needs -redshift-table"))
+		}
+		return nil
+	}
+	if config.format != "csv" || config.encoding != "utf-8" {
+		return errors.New(T("-redshift-table needs -o csv and -e utf-8"))
+	}
+	if localOutput() || config.firehoseStream != "" || config.pipe != "" || config.envelopeKmsKeyId != "" {
+		return errors.New(T("-redshift-table cannot be combined with -output, -firehose-stream, -pipe or -envelope-kms-key-id"))
+	}
+	return nil
+}
+
+// a manifest of COPY, see "Using a manifest to specify data files"
+type redshiftManifest struct {
+	Entries []redshiftEntry `json:"entries"`
+}
+
+type redshiftEntry struct {
+	URL       string            `json:"url"`
+	Mandatory bool              `json:"mandatory"`
+	Meta      redshiftEntryMeta `json:"meta"`
+}
+
+type redshiftEntryMeta struct {
+	ContentLength int64 `json:"content_length"`
+}
+
+// "name" in Redshift SQL, in lower case as Redshift folds the names
+func redshiftIdentifier(name string) string {
+	return `"` + strings.Replace(strings.ToLower(name), `"`, `""`, -1) + `"`
+}
+
+func redshiftString(value string) string {
+	return "'" + strings.Replace(value, "'", "''", -1) + "'"
+}
+
+// the COPY of the objects listed in listKey into -redshift-table, the
+// columns in the order of the CSV header
+func redshiftCopy(app *kintone.App, listKey string) (string, error) {
+	fields, err := csvColumns(app)
+	if err != nil {
+		return "", err
+	}
+	columns := make([]string, 0, len(fields)+1)
+	if hasSubTable(fields) {
+		columns = append(columns, redshiftIdentifier(GLUE_RECORD_START_COLUMN))
+	}
+	for _, f := range fields {
+		columns = append(columns, redshiftIdentifier(f.Code))
+	}
+
+	table := make([]string, 0, 2)
+	for _, name := range strings.SplitN(config.redshiftTable, ".", 2) {
+		table = append(table, redshiftIdentifier(name))
+	}
+	role := "default"
+	if config.redshiftIamRole != "" {
+		role = redshiftString(config.redshiftIamRole)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "COPY %s (%s)\n", strings.Join(table, "."), strings.Join(columns, ", "))
+	fmt.Fprintf(&b, "FROM %s\n", redshiftString("s3://"+config.bucketName+"/"+listKey))
+	fmt.Fprintf(&b, "IAM_ROLE %s\n", role)
+	b.WriteString("MANIFEST\nCSV\nIGNOREHEADER 1\nENCODING UTF8\nDATEFORMAT 'auto'\nTIMEFORMAT 'auto'\nEMPTYASNULL\n")
+	if config.compress == COMPRESS_GZIP {
+		b.WriteString("GZIP\n")
+	}
+	if config.region != "" {
+		fmt.Fprintf(&b, "REGION %s\n", redshiftString(config.region))
+	}
+	b.WriteString(";\n")
+	return b.String(), nil
+}
+
+// <key without extension><suffix>
+func redshiftKey(key, suffix string) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + suffix
+}
+
+// write the Redshift manifest of the run's objects and the COPY loading
+// them next to it as .copy.sql, and print the COPY
+func putRedshiftCopy(app *kintone.App, svc *s3.S3, key string) error {
+	manifestObjects.Lock()
+	objects := append([]manifestObject(nil), manifestObjects.list...)
+	manifestObjects.Unlock()
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	m := redshiftManifest{Entries: make([]redshiftEntry, 0, len(objects))}
+	for _, obj := range objects {
+		m.Entries = append(m.Entries, redshiftEntry{
+			URL:       "s3://" + config.bucketName + "/" + obj.Key,
+			Mandatory: true,
+			Meta:      redshiftEntryMeta{ContentLength: obj.Bytes},
+		})
+	}
+	data, err := json.MarshalIndent(&m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// as -manifest: under the prefix of an export into many objects
+	listKey, sqlKey := redshiftKey(key, ".redshift.manifest"), redshiftKey(key, ".copy.sql")
+	prefix := "s3://" + config.bucketName + "/"
+	if strings.HasSuffix(run.Destination, "/") && strings.HasPrefix(run.Destination, prefix) {
+		base := strings.TrimPrefix(run.Destination, prefix)
+		listKey, sqlKey = base+"redshift.manifest", base+"copy.sql"
+	}
+	input := newPutObjectInput(listKey, bytes.NewReader(data))
+	input.ContentType = aws.String("application/json")
+	if _, err := svc.PutObject(input); err != nil {
+		return err
+	}
+
+	statement, err := redshiftCopy(app, listKey)
+	if err != nil {
+		return err
+	}
+	input = newPutObjectInput(sqlKey, strings.NewReader(statement))
+	input.ContentType = aws.String("application/sql")
+	if _, err := svc.PutObject(input); err != nil {
+		return err
+	}
+	log.Printf(T("Redshift COPY of %d objects written to s3://%s/%s"), len(objects), config.bucketName, sqlKey)
+	fmt.Print(statement)
+	return nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"log"
+	"strings"
+	"sync"
+)
+
+// the largest object CopyObject can copy in one request
+const MAX_COPY_SIZE = 5 << 30
+
+// a -replicate-to bucket: dr-bucket or dr-bucket@us-west-2
+type replicaTarget struct {
+	Bucket string
+	Region string
+}
+
+// how the copy to a -replicate-to bucket went, kept in the run history
+type Replica struct {
+	Bucket  string `json:"bucket"`
+	Region  string `json:"region,omitempty"`
+	Objects int    `json:"objects"`
+	Error   string `json:"error,omitempty"`
+}
+
+func parseReplicaTargets(defs []string) ([]replicaTarget, error) {
+	targets := make([]replicaTarget, 0, len(defs))
+	for _, def := range defs {
+		bucket, region := def, config.region
+		if i := strings.LastIndex(def, "@"); i >= 0 {
+			bucket, region = def[:i], def[i+1:]
+		}
+		bucket = strings.TrimPrefix(strings.TrimSuffix(bucket, "/"), "s3://")
+		if bucket == "" || strings.Contains(bucket, "/") {
+			return nil, fmt.Errorf(T("invalid -replicate-to bucket: %s"), def)
+		}
+		if bucket == config.bucketName {
+			return nil, fmt.Errorf(T("-replicate-to is the export's own bucket: %s"), bucket)
+		}
+		targets = append(targets, replicaTarget{Bucket: bucket, Region: region})
+	}
+	if len(targets) > 0 && chunked() {
+		// the parts of a chunked export are removed once joined
+		return nil, errors.New(T("-replicate-to cannot be combined with -chunk-records or -chunk-time"))
+	}
+	return targets, nil
+}
+
+// the objects the run put into the bucket, in order, so they can be
+// copied to the replicas once the export succeeded
+type writtenObjects struct {
+	mu   sync.Mutex
+	keys []string
+	seen map[string]bool
+}
+
+var written = &writtenObjects{seen: make(map[string]bool)}
+
+func (w *writtenObjects) add(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.seen[key] {
+		w.seen[key] = true
+		w.keys = append(w.keys, key)
+	}
+}
+
+func (w *writtenObjects) remove(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.seen[key] {
+		delete(w.seen, key)
+		for i, k := range w.keys {
+			if k == key {
+				w.keys = append(w.keys[:i], w.keys[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func (w *writtenObjects) list() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.keys...)
+}
+
+// note every object svc writes to or removes from the bucket
+func trackWrites(svc *s3.S3) {
+	svc.Handlers.Complete.PushBack(func(r *request.Request) {
+		if r.Error != nil {
+			return
+		}
+		switch p := r.Params.(type) {
+		case *s3.PutObjectInput:
+			if aws.StringValue(p.Bucket) == config.bucketName {
+				written.add(aws.StringValue(p.Key))
+			}
+		case *s3.CompleteMultipartUploadInput:
+			if aws.StringValue(p.Bucket) == config.bucketName {
+				written.add(aws.StringValue(p.Key))
+			}
+		case *s3.CopyObjectInput:
+			if aws.StringValue(p.Bucket) == config.bucketName {
+				written.add(aws.StringValue(p.Key))
+			}
+		case *s3.DeleteObjectInput:
+			if aws.StringValue(p.Bucket) == config.bucketName {
+				written.remove(aws.StringValue(p.Key))
+			}
+		}
+	})
+}
+
+// copy srcKey of the export's bucket to key of bucket, keeping its
+// metadata and tags. another region may not have the KMS key, so in another
+// bucket a KMS-encrypted object takes that bucket's default encryption.
+func copyObject(src, dst *s3.S3, bucket, key, srcKey string) error {
+	head, err := src.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(config.bucketName),
+		Key:    aws.String(srcKey),
+	})
+	if err != nil {
+		return err
+	}
+
+	put := newPutObjectInput(key, nil)
+	if bucket != config.bucketName {
+		put.ExpectedBucketOwner, put.SSEKMSKeyId = nil, nil
+		if config.sse != s3.ServerSideEncryptionAes256 {
+			put.ServerSideEncryption = nil
+		}
+	}
+	if aws.Int64Value(head.ContentLength) <= MAX_COPY_SIZE {
+		input := &s3.CopyObjectInput{
+			Bucket:                    aws.String(bucket),
+			Key:                       aws.String(key),
+			CopySource:                aws.String(copySource(srcKey)),
+			ExpectedBucketOwner:       put.ExpectedBucketOwner,
+			ACL:                       put.ACL,
+			ServerSideEncryption:      put.ServerSideEncryption,
+			SSEKMSKeyId:               put.SSEKMSKeyId,
+			StorageClass:              put.StorageClass,
+			ObjectLockMode:            put.ObjectLockMode,
+			ObjectLockRetainUntilDate: put.ObjectLockRetainUntilDate,
+		}
+		if config.s3Checksum {
+			input.ChecksumAlgorithm = aws.String(s3.ChecksumAlgorithmSha256)
+		}
+		_, err := dst.CopyObject(input)
+		return err
+	}
+	return copyObjectInParts(dst, bucket, key, srcKey, head, put)
+}
+
+// the ranges a copy of size bytes is made of: -part-size, but at least
+// MIN_PART_SIZE and few enough for MAX_PARTS
+func copyPartSize(size int64) int64 {
+	partSize := config.partSize
+	if least := size/MAX_PARTS + 1; partSize < least {
+		partSize = least
+	}
+	if partSize < MIN_PART_SIZE {
+		partSize = MIN_PART_SIZE
+	}
+	return partSize
+}
+
+// objects over 5GB are copied a range at a time
+func copyObjectInParts(dst *s3.S3, bucket, key, srcKey string, head *s3.HeadObjectOutput, put *s3.PutObjectInput) error {
+	create := &s3.CreateMultipartUploadInput{
+		Bucket:                    aws.String(bucket),
+		Key:                       aws.String(key),
+		ContentType:               head.ContentType,
+		ContentEncoding:           head.ContentEncoding,
+		Metadata:                  head.Metadata,
+		ExpectedBucketOwner:       put.ExpectedBucketOwner,
+		ACL:                       put.ACL,
+		ServerSideEncryption:      put.ServerSideEncryption,
+		SSEKMSKeyId:               put.SSEKMSKeyId,
+		StorageClass:              put.StorageClass,
+		Tagging:                   put.Tagging,
+		ObjectLockMode:            put.ObjectLockMode,
+		ObjectLockRetainUntilDate: put.ObjectLockRetainUntilDate,
+	}
+	upload, err := dst.CreateMultipartUpload(create)
+	if err != nil {
+		return err
+	}
+
+	size := aws.Int64Value(head.ContentLength)
+	partSize := copyPartSize(size)
+	parts := make([]*s3.CompletedPart, 0)
+	for offset, number := int64(0), int64(1); offset < size; offset, number = offset+partSize, number+1 {
+		end := offset + partSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		out, err := dst.UploadPartCopy(&s3.UploadPartCopyInput{
+			Bucket:          aws.String(bucket),
+			Key:             aws.String(key),
+			UploadId:        upload.UploadId,
+			PartNumber:      aws.Int64(number),
+			CopySource:      aws.String(copySource(srcKey)),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, end)),
+		})
+		if err != nil {
+			dst.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(bucket),
+				Key:      aws.String(key),
+				UploadId: upload.UploadId,
+			})
+			return err
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: out.CopyPartResult.ETag, PartNumber: aws.Int64(number)})
+	}
+
+	_, err = dst.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(key),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
+// copy what the run wrote to each -replicate-to bucket. every bucket is
+// tried; the run fails if any of them did.
+func replicate(svc *s3.S3) error {
+	keys := written.list()
+	failed := 0
+	for _, target := range config.replicas {
+		replica := &Replica{Bucket: target.Bucket, Region: target.Region}
+		run.Replicas = append(run.Replicas, replica)
+
+		err := func() error {
+			dst, err := newS3ClientIn(target.Region)
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				if err := copyObject(svc, dst, target.Bucket, key, key); err != nil {
+					return fmt.Errorf("%s: %v", key, err)
+				}
+				replica.Objects += 1
+			}
+			return nil
+		}()
+		if err != nil {
+			replica.Error = err.Error()
+			failed += 1
+			log.Printf(T("replication to s3://%s failed after %d of %d objects: %v"), target.Bucket, replica.Objects, len(keys), err)
+		} else {
+			log.Printf(T("replicated %d objects to s3://%s"), replica.Objects, target.Bucket)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf(T("replication failed to %d of %d buckets"), failed, len(config.replicas))
+	}
+	return nil
+}
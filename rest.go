@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// path of a kintone REST API, e.g. "records" for /k/v1/records.json
+func kintoneAPIPath(name string) string {
+	if config.guestSpaceId != 0 {
+		return fmt.Sprintf("/k/guest/%d/v1/%s.json", config.guestSpaceId, name)
+	}
+	return "/k/v1/" + name + ".json"
+}
+
+// a GET request to an API go-kintone does not cover, with the same
+// credentials as the app
+func kintoneGet(path string, params url.Values) ([]byte, error) {
+	var body []byte
+	err := waitMaintenance(func() (err error) {
+		body, err = kintoneGetOnce(path, params)
+		return err
+	})
+	return body, err
+}
+
+func kintoneGetOnce(path string, params url.Values) ([]byte, error) {
+	u := url.URL{Scheme: "https", Host: config.domain, Path: path, RawQuery: params.Encode()}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.apiToken != "" {
+		req.Header.Set("X-Cybozu-API-Token", config.apiToken)
+	} else {
+		req.Header.Set("X-Cybozu-Authorization", base64.StdEncoding.EncodeToString([]byte(config.login+":"+config.password)))
+	}
+	if config.basicAuthUser != "" {
+		req.SetBasicAuth(config.basicAuthUser, config.basicAuthPassword)
+	}
+
+	resp, err := sharedHTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &restError{path: path, status: resp.Status, code: resp.StatusCode, body: body}
+	}
+	return body, nil
+}
+
+type restError struct {
+	path   string
+	status string
+	code   int
+	body   []byte
+}
+
+func (e *restError) Error() string {
+	return fmt.Sprintf("GET %s: %s: %s", e.path, e.status, e.body)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// what {date} and {timestamp} of the key template match in earlier keys
+var keyWildcards = map[string]string{
+	"{date}":      `\d{4}-\d{2}-\d{2}`,
+	"{timestamp}": `\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z`,
+}
+
+func validateKeepOptions() error {
+	if config.keep == 0 {
+		return nil
+	}
+	if config.keep < 0 {
+		return errors.New(T("-keep must be positive"))
+	}
+	if config.splitBy != "" || partitionColumns != nil || config.jsonDocumentRecords > 0 || config.appendMode {
+		return errors.New(T("-keep needs an export into one object, not -split-by, -partition-by, -json-document-records or -append"))
+	}
+	if _, _, wild := keyPattern(config.keyTemplate); !wild {
+		return errors.New(T("-keep needs {date} or {timestamp} in the object key, or -if-not-exists suffix"))
+	}
+	return nil
+}
+
+// the keys the template expands to on any day, and the prefix to list
+// them under. -if-not-exists suffix adds -1, -2, ... before the extension.
+func keyPattern(template string) (*regexp.Regexp, string, bool) {
+	template = strings.TrimPrefix(template, "/")
+	ext := path.Ext(template)
+	if strings.Contains(ext, "{") && ext != ".{ext}" {
+		ext = ""
+	}
+	base := strings.TrimSuffix(template, ext)
+
+	var b strings.Builder
+	prefix := ""
+	wild := false
+	literal := func(s string) {
+		s, _ = expandKey(s)
+		if !wild {
+			prefix += s
+		}
+		b.WriteString(regexp.QuoteMeta(s))
+	}
+	for {
+		loc := keyPlaceholderRegexp.FindStringIndex(base)
+		if loc == nil {
+			literal(base)
+			break
+		}
+		literal(base[:loc[0]])
+		if re, ok := keyWildcards[base[loc[0]:loc[1]]]; ok {
+			b.WriteString(re)
+			wild = true
+		} else {
+			literal(base[loc[0]:loc[1]])
+		}
+		base = base[loc[1]:]
+	}
+	if config.ifNotExists == IF_NOT_EXISTS_SUFFIX {
+		b.WriteString(`(-\d+)?`)
+		wild = true
+	}
+	literal(ext)
+	return regexp.MustCompile("^" + b.String() + "$"), prefix, wild
+}
+
+// keep the newest -keep exports of the key template and delete the rest,
+// with their checksums, signatures and schemas
+func pruneExports(svc *s3.S3) error {
+	pattern, prefix, _ := keyPattern(config.keyTemplate)
+
+	exports := make([]*s3.Object, 0)
+	listed := make(map[string]bool)
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(config.bucketName),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			key := aws.StringValue(obj.Key)
+			listed[key] = true
+			if pattern.MatchString(key) && key != config.latestKey && key != config.latestPointer {
+				exports = append(exports, obj)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if len(exports) <= config.keep {
+		return nil
+	}
+
+	sort.Slice(exports, func(i, j int) bool {
+		return aws.TimeValue(exports[i].LastModified).After(aws.TimeValue(exports[j].LastModified))
+	})
+	doomed := make([]*s3.ObjectIdentifier, 0)
+	for _, obj := range exports[config.keep:] {
+		key := aws.StringValue(obj.Key)
+		if key == config.key {
+			continue
+		}
+		for _, k := range []string{key, key + ".sha256", key + ".sha256.json", jsonSchemaKey(key), optionCatalogKey(key), overflowKey(key), manifestKey(key), redshiftKey(key, ".redshift.manifest"), redshiftKey(key, ".copy.sql")} {
+			// and the signature of each
+			for _, k := range []string{k, k + ".sig"} {
+				if listed[k] {
+					doomed = append(doomed, &s3.ObjectIdentifier{Key: aws.String(k)})
+				}
+			}
+		}
+	}
+
+	for len(doomed) > 0 {
+		// DeleteObjects takes up to 1000 keys
+		batch := doomed
+		if len(batch) > 1000 {
+			batch = batch[:1000]
+		}
+		doomed = doomed[len(batch):]
+		input := &s3.DeleteObjectsInput{
+			Bucket: aws.String(config.bucketName),
+			Delete: &s3.Delete{Objects: batch, Quiet: aws.Bool(true)},
+		}
+		if config.bucketOwner != "" {
+			input.ExpectedBucketOwner = aws.String(config.bucketOwner)
+		}
+		out, err := svc.DeleteObjects(input)
+		if err != nil {
+			return err
+		}
+		for _, e := range out.Errors {
+			log.Printf(T("could not delete s3://%s/%s: %s"), config.bucketName, aws.StringValue(e.Key), aws.StringValue(e.Message))
+		}
+	}
+	log.Printf(T("kept the newest %d exports of s3://%s/%s"), config.keep, config.bucketName, config.keyTemplate)
+	return nil
+}
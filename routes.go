@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// one rule of a -routes file. the first rule matching the app by ID or by
+// name (a pattern like "人事*") decides where its export goes; fields left
+// out keep the options' values.
+type route struct {
+	Apps        []uint64 `json:"apps"`
+	Names       []string `json:"names"`
+	Bucket      string   `json:"bucket"`
+	Prefix      string   `json:"prefix"`
+	Format      string   `json:"format"`
+	SseKmsKeyId string   `json:"sse_kms_key_id"`
+}
+
+func loadRoutes(file string) ([]route, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var routes []route
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return routes, nil
+}
+
+func fetchAppName() (string, error) {
+	body, err := kintoneGet(kintoneAPIPath("app"), url.Values{"id": {strconv.FormatUint(config.appId, 10)}})
+	if err != nil {
+		return "", err
+	}
+	var app struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(body, &app); err != nil {
+		return "", err
+	}
+	return app.Name, nil
+}
+
+func (r *route) matches(appName func() (string, error)) (bool, error) {
+	for _, id := range r.Apps {
+		if id == config.appId {
+			return true, nil
+		}
+	}
+	if len(r.Names) == 0 {
+		return false, nil
+	}
+	name, err := appName()
+	if err != nil {
+		return false, err
+	}
+	for _, pattern := range r.Names {
+		if ok, err := path.Match(pattern, name); err != nil {
+			return false, fmt.Errorf(T("bad app name pattern %q: %v"), pattern, err)
+		} else if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// apply the first matching rule of the routes file and return the key
+// under its prefix. the app name is only fetched when a rule needs it.
+func applyRoutes(file string, key string) (string, error) {
+	routes, err := loadRoutes(file)
+	if err != nil {
+		return "", err
+	}
+
+	var name *string
+	appName := func() (string, error) {
+		if name == nil {
+			n, err := fetchAppName()
+			if err != nil {
+				return "", err
+			}
+			name = &n
+		}
+		return *name, nil
+	}
+
+	for i := range routes {
+		r := &routes[i]
+		ok, err := r.matches(appName)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			continue
+		}
+
+		if r.Bucket != "" {
+			if config.bucketName, err = expandTemplate(r.Bucket); err != nil {
+				return "", err
+			}
+		}
+		if r.Format != "" {
+			config.format = r.Format
+		}
+		if r.SseKmsKeyId != "" {
+			config.sseKmsKeyId = r.SseKmsKeyId
+		}
+		if r.Prefix != "" {
+			prefix, err := expandTemplate(r.Prefix)
+			if err != nil {
+				return "", err
+			}
+			key = strings.Trim(prefix, "/") + "/" + strings.TrimPrefix(key, "/")
+		}
+		log.Printf(T("app %d is routed by rule %d of %s to s3://%s/%s"), config.appId, i+1, file, config.bucketName, key)
+		return key, nil
+	}
+	return key, nil
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	RUN_RUNNING   = "running"
+	RUN_SUCCEEDED = "succeeded"
+	RUN_FAILED    = "failed"
+)
+
+// Run is the record of one export, kept in the run history
+type Run struct {
+	Id          string    `json:"id"`
+	Domain      string    `json:"domain"`
+	AppId       uint64    `json:"app_id"`
+	Query       string    `json:"query"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	Duration    float64   `json:"duration_seconds"`
+	Records     uint64    `json:"records"`
+	Rows        uint64    `json:"rows"`
+	Duplicates  uint64    `json:"duplicates_dropped,omitempty"`
+	Bytes       int64     `json:"bytes"`
+	Destination string    `json:"destination"`
+	Snapshot    string    `json:"snapshot,omitempty"`
+	Part        int       `json:"part,omitempty"`
+	Attempt     int       `json:"attempt"`
+	Tags        []string  `json:"tags,omitempty"`
+
+	// kintone's count for the query when the export started
+	TotalCount    *uint64 `json:"total_count,omitempty"`
+	CountMismatch bool    `json:"count_mismatch,omitempty"`
+
+	// -pin-revisions: records fetched again after changing during the export,
+	// and records deleted or no longer matching the query by its end
+	Refetched uint64 `json:"refetched,omitempty"`
+	Vanished  uint64 `json:"vanished,omitempty"`
+
+	// records left out or masked by the redaction rules
+	Redacted uint64 `json:"redacted,omitempty"`
+	Masked   uint64 `json:"masked,omitempty"`
+
+	// values cut by -truncate-text
+	Truncated uint64 `json:"truncated,omitempty"`
+
+	// attachments queued by -queue-attachment-failures
+	AttachmentFailures uint64 `json:"attachment_failures,omitempty"`
+
+	// records with characters the output encoding could not represent
+	LossyRecords   uint64   `json:"lossy_records,omitempty"`
+	LossyRecordIds []uint64 `json:"lossy_record_ids,omitempty"`
+
+	// throughput of fetch, serialize, download and upload
+	Stages map[string]*StageStats `json:"stages,omitempty"`
+
+	// the copies to the -replicate-to buckets
+	Replicas []*Replica `json:"replicas,omitempty"`
+}
+
+var run Run
+
+func newRunId(t time.Time) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return t.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
+
+func startRun() {
+	now := time.Now()
+	run = Run{
+		Id:        newRunId(now),
+		Domain:    config.domain,
+		AppId:     config.appId,
+		Query:     config.query,
+		Status:    RUN_RUNNING,
+		StartedAt: now,
+		Attempt:   config.attempt,
+		Tags:      config.snapshotTags,
+	}
+}
+
+// <run id>-<attempt>, in the keys of part objects so a retried or
+// concurrent run writes parts of its own under a shared prefix
+func attemptId() string {
+	return fmt.Sprintf("%s-%d", run.Id, run.Attempt)
+}
+
+func finishRun(err error) {
+	run.FinishedAt = time.Now()
+	run.Duration = run.FinishedAt.Sub(run.StartedAt).Seconds()
+	finishStages()
+	if err != nil {
+		run.Status = RUN_FAILED
+		run.Error = err.Error()
+	} else if run.Status == RUN_RUNNING {
+		run.Status = RUN_SUCCEEDED
+	}
+}
+
+// end the run of any output: log it, tell -sns-topic-arn and -event-bus
+// and save it in -history
+func endRun(err error) {
+	finishRun(err)
+	logStages()
+	if run.LossyRecords > 0 {
+		log.Printf(T("%d records had characters which cannot be encoded in %s, replaced with %q"), run.LossyRecords, config.encoding, config.encodingPlaceholder)
+	}
+	reportRun(err)
+
+	if config.history != "" {
+		svc, err := newS3Client()
+		if err == nil {
+			err = saveRun(svc)
+		}
+		if err != nil {
+			log.Printf(T("could not save run history: %v"), err)
+		}
+	}
+}
+
+// tell -sns-topic-arn and -event-bus how the run ended
+func reportRun(err error) {
+	publishRunNotification(err)
+	putRunEvent(err)
+}
+
+// objects of a split export are uploaded concurrently
+var runMu sync.Mutex
+
+// an upload which failed after its retries fails the run, and the process
+// exits non-zero so the scheduler sees it
+func uploadError(key string, err error) error {
+	return fmt.Errorf(T("upload to s3://%s/%s failed: %v"), config.bucketName, key, err)
+}
+
+// split "s3://bucket/prefix" into its bucket and prefix
+func parseS3URL(s string) (string, string, error) {
+	if !strings.HasPrefix(s, "s3://") {
+		return "", "", fmt.Errorf(T("not an s3:// URL: %s"), s)
+	}
+	path := strings.TrimPrefix(s, "s3://")
+	bucket := path
+	prefix := ""
+	if idx := strings.Index(path, "/"); idx >= 0 {
+		bucket = path[:idx]
+		prefix = strings.Trim(path[idx+1:], "/")
+	}
+	if bucket == "" {
+		return "", "", fmt.Errorf(T("bucket name is missing: %s"), s)
+	}
+	return bucket, prefix, nil
+}
+
+func historyKey(prefix string, appId uint64, id string) string {
+	key := fmt.Sprintf("%d/%s.json", appId, id)
+	if prefix != "" {
+		key = prefix + "/" + key
+	}
+	return key
+}
+
+func saveRun(svc *s3.S3) error {
+	bucket, prefix, err := parseS3URL(config.history)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(&run, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(historyKey(prefix, run.AppId, run.Id)),
+		Body:        strings.NewReader(string(data)),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+// read every run record under the history prefix, newest first
+func loadRuns(svc *s3.S3, appId uint64) ([]*Run, error) {
+	bucket, prefix, err := parseS3URL(config.history)
+	if err != nil {
+		return nil, err
+	}
+
+	listPrefix := prefix
+	if appId != 0 {
+		listPrefix = strings.TrimSuffix(historyKey(prefix, appId, ""), ".json")
+	} else if listPrefix != "" {
+		listPrefix += "/"
+	}
+
+	keys := make([]string, 0)
+	err = svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(listPrefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			if strings.HasSuffix(*obj.Key, ".json") {
+				keys = append(keys, *obj.Key)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]*Run, 0, len(keys))
+	for _, key := range keys {
+		r, err := loadRun(svc, bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+	return runs, nil
+}
+
+func loadRun(svc *s3.S3, bucket, key string) (*Run, error) {
+	out, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	data, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var r Run
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("%s: %v", key, err)
+	}
+	return &r, nil
+}
+
+// runs list | runs show <run id> | runs tag <tag> | runs download <run id or tag>
+func runsCommand(args []string) error {
+	if config.history == "" {
+		return errors.New(T("run history is not configured (-history)"))
+	}
+	if len(args) == 0 {
+		return errors.New(T("usage: runs list | runs show <run id> | runs tag <tag> | runs download <run id or tag>"))
+	}
+
+	svc, err := newS3Client()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list", "tag":
+		runs, err := loadRuns(svc, config.appId)
+		if err != nil {
+			return err
+		}
+		if args[0] == "tag" {
+			if len(args) < 2 {
+				return errors.New(T("usage: runs tag <tag>"))
+			}
+			runs = taggedRuns(runs, args[1])
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, T("ID\tAPP\tSTATUS\tSTARTED\tDURATION\tRECORDS\tDESTINATION"))
+		for _, r := range runs {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%.1fs\t%d\t%s\n",
+				r.Id, r.AppId, r.Status, r.StartedAt.Format(time.RFC3339), r.Duration, r.Records, r.Destination)
+		}
+		return w.Flush()
+	case "show":
+		if len(args) < 2 {
+			return errors.New(T("usage: runs show <run id>"))
+		}
+		runs, err := loadRuns(svc, config.appId)
+		if err != nil {
+			return err
+		}
+		for _, r := range runs {
+			if r.Id == args[1] {
+				data, _ := json.MarshalIndent(r, "", "  ")
+				fmt.Println(string(data))
+				return nil
+			}
+		}
+		return fmt.Errorf(T("run not found: %s"), args[1])
+	case "download":
+		if len(args) < 2 {
+			return errors.New(T("usage: runs download <run id or tag>"))
+		}
+		runs, err := loadRuns(svc, config.appId)
+		if err != nil {
+			return err
+		}
+		return downloadCommand(svc, runs, args[1])
+	}
+	return fmt.Errorf(T("unknown runs command: %s"), args[0])
+}
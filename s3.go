@@ -0,0 +1,306 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the object key when neither -k nor KINTONE_TO_S3_KEY is given
+const S3_KEY = "golang-kintone-to-s3.csv"
+
+// configuration shared by all AWS service clients
+func newAwsConfig() *aws.Config {
+	creds := credentials.NewStaticCredentials(config.accessKey, config.secretAccessKey, config.sessionToken)
+	if config.awsProfile != "" {
+		// a profile of the shared credentials file instead of the keys
+		creds = credentials.NewSharedCredentials("", config.awsProfile)
+	}
+	return &aws.Config{
+		Credentials: creds,
+		Region:      aws.String(config.region),
+		HTTPClient:  sharedHTTPClient(),
+	}
+}
+
+// the profiles of the shared credentials file, for -aws-profile
+func awsProfileNames() ([]string, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			names = append(names, strings.TrimSpace(line[1:len(line)-1]))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func newS3Client() (*s3.S3, error) {
+	return newS3ClientIn(config.region)
+}
+
+// a client for a bucket in another region, e.g. a -replicate-to bucket
+func newS3ClientIn(region string) (*s3.S3, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	cfg := newAwsConfig()
+	cfg.Region = aws.String(region)
+	// each request, PutObject or a part of a multipart upload, is retried
+	// with exponential backoff
+	cfg.Retryer = client.DefaultRetryer{
+		NumMaxRetries: config.uploadRetries,
+		MinRetryDelay: time.Second,
+		MaxRetryDelay: time.Minute,
+	}
+	if config.s3Endpoint != "" {
+		// MinIO, Wasabi and the like; they mostly ignore the region but
+		// the signature needs one
+		cfg.Endpoint = aws.String(config.s3Endpoint)
+		if region == "" {
+			cfg.Region = aws.String("us-east-1")
+		}
+	}
+	if config.s3ForcePathStyle {
+		cfg.S3ForcePathStyle = aws.Bool(true)
+	}
+	if config.s3Accelerate {
+		// the bucket must have Transfer Acceleration enabled
+		cfg.S3UseAccelerate = aws.Bool(true)
+	}
+	if _, ok := accessPointARN(); ok {
+		// requests go to the access point's region, not -region; a
+		// Multi-Region Access Point routes them to the nearest bucket
+		cfg.S3UseARNRegion = aws.Bool(true)
+	}
+	return s3.New(sess, cfg), nil
+}
+
+// the IANA name of the output encoding, for the Content-Type
+func charset() string {
+	switch config.encoding {
+	case "utf-16":
+		return "utf-16le"
+	case "utf-16be-with-signature", "utf-16le-with-signature":
+		return "utf-16"
+	case "sjis", "cp932", "windows-31j":
+		return "windows-31j"
+	}
+	return config.encoding
+}
+
+// the Content-Type of the exported objects. what a -pipe command makes of
+// the output is up to it. -compress is told by exportContentEncoding.
+func exportContentType() string {
+	switch {
+	case config.envelopeKmsKeyId != "":
+		return "application/octet-stream"
+	case config.pipe != "":
+		return "application/octet-stream"
+	case config.format == "json":
+		return "application/json; charset=" + charset()
+	case config.format == "sqlite":
+		return "application/vnd.sqlite3"
+	}
+	return "text/csv; charset=" + charset()
+}
+
+// the Content-Encoding of the exported objects, or nil. whatever -pipe
+// made of the records is compressed after it; the envelope seals the
+// compressed bytes, so they are not gzip as stored.
+func exportContentEncoding() *string {
+	if config.compress == COMPRESS_GZIP && config.envelopeKmsKeyId == "" {
+		return aws.String("gzip")
+	}
+	return nil
+}
+
+// build the PutObject request shared by the export and the probe
+func newPutObjectInput(key string, body io.ReadSeeker) *s3.PutObjectInput {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(config.bucketName),
+		Key:    aws.String(key),
+		Body:   body,
+	}
+
+	if config.bucketOwner != "" {
+		// delivering into another account's bucket
+		input.ExpectedBucketOwner = aws.String(config.bucketOwner)
+	}
+
+	switch {
+	case config.acl != "":
+		input.ACL = aws.String(config.acl)
+	case config.objectOwnership == s3.ObjectOwnershipBucketOwnerEnforced:
+		// ACLs are disabled on the bucket, sending one would be rejected
+	case config.objectOwnership != "", config.bucketOwner != "":
+		// accepted by both enforced and preferred buckets
+		input.ACL = aws.String(s3.ObjectCannedACLBucketOwnerFullControl)
+	default:
+		// no ACL: the object stays private to the bucket owner
+	}
+
+	if config.sseKmsKeyId != "" {
+		input.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAwsKms)
+		input.SSEKMSKeyId = aws.String(config.sseKmsKeyId)
+	} else if config.sse != "" {
+		// aws:kms without a key ID is the account's aws/s3 key
+		input.ServerSideEncryption = aws.String(config.sse)
+	}
+
+	if config.storageClass != "" {
+		input.StorageClass = aws.String(config.storageClass)
+	}
+
+	if config.tagging != "" {
+		input.Tagging = aws.String(config.tagging)
+	}
+
+	if config.objectLockMode != "" {
+		input.ObjectLockMode = aws.String(config.objectLockMode)
+		input.ObjectLockRetainUntilDate = aws.Time(config.objectLockRetainUntil)
+	}
+
+	return input
+}
+
+// the same settings for an upload in parts
+func newCreateMultipartUploadInput(key string) *s3.CreateMultipartUploadInput {
+	put := newPutObjectInput(key, nil)
+	return &s3.CreateMultipartUploadInput{
+		Bucket:                    put.Bucket,
+		Key:                       put.Key,
+		ExpectedBucketOwner:       put.ExpectedBucketOwner,
+		ACL:                       put.ACL,
+		ServerSideEncryption:      put.ServerSideEncryption,
+		SSEKMSKeyId:               put.SSEKMSKeyId,
+		StorageClass:              put.StorageClass,
+		Tagging:                   put.Tagging,
+		ObjectLockMode:            put.ObjectLockMode,
+		ObjectLockRetainUntilDate: put.ObjectLockRetainUntilDate,
+	}
+}
+
+// write and remove a small object with the export's settings, so missing
+// permissions are reported before the records are fetched
+func probeBucket(svc *s3.S3, key string) error {
+	probeKey := key + ".probe"
+	input := newPutObjectInput(probeKey, strings.NewReader(""))
+	// a locked probe could not be removed
+	input.ObjectLockMode, input.ObjectLockRetainUntilDate = nil, nil
+	_, err := svc.PutObject(input)
+	if err != nil {
+		return fmt.Errorf(T("pre-flight PutObject to s3://%s/%s failed: %v"), config.bucketName, probeKey, err)
+	}
+
+	deleteInput := &s3.DeleteObjectInput{
+		Bucket: aws.String(config.bucketName),
+		Key:    aws.String(probeKey),
+	}
+	if config.bucketOwner != "" {
+		deleteInput.ExpectedBucketOwner = aws.String(config.bucketOwner)
+	}
+	if _, err := svc.DeleteObject(deleteInput); err != nil {
+		// the bucket owner may not grant us delete permission
+		log.Printf(T("could not remove probe object s3://%s/%s: %v"), config.bucketName, probeKey, err)
+	}
+	return nil
+}
+
+func validateObjectOwnership(ownership string) error {
+	switch ownership {
+	case "", s3.ObjectOwnershipBucketOwnerEnforced, s3.ObjectOwnershipBucketOwnerPreferred, s3.ObjectOwnershipObjectWriter:
+		return nil
+	}
+	return fmt.Errorf(T("unknown object ownership: %s"), ownership)
+}
+
+func validateACL(acl string) error {
+	if acl == "" {
+		return nil
+	}
+	if config.objectOwnership == s3.ObjectOwnershipBucketOwnerEnforced {
+		return errors.New(T("-acl cannot be used with BucketOwnerEnforced, which disables ACLs"))
+	}
+	for _, a := range s3.ObjectCannedACL_Values() {
+		if a == acl {
+			return nil
+		}
+	}
+	return fmt.Errorf(T("unknown ACL: %s"), acl)
+}
+
+// app=123,env=prod,pii=true into the query string form S3 takes
+func parseTags(s string) (string, error) {
+	tags := url.Values{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return "", fmt.Errorf(T("invalid tag: %s"), item)
+		}
+		tags.Set(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+	if len(tags) > 10 {
+		return "", errors.New(T("an object can have at most 10 tags"))
+	}
+	return tags.Encode(), nil
+}
+
+func validateSSE(sse string) error {
+	switch sse {
+	case "", s3.ServerSideEncryptionAwsKms:
+		return nil
+	case s3.ServerSideEncryptionAes256:
+		if config.sseKmsKeyId != "" {
+			return errors.New(T("-sse-kms-key-id needs -sse aws:kms"))
+		}
+		return nil
+	}
+	return fmt.Errorf(T("unknown server-side encryption: %s"), sse)
+}
+
+func validateStorageClass(class string) error {
+	if class == "" {
+		return nil
+	}
+	for _, c := range s3.StorageClass_Values() {
+		if c == class {
+			return nil
+		}
+	}
+	return fmt.Errorf(T("unknown storage class: %s"), class)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EVENT_FORMAT_TOOL = "tool"
+	EVENT_FORMAT_S3   = "s3"
+)
+
+func validateEventFormat(format string) error {
+	switch format {
+	case EVENT_FORMAT_TOOL, EVENT_FORMAT_S3:
+		return nil
+	}
+	return fmt.Errorf(T("unknown event format: %s"), format)
+}
+
+// the shape of an S3 event notification, so consumers of native
+// ObjectCreated events can take the completion messages as they are
+type s3Event struct {
+	Records []s3EventRecord `json:"Records"`
+}
+
+type s3EventRecord struct {
+	EventVersion string           `json:"eventVersion"`
+	EventSource  string           `json:"eventSource"`
+	AwsRegion    string           `json:"awsRegion"`
+	EventTime    string           `json:"eventTime"`
+	EventName    string           `json:"eventName"`
+	UserIdentity s3EventPrincipal `json:"userIdentity"`
+	S3           s3EventEntity    `json:"s3"`
+}
+
+type s3EventPrincipal struct {
+	PrincipalId string `json:"principalId"`
+}
+
+type s3EventEntity struct {
+	SchemaVersion   string        `json:"s3SchemaVersion"`
+	ConfigurationId string        `json:"configurationId"`
+	Bucket          s3EventBucket `json:"bucket"`
+	Object          s3EventObject `json:"object"`
+}
+
+type s3EventBucket struct {
+	Name string `json:"name"`
+	Arn  string `json:"arn"`
+}
+
+type s3EventObject struct {
+	Key       string `json:"key"`
+	Size      int64  `json:"size"`
+	ETag      string `json:"eTag"`
+	VersionId string `json:"versionId,omitempty"`
+	Sequencer string `json:"sequencer"`
+}
+
+// an ObjectCreated:Put event of the exported object, as S3 would send it.
+// the key is URL-encoded as in S3's own events.
+func newS3Event(svc *s3.S3, key string) (*s3Event, error) {
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket:              aws.String(config.bucketName),
+		Key:                 aws.String(key),
+		ExpectedBucketOwner: newPutObjectInput(key, nil).ExpectedBucketOwner,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	object := s3EventObject{
+		Key:       url.QueryEscape(key),
+		Size:      aws.Int64Value(head.ContentLength),
+		ETag:      strings.Trim(aws.StringValue(head.ETag), `"`),
+		VersionId: aws.StringValue(head.VersionId),
+		Sequencer: strings.ToUpper(strconv.FormatInt(now.UnixNano(), 16)),
+	}
+	return &s3Event{Records: []s3EventRecord{{
+		EventVersion: "2.1",
+		EventSource:  "aws:s3",
+		AwsRegion:    aws.StringValue(svc.Config.Region),
+		EventTime:    now.Format("2006-01-02T15:04:05.000Z"),
+		EventName:    "ObjectCreated:Put",
+		UserIdentity: s3EventPrincipal{PrincipalId: "golang-kintone-to-s3"},
+		S3: s3EventEntity{
+			SchemaVersion:   "1.0",
+			ConfigurationId: "golang-kintone-to-s3",
+			Bucket:          s3EventBucket{Name: config.bucketName, Arn: bucketARN()},
+			Object:          object,
+		},
+	}}}, nil
+}
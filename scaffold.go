@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kintone/go-kintone"
+	"os"
+	"sort"
+	"strings"
+)
+
+// a drop-down or radio button with more options is a poor partition column
+const MAX_PARTITION_OPTIONS = 20
+
+// layout-only fields hold no record data
+var layoutFieldTypes = map[string]bool{
+	"GROUP":           true,
+	"LABEL":           true,
+	"SPACER":          true,
+	"HR":              true,
+	"REFERENCE_TABLE": true,
+}
+
+// the drop-down or radio button with the fewest options, if any has a few
+func inferPartitionField(fields map[string]*kintone.FieldInfo) string {
+	best := ""
+	bestOptions := MAX_PARTITION_OPTIONS + 1
+	for _, f := range fields {
+		if f.Type != kintone.FT_SINGLE_SELECT && f.Type != kintone.FT_RADIO {
+			continue
+		}
+		n := len(f.Options)
+		if n < 2 || n > bestOptions || (n == bestOptions && f.Code > best) {
+			continue
+		}
+		best, bestOptions = f.Code, n
+	}
+	return best
+}
+
+// template: print a job config for the app, to be edited and passed to -config
+func templateCommand(app *kintone.App) error {
+	fields, err := getFields(app)
+	if err != nil {
+		return err
+	}
+
+	codes := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f.Code == "" || layoutFieldTypes[f.Type] {
+			continue
+		}
+		codes = append(codes, f.Code)
+	}
+	sort.Strings(codes)
+
+	partitionBy := "app_id,dt"
+	if field := inferPartitionField(fields); field != "" {
+		partitionBy += "," + field
+	}
+
+	job := map[string]interface{}{
+		"a":            config.appId,
+		"c":            strings.Join(append([]string{"$id", "$revision"}, codes...), ","),
+		"o":            "csv",
+		"e":            "utf-8",
+		"k":            fmt.Sprintf("exports/app-%d/records.{ext}", config.appId),
+		"partition-by": partitionBy,
+	}
+	if config.query != "" {
+		job["q"] = config.query
+	}
+
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, string(data))
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// a time of day, on some days of the week, when no export may start.
+// "01:00-03:00" is every day, "sat,sun 00:00-24:00" the weekend and
+// "mon-fri 09:00-18:00" business hours. a window may cross midnight.
+type blackoutWindow struct {
+	days  [7]bool
+	start int
+	end   int
+}
+
+func parseClock(s string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf(T("invalid time of day: %s"), s)
+	}
+	return h*60 + m, nil
+}
+
+func parseWeekday(s string) (int, error) {
+	for i, day := range weekdays {
+		if strings.HasPrefix(strings.ToLower(s), day) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf(T("invalid day of the week: %s"), s)
+}
+
+func parseBlackout(s string) (*blackoutWindow, error) {
+	w := &blackoutWindow{}
+	fields := strings.Fields(s)
+	switch len(fields) {
+	case 1:
+		for i := range w.days {
+			w.days[i] = true
+		}
+	case 2:
+		for _, item := range strings.Split(fields[0], ",") {
+			from, to := item, item
+			if i := strings.Index(item, "-"); i >= 0 {
+				from, to = item[:i], item[i+1:]
+			}
+			first, err := parseWeekday(from)
+			if err != nil {
+				return nil, err
+			}
+			last, err := parseWeekday(to)
+			if err != nil {
+				return nil, err
+			}
+			for d := first; ; d = (d + 1) % 7 {
+				w.days[d] = true
+				if d == last {
+					break
+				}
+			}
+		}
+		fields = fields[1:]
+	default:
+		return nil, fmt.Errorf(T("invalid blackout window: %s"), s)
+	}
+
+	clock := strings.SplitN(fields[0], "-", 2)
+	if len(clock) != 2 {
+		return nil, fmt.Errorf(T("invalid blackout window: %s"), s)
+	}
+	var err error
+	if w.start, err = parseClock(clock[0]); err != nil {
+		return nil, err
+	}
+	if w.end, err = parseClock(clock[1]); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *blackoutWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := int(t.Weekday())
+	if w.start <= w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+	// past midnight the window belongs to the day it started on
+	if minute >= w.start {
+		return w.days[day]
+	}
+	return minute < w.end && w.days[(day+6)%7]
+}
+
+func parseBlackouts(defs []string) ([]*blackoutWindow, error) {
+	windows := make([]*blackoutWindow, 0, len(defs))
+	for _, def := range defs {
+		w, err := parseBlackout(def)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// sleep a random part of -start-jitter, so jobs scheduled at the same
+// minute do not hit kintone at once, then tell whether the run falls in a
+// blackout window and should be skipped
+func waitForStart() bool {
+	if config.startJitter > 0 {
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		delay := time.Duration(r.Int63n(int64(config.startJitter)))
+		log.Printf(T("waiting %v before starting"), delay.Round(time.Second))
+		time.Sleep(delay)
+	}
+
+	now := time.Now()
+	for _, w := range config.blackouts {
+		if w.contains(now) {
+			log.Printf(T("skipping the export: %s is in a blackout window"), now.Format("Mon 15:04"))
+			return false
+		}
+	}
+	return true
+}
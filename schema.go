@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kintone/go-kintone"
+	"log"
+	"time"
+)
+
+// the part of kintone.FieldInfo the export depends on
+type cachedField struct {
+	Code    string        `json:"code"`
+	Label   string        `json:"label"`
+	Type    string        `json:"type"`
+	Options []string      `json:"options,omitempty"`
+	Fields  []cachedField `json:"fields,omitempty"`
+}
+
+type cachedSchema struct {
+	FetchedAt time.Time     `json:"fetched_at"`
+	Fields    []cachedField `json:"fields"`
+}
+
+func schemaCacheKey() string {
+	return fmt.Sprintf("schema/%s/%d.json", config.domain, config.appId)
+}
+
+func toCachedField(f *kintone.FieldInfo) cachedField {
+	c := cachedField{Code: f.Code, Label: f.Label, Type: f.Type, Options: f.Options}
+	for i := range f.Fields {
+		c.Fields = append(c.Fields, toCachedField(&f.Fields[i]))
+	}
+	return c
+}
+
+func fromCachedField(c cachedField) *kintone.FieldInfo {
+	f := &kintone.FieldInfo{Code: c.Code, Label: c.Label, Type: c.Type, Options: c.Options}
+	for _, sub := range c.Fields {
+		f.Fields = append(f.Fields, *fromCachedField(sub))
+	}
+	return f
+}
+
+// returns nil if the cache is missing, unreadable or older than the TTL
+func loadCachedFields() map[string]*kintone.FieldInfo {
+	data, err := state.Get(schemaCacheKey())
+	if err != nil {
+		log.Printf(T("could not read schema cache: %v"), err)
+		return nil
+	}
+	if data == nil {
+		return nil
+	}
+
+	var schema cachedSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		log.Printf(T("ignoring broken schema cache: %v"), err)
+		return nil
+	}
+	if time.Since(schema.FetchedAt) > config.schemaTTL {
+		return nil
+	}
+
+	fields := make(map[string]*kintone.FieldInfo, len(schema.Fields))
+	for _, c := range schema.Fields {
+		fields[c.Code] = fromCachedField(c)
+	}
+	return fields
+}
+
+func saveCachedFields(fields map[string]*kintone.FieldInfo) error {
+	schema := cachedSchema{FetchedAt: time.Now()}
+	for _, f := range fields {
+		schema.Fields = append(schema.Fields, toCachedField(f))
+	}
+
+	data, err := json.Marshal(&schema)
+	if err != nil {
+		return err
+	}
+	return state.Put(schemaCacheKey(), data)
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// where a credential is kept. a credential option may be given as a
+// reference such as ssm:///kintone/token instead of the secret itself.
+type secretProvider interface {
+	// the secret at ref, the part of the reference after <scheme>://
+	Lookup(ref string) (string, error)
+}
+
+var secretProviders = map[string]secretProvider{
+	"env":            envSecrets{},
+	"file":           fileSecrets{},
+	"secretsmanager": &secretsManagerSecrets{},
+	"ssm":            &ssmSecrets{},
+	"vault":          vault,
+}
+
+var vault = &vaultSecrets{}
+
+// the secrets looked up, as several options may refer to one secret
+var secretCache = struct {
+	sync.Mutex
+	values map[string]string
+}{values: make(map[string]string)}
+
+// the provider of a reference, nil for a literal value
+func parseSecretRef(value string) (secretProvider, string) {
+	i := strings.Index(value, "://")
+	if i < 0 {
+		return nil, ""
+	}
+	provider, ok := secretProviders[value[:i]]
+	if !ok {
+		return nil, ""
+	}
+	return provider, value[i+3:]
+}
+
+func isSecretRef(value string) bool {
+	provider, _ := parseSecretRef(value)
+	return provider != nil
+}
+
+// the secret a reference points at, or value itself if it is none.
+// <ref>#<name> takes the field name of a secret kept as a JSON object.
+func lookupSecret(value string) (string, error) {
+	provider, ref := parseSecretRef(value)
+	if provider == nil {
+		return value, nil
+	}
+	name := ""
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		ref, name = ref[:i], ref[i+1:]
+	}
+
+	secretCache.Lock()
+	defer secretCache.Unlock()
+	key := value
+	if name != "" {
+		key = strings.TrimSuffix(value, "#"+name)
+	}
+	secret, ok := secretCache.values[key]
+	if !ok {
+		var err error
+		if secret, err = provider.Lookup(ref); err != nil {
+			return "", fmt.Errorf(T("secret %s: %v"), value, err)
+		}
+		secretCache.values[key] = secret
+	}
+	if name == "" {
+		return secret, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf(T("secret %s: not a JSON object: %v"), value, err)
+	}
+	field, ok := fields[name]
+	if !ok {
+		return "", fmt.Errorf(T("secret %s: no field %s"), value, name)
+	}
+	if s, ok := field.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(field), nil
+}
+
+// look up the credential options given as secret references
+func resolveSecrets() error {
+	for _, v := range []*string{&config.login, &config.password, &config.basicAuthUser, &config.basicAuthPassword, &config.apiToken, &config.accessKey, &config.secretAccessKey, &config.azureConnectionString} {
+		secret, err := lookupSecret(*v)
+		if err != nil {
+			return err
+		}
+		*v = secret
+	}
+	if config.vaultAwsCreds != "" {
+		return vault.awsCredentials(config.vaultAwsCreds)
+	}
+	return nil
+}
+
+// env://NAME
+type envSecrets struct{}
+
+func (envSecrets) Lookup(ref string) (string, error) {
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf(T("%s is not set"), ref)
+	}
+	return value, nil
+}
+
+// file:///run/secrets/kintone-token, without the trailing newline
+type fileSecrets struct{}
+
+func (fileSecrets) Lookup(ref string) (string, error) {
+	data, err := ioutil.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// the AWS secret stores are reached with the default credential chain,
+// as the access key of -region's S3 may itself be one of their secrets
+func newSecretSession() (*session.Session, error) {
+	return session.NewSession(&aws.Config{
+		Region:     aws.String(config.region),
+		HTTPClient: sharedHTTPClient(),
+	})
+}
+
+// secretsmanager://<secret name or ARN>
+type secretsManagerSecrets struct {
+	svc *secretsmanager.SecretsManager
+}
+
+func (s *secretsManagerSecrets) Lookup(ref string) (string, error) {
+	if s.svc == nil {
+		sess, err := newSecretSession()
+		if err != nil {
+			return "", err
+		}
+		s.svc = secretsmanager.New(sess)
+	}
+	out, err := s.svc.GetSecretValue(&secretsmanager.GetSecretValueInput{SecretId: aws.String(ref)})
+	if err != nil {
+		return "", err
+	}
+	if out.SecretString == nil {
+		return string(out.SecretBinary), nil
+	}
+	return aws.StringValue(out.SecretString), nil
+}
+
+// ssm:///<parameter name>, SecureString parameters decrypted
+type ssmSecrets struct {
+	svc *ssm.SSM
+}
+
+func (s *ssmSecrets) Lookup(ref string) (string, error) {
+	if s.svc == nil {
+		sess, err := newSecretSession()
+		if err != nil {
+			return "", err
+		}
+		s.svc = ssm.New(sess)
+	}
+	out, err := s.svc.GetParameter(&ssm.GetParameterInput{
+		Name:           aws.String(ref),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.Parameter.Value), nil
+}
+
+// vault://<API path>, e.g. vault://secret/data/kintone#token for a KV
+// version 2 engine mounted at secret/, at VAULT_ADDR. the token is
+// VAULT_TOKEN, or one logged in for with VAULT_ROLE_ID and VAULT_SECRET_ID
+// through the AppRole auth method at VAULT_APPROLE_PATH (default approle).
+type vaultSecrets struct {
+	token string
+}
+
+func (v *vaultSecrets) login() (string, error) {
+	if v.token != "" {
+		return v.token, nil
+	}
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		v.token = token
+		return token, nil
+	}
+	roleId, secretId := os.Getenv("VAULT_ROLE_ID"), os.Getenv("VAULT_SECRET_ID")
+	if roleId == "" {
+		return "", errors.New(T("Vault needs VAULT_TOKEN, or VAULT_ROLE_ID and VAULT_SECRET_ID"))
+	}
+	mount := os.Getenv("VAULT_APPROLE_PATH")
+	if mount == "" {
+		mount = "approle"
+	}
+	body, err := json.Marshal(map[string]string{"role_id": roleId, "secret_id": secretId})
+	if err != nil {
+		return "", err
+	}
+	data, err := vaultRequest("POST", "auth/"+mount+"/login", "", body)
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return "", err
+	}
+	v.token = response.Auth.ClientToken
+	return v.token, nil
+}
+
+func (v *vaultSecrets) Lookup(ref string) (string, error) {
+	token, err := v.login()
+	if err != nil {
+		return "", err
+	}
+	body, err := vaultRequest("GET", ref, token, nil)
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", err
+	}
+	data := response.Data
+	// KV version 2 has the secret in data.data, next to data.metadata
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+	secret, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// take the AWS credentials from the AWS secrets engine, e.g. aws/creds/export
+// or aws/sts/export. they are not revoked but expire with their lease.
+func (v *vaultSecrets) awsCredentials(path string) error {
+	token, err := v.login()
+	if err != nil {
+		return err
+	}
+	data, err := vaultRequest("GET", path, token, nil)
+	if err != nil {
+		return fmt.Errorf(T("secret %s: %v"), "vault://"+path, err)
+	}
+
+	var response struct {
+		LeaseDuration int `json:"lease_duration"`
+		Data          struct {
+			AccessKey     string `json:"access_key"`
+			SecretKey     string `json:"secret_key"`
+			SecurityToken string `json:"security_token"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return err
+	}
+	if response.Data.AccessKey == "" {
+		return fmt.Errorf(T("%s has no AWS credentials"), "vault://"+path)
+	}
+	config.accessKey = response.Data.AccessKey
+	config.secretAccessKey = response.Data.SecretKey
+	config.sessionToken = response.Data.SecurityToken
+	log.Printf(T("AWS credentials from Vault %s, valid for %s"), path, time.Duration(response.LeaseDuration)*time.Second)
+	return nil
+}
+
+func vaultRequest(method, path, token string, body []byte) ([]byte, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return nil, fmt.Errorf(T("%s is not set"), "VAULT_ADDR")
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+
+	resp, err := sharedHTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, data)
+	}
+	return data, nil
+}
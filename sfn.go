@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sfn"
+	"log"
+	"time"
+)
+
+// reports the run back to a Step Functions task started with .waitForTaskToken
+type taskReporter struct {
+	svc  *sfn.SFN
+	done chan struct{}
+}
+
+func startTask() (*taskReporter, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	task := &taskReporter{
+		svc:  sfn.New(sess, newAwsConfig()),
+		done: make(chan struct{}),
+	}
+	go task.heartbeat()
+	return task, nil
+}
+
+func (t *taskReporter) heartbeat() {
+	ticker := time.NewTicker(config.taskHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+			_, err := t.svc.SendTaskHeartbeat(&sfn.SendTaskHeartbeatInput{
+				TaskToken: aws.String(config.taskToken),
+			})
+			if err != nil {
+				log.Printf(T("could not send task heartbeat: %v"), err)
+			}
+		}
+	}
+}
+
+// stop the heartbeats and send the run summary as the task result
+func (t *taskReporter) finish(err error) {
+	close(t.done)
+
+	if err != nil || run.Status == RUN_FAILED {
+		cause := run.Error
+		if err != nil {
+			cause = err.Error()
+		}
+		_, err = t.svc.SendTaskFailure(&sfn.SendTaskFailureInput{
+			TaskToken: aws.String(config.taskToken),
+			Error:     aws.String("ExportFailed"),
+			Cause:     aws.String(cause),
+		})
+	} else {
+		output, _ := json.Marshal(&run)
+		_, err = t.svc.SendTaskSuccess(&sfn.SendTaskSuccessInput{
+			TaskToken: aws.String(config.taskToken),
+			Output:    aws.String(string(output)),
+		})
+	}
+	if err != nil {
+		log.Printf(T("could not report task result: %v"), err)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io/ioutil"
+	"time"
+)
+
+// what the signature of an exported object covers
+type objectChecksum struct {
+	Bucket   string    `json:"bucket"`
+	Key      string    `json:"key"`
+	Bytes    int64     `json:"bytes"`
+	Sha256   string    `json:"sha256"`
+	RunId    string    `json:"run_id"`
+	SignedAt time.Time `json:"signed_at"`
+}
+
+// written next to the signed object as <key>.sig
+type signature struct {
+	Algorithm string `json:"algorithm"`
+	KeyId     string `json:"key_id"`
+	Signature string `json:"signature"`
+}
+
+// signs a SHA-256 digest
+type signer interface {
+	Sign(digest []byte) (*signature, error)
+}
+
+// nil unless -sign-kms-key-id or -sign-key is given
+var exportSigner signer
+
+func newSigner() (signer, error) {
+	switch {
+	case config.signKmsKeyId != "" && config.signKeyFile != "":
+		return nil, errors.New(T("-sign-kms-key-id and -sign-key cannot be used together"))
+	case config.signKmsKeyId != "":
+		sess, err := session.NewSession()
+		if err != nil {
+			return nil, err
+		}
+		return &kmsSigner{svc: kms.New(sess, newAwsConfig()), keyId: config.signKmsKeyId, algorithm: config.signAlgorithm}, nil
+	case config.signKeyFile != "":
+		return loadKeySigner(config.signKeyFile)
+	}
+	return nil, nil
+}
+
+type kmsSigner struct {
+	svc       *kms.KMS
+	keyId     string
+	algorithm string
+}
+
+func (k *kmsSigner) Sign(digest []byte) (*signature, error) {
+	out, err := k.svc.Sign(&kms.SignInput{
+		KeyId:            aws.String(k.keyId),
+		Message:          digest,
+		MessageType:      aws.String(kms.MessageTypeDigest),
+		SigningAlgorithm: aws.String(k.algorithm),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &signature{
+		Algorithm: k.algorithm,
+		KeyId:     aws.StringValue(out.KeyId),
+		Signature: base64.StdEncoding.EncodeToString(out.Signature),
+	}, nil
+}
+
+// a PEM private key: RSA (signed with PSS), ECDSA or Ed25519
+type keySigner struct {
+	key   crypto.Signer
+	keyId string
+}
+
+// path is a PEM file, or a secret reference to the PEM
+func loadKeySigner(path string) (signer, error) {
+	var data []byte
+	var err error
+	if isSecretRef(path) {
+		var secret string
+		secret, err = lookupSecret(path)
+		data = []byte(secret)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf(T("no PEM private key in %s"), path)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	s, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf(T("unsupported private key in %s"), path)
+	}
+
+	// the key is identified by the digest of its public key
+	public, err := x509.MarshalPKIXPublicKey(s.Public())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(public)
+	return &keySigner{key: s, keyId: "sha256:" + hex.EncodeToString(sum[:])}, nil
+}
+
+func (k *keySigner) Sign(digest []byte) (*signature, error) {
+	var algorithm string
+	var sig []byte
+	var err error
+	switch key := k.key.(type) {
+	case *rsa.PrivateKey:
+		algorithm = kms.SigningAlgorithmSpecRsassaPssSha256
+		sig, err = rsa.SignPSS(rand.Reader, key, crypto.SHA256, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case *ecdsa.PrivateKey:
+		algorithm = kms.SigningAlgorithmSpecEcdsaSha256
+		sig, err = key.Sign(rand.Reader, digest, crypto.SHA256)
+	case ed25519.PrivateKey:
+		// Ed25519 signs the message itself, which here is the digest
+		algorithm = "ED25519"
+		sig, err = key.Sign(rand.Reader, digest, crypto.Hash(0))
+	default:
+		return nil, errors.New(T("unsupported private key"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &signature{
+		Algorithm: algorithm,
+		KeyId:     k.keyId,
+		Signature: base64.StdEncoding.EncodeToString(sig),
+	}, nil
+}
+
+// sign data and put the signature at key.sig
+func putSignature(svc *s3.S3, key string, data []byte) error {
+	digest := sha256.Sum256(data)
+	sig, err := exportSigner.Sign(digest[:])
+	if err != nil {
+		return err
+	}
+
+	body, err := json.MarshalIndent(sig, "", "  ")
+	if err != nil {
+		return err
+	}
+	input := newPutObjectInput(key+".sig", bytes.NewReader(body))
+	input.ContentType = aws.String("application/json")
+	_, err = svc.PutObject(input)
+	return err
+}
+
+// put the checksum document of an exported object and its signature, at
+// key.sha256.json and key.sha256.json.sig
+func signObject(svc *s3.S3, key string, size int64, sum []byte) error {
+	doc := objectChecksum{
+		Bucket:   config.bucketName,
+		Key:      key,
+		Bytes:    size,
+		Sha256:   hex.EncodeToString(sum),
+		RunId:    run.Id,
+		SignedAt: time.Now(),
+	}
+	data, err := json.MarshalIndent(&doc, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	checksumKey := key + ".sha256.json"
+	input := newPutObjectInput(checksumKey, bytes.NewReader(data))
+	input.ContentType = aws.String("application/json")
+	if _, err := svc.PutObject(input); err != nil {
+		return err
+	}
+	return putSignature(svc, checksumKey, data)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"log"
+)
+
+// publish the finished run, succeeded or failed, to -sns-topic-arn for
+// alerting. the status is a message attribute, so a subscription can take
+// the failures only with a filter policy.
+func publishRunNotification(err error) {
+	if config.snsTopicArn == "" {
+		return
+	}
+	status := run.Status
+	if err != nil {
+		status = RUN_FAILED
+	}
+	message, _ := json.Marshal(&run)
+
+	sess, perr := session.NewSession()
+	if perr == nil {
+		_, perr = sns.New(sess, newAwsConfig()).Publish(&sns.PublishInput{
+			TopicArn: aws.String(config.snsTopicArn),
+			Subject:  aws.String(fmt.Sprintf("golang-kintone-to-s3: app %d %s", run.AppId, status)),
+			Message:  aws.String(string(message)),
+			MessageAttributes: map[string]*sns.MessageAttributeValue{
+				"status": {DataType: aws.String("String"), StringValue: aws.String(status)},
+				"app_id": {DataType: aws.String("Number"), StringValue: aws.String(fmt.Sprint(run.AppId))},
+			},
+		})
+	}
+	if perr != nil {
+		log.Printf(T("could not publish to %s: %v"), config.snsTopicArn, perr)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/kintone/go-kintone"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// value of an empty field in the key, as Hive names it
+const EMPTY_PARTITION = "__HIVE_DEFAULT_PARTITION__"
+
+// value in the key of the records whose value is not one of the options,
+// e.g. an option removed since they were saved
+const OTHER_PARTITION = "__OTHER__"
+
+var queryTailRegexp = regexp.MustCompile(`(?i)\b(order\s+by|limit|offset)\b`)
+
+func validateSplitOptions() error {
+	if chunked() || config.appendMode {
+		return errors.New(T("-split-by cannot be combined with -chunk-records, -chunk-time or -append"))
+	}
+	if config.splitParallel < 1 {
+		config.splitParallel = 1
+	}
+	return nil
+}
+
+// add a condition to the query, keeping its order by, limit and offset at the end
+func andQuery(query, cond string) string {
+	tail := ""
+	if loc := queryTailRegexp.FindStringIndex(query); loc != nil {
+		query, tail = query[:loc[0]], " "+query[loc[0]:]
+	}
+	if strings.TrimSpace(query) == "" {
+		return cond + tail
+	}
+	return "(" + strings.TrimSpace(query) + ") and " + cond + tail
+}
+
+// the query without its order by, limit and offset
+func queryCondition(query string) string {
+	if loc := queryTailRegexp.FindStringIndex(query); loc != nil {
+		return query[:loc[0]]
+	}
+	return query
+}
+
+func quoteQueryValue(s string) string {
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(s) + "\""
+}
+
+// one value of the split field and the sub-query selecting it
+type splitPart struct {
+	value string
+	query string
+}
+
+// enumerate the values of the split field: the options of a drop-down or
+// radio button and a part for any other value, or the distinct values
+// found by scanning the field
+func splitParts(app *kintone.App) ([]splitPart, error) {
+	fields, err := getFields(app)
+	if err != nil {
+		return nil, err
+	}
+	field := fields[config.splitBy]
+	if field == nil {
+		return nil, fmt.Errorf(T("unknown field: %s"), config.splitBy)
+	}
+
+	code := config.splitBy
+	parts := make([]splitPart, 0)
+	switch field.Type {
+	case kintone.FT_SINGLE_SELECT, kintone.FT_RADIO:
+		known := make([]string, 0, len(field.Options)+1)
+		for _, option := range field.Options {
+			parts = append(parts, splitPart{option, code + " in (" + quoteQueryValue(option) + ")"})
+			known = append(known, quoteQueryValue(option))
+		}
+		if field.Type == kintone.FT_SINGLE_SELECT {
+			parts = append(parts, splitPart{"", code + " in (\"\")"})
+			known = append(known, "\"\"")
+		}
+		parts = append(parts, splitPart{OTHER_PARTITION, code + " not in (" + strings.Join(known, ", ") + ")"})
+	case kintone.FT_SINGLE_LINE_TEXT, kintone.FT_DECIMAL, kintone.FT_LINK:
+		values, err := distinctValues(app, code)
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range values {
+			if value == "" {
+				parts = append(parts, splitPart{"", code + " is empty"})
+			} else {
+				parts = append(parts, splitPart{value, code + " = " + quoteQueryValue(value)})
+			}
+		}
+	default:
+		return nil, fmt.Errorf(T("cannot split by a field of type %s: %s"), field.Type, code)
+	}
+
+	for i := range parts {
+		parts[i].query = andQuery(config.query, parts[i].query)
+	}
+	return parts, nil
+}
+
+func distinctValues(app *kintone.App, code string) ([]string, error) {
+	seen := make(map[string]bool)
+	for offset := int64(0); ; offset += EXPORT_ROW_LIMIT {
+		if err := interruption(); err != nil {
+			return nil, err
+		}
+		records, eof, err := getRecords(app, config.query, []string{code}, offset)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			seen[toString(record.Fields[code], ",")] = true
+		}
+		if eof {
+			break
+		}
+	}
+
+	values := make([]string, 0, len(seen))
+	for value := range seen {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+// e.g. golang-kintone-to-s3/支店=東京/golang-kintone-to-s3.csv
+func splitKey(value string) string {
+	if partitionColumns != nil {
+		return partitionKey(value)
+	}
+	base := strings.TrimSuffix(config.key, path.Ext(config.key))
+	return base + "/" + config.splitBy + "=" + partitionValue(value) + "/" + path.Base(config.key)
+}
+
+// export each value of the split field into its own object, -split-parallel at a time
+func exportSplit(app *kintone.App, svc *s3.S3) error {
+	parts, err := splitParts(app)
+	if err != nil {
+		return err
+	}
+	if partitionColumns != nil {
+		run.Destination = "s3://" + config.bucketName + "/" + partitionBase()
+	} else {
+		run.Destination = "s3://" + config.bucketName + "/" + strings.TrimSuffix(config.key, path.Ext(config.key)) + "/"
+	}
+
+	sem := make(chan struct{}, config.splitParallel)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	for _, part := range parts {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(part splitPart) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			key := splitKey(part.value)
+			if _, err := exportObject(app, svc, part.query, key); err != nil {
+				log.Printf(T("s3://%s/%s: %v"), config.bucketName, key, err)
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(part)
+	}
+	wg.Wait()
+	return firstErr
+}
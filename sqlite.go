@@ -0,0 +1,263 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/kintone/go-kintone"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// the table of the records; each subtable gets a table of its own name
+const SQLITE_RECORDS_TABLE = "records"
+
+func validateSqliteOptions() error {
+	if chunked() || config.appendMode || config.splitBy != "" || config.pinRevisions {
+		return errors.New(T("-o sqlite cannot be combined with -chunk-records, -chunk-time, -append, -split-by or -pin-revisions"))
+	}
+	return nil
+}
+
+func sqliteIdent(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func sqliteType(f *Column) string {
+	switch f.Type {
+	case kintone.FT_ID, kintone.FT_REVISION, kintone.FT_SUBTABLE:
+		return "INTEGER"
+	case kintone.FT_DECIMAL:
+		return "NUMERIC"
+	}
+	return "TEXT"
+}
+
+// the columns of one table and the statement inserting a row into it
+type sqliteTable struct {
+	columns Columns
+	insert  *sql.Stmt
+}
+
+func createSqliteTable(tx *sql.Tx, name string, columns Columns, primaryKey string) (*sqliteTable, error) {
+	defs := make([]string, 0, len(columns))
+	marks := make([]string, 0, len(columns))
+	for _, f := range columns {
+		def := sqliteIdent(f.Code) + " " + sqliteType(f)
+		if f.Code == primaryKey {
+			def += " PRIMARY KEY"
+		}
+		defs = append(defs, def)
+		marks = append(marks, "?")
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf("CREATE TABLE %s (%s)", sqliteIdent(name), strings.Join(defs, ", "))); err != nil {
+		return nil, err
+	}
+	insert, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s VALUES (%s)", sqliteIdent(name), strings.Join(marks, ", ")))
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteTable{columns: columns, insert: insert}, nil
+}
+
+// numbers are stored as numbers, missing values as NULL
+func sqliteValue(f *Column, value string, ok bool) interface{} {
+	if !ok || (value == "" && sqliteType(f) != "TEXT") {
+		return nil
+	}
+	return value
+}
+
+func (t *sqliteTable) add(app *kintone.App, record *kintone.Record, j int, derivedValues map[string]string) error {
+	values := make([]interface{}, len(t.columns))
+	for i, f := range t.columns {
+		value, ok, err := cellValue(app, record, f, j, record.Id(), derivedValues)
+		if err != nil {
+			return err
+		}
+		values[i] = sqliteValue(f, value, ok)
+	}
+	_, err := t.insert.Exec(values...)
+	return err
+}
+
+// write the records into a SQLite database at path: the records table
+// keyed by $id, and a table per subtable with the $id of its record
+func writeSqlite(app *kintone.App, query string, path string) error {
+	fields, err := getFields(app)
+	if err != nil {
+		return err
+	}
+
+	duplicates, err := dedupeFilter(app, query)
+	if err != nil {
+		return err
+	}
+
+	var columns Columns
+	if config.fields == nil {
+		columns = makeColumns(fields)
+	} else {
+		columns = makePartialColumns(fields, config.fields)
+	}
+	columns = append(columns, derivedColumns()...)
+
+	recordId := &Column{Code: "$id", Type: kintone.FT_ID}
+	recordColumns := Columns{recordId}
+	subColumns := make(map[string]Columns)
+	tableNames := make([]string, 0)
+	for _, f := range columns {
+		switch {
+		case f.Type == kintone.FT_SUBTABLE:
+			// the row id of the subtable, then its fields
+			subColumns[f.Code] = Columns{recordId, f}
+			tableNames = append(tableNames, f.Code)
+		case f.IsSubField:
+			subColumns[f.Table] = append(subColumns[f.Table], f)
+		case f.Code != "$id":
+			recordColumns = append(recordColumns, f)
+		}
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	records, err := createSqliteTable(tx, SQLITE_RECORDS_TABLE, recordColumns, "$id")
+	if err != nil {
+		return err
+	}
+	tables := make([]*sqliteTable, 0, len(tableNames))
+	for _, name := range tableNames {
+		if name == SQLITE_RECORDS_TABLE {
+			return fmt.Errorf(T("a subtable is named %s, which is the table of the records"), name)
+		}
+		t, err := createSqliteTable(tx, name, subColumns[name], name)
+		if err != nil {
+			return err
+		}
+		tables = append(tables, t)
+	}
+
+	pages := fetchPages(app, query, fetchFields())
+	defer pages.stop()
+
+	for {
+		page, err := pages.next()
+		if err != nil {
+			return err
+		}
+		if page == nil {
+			break
+		}
+		started := time.Now()
+
+		for _, record := range page {
+			if duplicates[record.Id()] {
+				atomic.AddUint64(&run.Duplicates, 1)
+				continue
+			}
+			if redactRecord(record) {
+				continue
+			}
+			if err := truncateRecord(record); err != nil {
+				return err
+			}
+
+			derivedValues := evalDerived(record)
+			if err := records.add(app, record, 0, derivedValues); err != nil {
+				return err
+			}
+			rows := uint64(1)
+			for i, t := range tables {
+				n := len(record.Fields[tableNames[i]].(kintone.SubTableField))
+				for j := 0; j < n; j++ {
+					if err := t.add(app, record, j, derivedValues); err != nil {
+						return err
+					}
+				}
+				rows += uint64(n)
+			}
+			atomic.AddUint64(&run.Rows, rows)
+			atomic.AddUint64(&run.Records, 1)
+		}
+		addStage(STAGE_SERIALIZE, time.Since(started), uint64(len(page)), 0)
+	}
+	return tx.Commit()
+}
+
+// build the database in the work directory, then upload it to the key
+func exportSqlite(app *kintone.App, svc *s3.S3, key string) error {
+	path := filepath.Join(workdir, "export.sqlite")
+	if err := writeSqlite(app, config.query, path); err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	buf := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(buf)
+
+	if err := reconcileRecordCount(); err != nil {
+		return err
+	}
+
+	upload := newUploadPipeline(svc, key)
+	upload.metadata = provenanceMetadata
+	upload.ifNoneMatch = config.ifNotExists != ""
+	var out io.Writer = upload
+	env, err := encryptUpload(upload)
+	if err != nil {
+		upload.Abort()
+		return err
+	}
+	if env != nil {
+		out = env
+	}
+	if _, err := io.CopyBuffer(out, file, *buf); err != nil {
+		upload.Abort()
+		return err
+	}
+	if env != nil {
+		if err := env.Close(); err != nil {
+			upload.Abort()
+			return err
+		}
+	}
+	err = upload.Close()
+	atomic.AddInt64(&run.Bytes, upload.Len())
+	if err != nil {
+		return uploadError(key, err)
+	}
+	addManifestObject(key, upload.Len(), run.Records, upload.Sum())
+
+	if exportSigner != nil {
+		if err := signObject(svc, key, upload.Len(), upload.Sum()); err != nil {
+			return err
+		}
+	}
+	if config.sha256Sidecar {
+		return putChecksumSidecar(svc, key, upload.Sum())
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"log"
+	"strings"
+	"time"
+)
+
+func validateSqsOptions() error {
+	if config.sqsQueueURL == "" {
+		return nil
+	}
+	if localOutput() || config.firehoseStream != "" {
+		return errors.New(T("-sqs-queue-url needs an export to S3"))
+	}
+	if config.splitBy != "" || config.jsonDocumentRecords > 0 {
+		return errors.New(T("-sqs-queue-url cannot be combined with -split-by or -json-document-records"))
+	}
+	return nil
+}
+
+// the completion message of an exported object
+type exportedObject struct {
+	Bucket     string    `json:"bucket"`
+	Key        string    `json:"key"`
+	Records    uint64    `json:"records"`
+	Bytes      int64     `json:"bytes"`
+	RunId      string    `json:"run_id"`
+	Attempt    int       `json:"attempt"`
+	Domain     string    `json:"domain"`
+	AppId      uint64    `json:"app_id"`
+	Query      string    `json:"query"`
+	Tags       []string  `json:"tags,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// the body of a completion message in -event-format
+func completionMessage(svc *s3.S3, key string) ([]byte, error) {
+	var message interface{} = &exportedObject{
+		Bucket:     config.bucketName,
+		Key:        key,
+		Records:    run.Records,
+		Bytes:      run.Bytes,
+		RunId:      run.Id,
+		Attempt:    run.Attempt,
+		Domain:     run.Domain,
+		AppId:      run.AppId,
+		Query:      run.Query,
+		Tags:       run.Tags,
+		StartedAt:  run.StartedAt.UTC(),
+		ExportedAt: time.Now().UTC(),
+	}
+	if config.eventFormat == EVENT_FORMAT_S3 {
+		event, err := newS3Event(svc, key)
+		if err != nil {
+			return nil, err
+		}
+		message = event
+	}
+	return json.Marshal(message)
+}
+
+// tell the consumers of -sqs-queue-url the object is in place, so they need
+// no S3 event notification of the bucket
+func sendSqsMessage(svc *s3.S3, key string) error {
+	body, err := completionMessage(svc, key)
+	if err != nil {
+		return err
+	}
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(config.sqsQueueURL),
+		MessageBody: aws.String(string(body)),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"run_id": {DataType: aws.String("String"), StringValue: aws.String(run.Id)},
+		},
+	}
+	// a FIFO queue keeps the exports of an app in order, and takes a
+	// retried attempt's message only once
+	if strings.HasSuffix(config.sqsQueueURL, ".fifo") {
+		input.MessageGroupId = aws.String(fmt.Sprintf("%s/%d", config.domain, config.appId))
+		input.MessageDeduplicationId = aws.String(attemptId())
+	}
+	out, err := sqs.New(sess, newAwsConfig()).SendMessage(input)
+	if err != nil {
+		return fmt.Errorf(T("SQS message to %s: %v"), config.sqsQueueURL, err)
+	}
+	log.Printf(T("sent SQS message %s"), aws.StringValue(out.MessageId))
+	return nil
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StateStore keeps state between runs: incremental watermarks, checkpoints and locks.
+type StateStore interface {
+	// Get returns nil without error if the key does not exist.
+	Get(key string) ([]byte, error)
+	Put(key string, data []byte) error
+	Delete(key string) error
+	// Lock takes an exclusive lock which expires after ttl, so a crashed
+	// run does not block the next one forever.
+	Lock(key string, ttl time.Duration) (unlock func() error, err error)
+}
+
+var ErrLocked = errors.New(T("state is locked by another run"))
+
+// the lock expired during the run and another run holds it now
+var errLockLost = errors.New(T("the lock expired and was taken over by another run"))
+
+type lockInfo struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+func newLockInfo(ttl time.Duration) []byte {
+	data, _ := json.Marshal(&lockInfo{Owner: run.Id, Expires: time.Now().Add(ttl)})
+	return data
+}
+
+func lockExpired(data []byte) bool {
+	var info lockInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return true
+	}
+	return time.Now().After(info.Expires)
+}
+
+// open -state for the run and take the app's run lock, so only one run
+// per app goes at a time. the returned func releases the lock.
+func openState() (func(), error) {
+	if config.state == "" {
+		return func() {}, nil
+	}
+	var err error
+	if state, err = newStateStore(config.state); err != nil {
+		return nil, err
+	}
+	unlock, err := state.Lock(fmt.Sprintf("lock/%s/%d", config.domain, config.appId), config.lockTTL)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := unlock(); err != nil {
+			log.Printf(T("could not release lock: %v"), err)
+		}
+	}, nil
+}
+
+// location is a local directory (optionally file://), s3://bucket/prefix or dynamodb://table
+func newStateStore(location string) (StateStore, error) {
+	switch {
+	case strings.HasPrefix(location, "s3://"):
+		bucket, prefix, err := parseS3URL(location)
+		if err != nil {
+			return nil, err
+		}
+		svc, err := newS3Client()
+		if err != nil {
+			return nil, err
+		}
+		return &s3StateStore{svc: svc, bucket: bucket, prefix: prefix}, nil
+	case strings.HasPrefix(location, "dynamodb://"):
+		table := strings.TrimPrefix(location, "dynamodb://")
+		if table == "" {
+			return nil, fmt.Errorf(T("table name is missing: %s"), location)
+		}
+		sess, err := session.NewSession()
+		if err != nil {
+			return nil, err
+		}
+		return &dynamoStateStore{svc: dynamodb.New(sess, newAwsConfig()), table: table}, nil
+	default:
+		dir := strings.TrimPrefix(location, "file://")
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return nil, err
+		}
+		return &fileStateStore{dir: dir}, nil
+	}
+}
+
+// local files, for on-prem hosts
+type fileStateStore struct {
+	dir string
+}
+
+func (f *fileStateStore) path(key string) string {
+	return filepath.Join(f.dir, filepath.FromSlash(key))
+}
+
+func (f *fileStateStore) Get(key string) ([]byte, error) {
+	data, err := ioutil.ReadFile(f.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (f *fileStateStore) Put(key string, data []byte) error {
+	path := f.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	// write a temp file first so a crash never leaves half a checkpoint
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (f *fileStateStore) Delete(key string) error {
+	err := os.Remove(f.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// create path with data, failing if it exists
+func createExclusive(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (f *fileStateStore) Lock(key string, ttl time.Duration) (func() error, error) {
+	path := f.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return nil, err
+	}
+
+	lock := newLockInfo(ttl)
+	for retry := 0; retry < 2; retry++ {
+		err := createExclusive(path, lock)
+		if err == nil {
+			return func() error { return f.unlock(path, lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !lockExpired(data) {
+			return nil, ErrLocked
+		}
+		if err := f.removeExpired(path, data); err != nil {
+			return nil, err
+		}
+	}
+	return nil, ErrLocked
+}
+
+// remove the expired lock for a new one. the runs finding it expired take
+// turns by a guard file, and the lock is removed only if it is still the
+// one found expired, not one another run has taken since.
+func (f *fileStateStore) removeExpired(path string, expired []byte) error {
+	guard := path + ".takeover"
+	if err := createExclusive(guard, nil); err != nil {
+		if !os.IsExist(err) {
+			return err
+		}
+		// left by a run which died taking over
+		if info, err := os.Stat(guard); err == nil && time.Since(info.ModTime()) > time.Minute {
+			os.Remove(guard)
+		}
+		return ErrLocked
+	}
+	defer os.Remove(guard)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, expired) {
+		return ErrLocked
+	}
+	return os.Remove(path)
+}
+
+// remove the lock if it is still the one taken
+func (f *fileStateStore) unlock(path string, lock []byte) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return errLockLost
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, lock) {
+		return errLockLost
+	}
+	return os.Remove(path)
+}
+
+// objects under an S3 prefix, for containers without local storage
+type s3StateStore struct {
+	svc    *s3.S3
+	bucket string
+	prefix string
+}
+
+func (s *s3StateStore) key(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return s.prefix + "/" + key
+}
+
+func (s *s3StateStore) Get(key string) ([]byte, error) {
+	data, _, err := s.get(key)
+	return data, err
+}
+
+// the object and its ETag, or nil if it does not exist
+func (s *s3StateStore) get(key string) ([]byte, *string, error) {
+	out, err := s.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	defer out.Body.Close()
+	data, err := ioutil.ReadAll(out.Body)
+	return data, out.ETag, err
+}
+
+func (s *s3StateStore) Put(key string, data []byte) error {
+	_, err := s.svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+		Body:   strings.NewReader(string(data)),
+	})
+	return err
+}
+
+func (s *s3StateStore) Delete(key string) error {
+	_, err := s.svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	return err
+}
+
+// a conditional request which found the object changed or gone
+func conditionFailed(err error) bool {
+	aerr, ok := err.(awserr.RequestFailure)
+	return ok && (aerr.StatusCode() == 412 || aerr.StatusCode() == 404)
+}
+
+func (s *s3StateStore) Lock(key string, ttl time.Duration) (func() error, error) {
+	lock := newLockInfo(ttl)
+	for retry := 0; retry < 2; retry++ {
+		// conditional write: fails if the lock object already exists
+		out, err := s.svc.PutObject(&s3.PutObjectInput{
+			Bucket:      aws.String(s.bucket),
+			Key:         aws.String(s.key(key)),
+			Body:        strings.NewReader(string(lock)),
+			IfNoneMatch: aws.String("*"),
+		})
+		if err == nil {
+			return s.unlocker(key, out.ETag), nil
+		}
+		if aerr, ok := err.(awserr.RequestFailure); !ok || aerr.StatusCode() != 412 {
+			return nil, err
+		}
+
+		data, etag, err := s.get(key)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			continue
+		}
+		if !lockExpired(data) {
+			return nil, ErrLocked
+		}
+		// replace the expired lock only if no other run has done so first
+		out, err = s.svc.PutObject(&s3.PutObjectInput{
+			Bucket:  aws.String(s.bucket),
+			Key:     aws.String(s.key(key)),
+			Body:    strings.NewReader(string(lock)),
+			IfMatch: etag,
+		})
+		if err == nil {
+			return s.unlocker(key, out.ETag), nil
+		}
+		if conditionFailed(err) {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	return nil, ErrLocked
+}
+
+// delete the lock only while it is still the one put
+func (s *s3StateStore) unlocker(key string, etag *string) func() error {
+	return func() error {
+		_, err := s.svc.DeleteObject(&s3.DeleteObjectInput{
+			Bucket:  aws.String(s.bucket),
+			Key:     aws.String(s.key(key)),
+			IfMatch: etag,
+		})
+		if conditionFailed(err) {
+			return errLockLost
+		}
+		return err
+	}
+}
+
+// items of a DynamoDB table with a string partition key named "key"
+type dynamoStateStore struct {
+	svc   *dynamodb.DynamoDB
+	table string
+}
+
+func (d *dynamoStateStore) Get(key string) ([]byte, error) {
+	out, err := d.svc.GetItem(&dynamodb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            map[string]*dynamodb.AttributeValue{"key": {S: aws.String(key)}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil || out.Item["data"] == nil {
+		return nil, nil
+	}
+	return out.Item["data"].B, nil
+}
+
+func (d *dynamoStateStore) Put(key string, data []byte) error {
+	_, err := d.svc.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"key":  {S: aws.String(key)},
+			"data": {B: data},
+		},
+	})
+	return err
+}
+
+func (d *dynamoStateStore) Delete(key string) error {
+	_, err := d.svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(d.table),
+		Key:       map[string]*dynamodb.AttributeValue{"key": {S: aws.String(key)}},
+	})
+	return err
+}
+
+func (d *dynamoStateStore) Lock(key string, ttl time.Duration) (func() error, error) {
+	now := time.Now()
+	lock := newLockInfo(ttl)
+	_, err := d.svc.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"key":     {S: aws.String(key)},
+			"data":    {B: lock},
+			"expires": {N: aws.String(strconv.FormatInt(now.Add(ttl).Unix(), 10))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#k) OR #e < :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#k": aws.String("key"),
+			"#e": aws.String("expires"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+	// delete the lock only while it is still the one put
+	return func() error {
+		_, err := d.svc.DeleteItem(&dynamodb.DeleteItemInput{
+			TableName:                 aws.String(d.table),
+			Key:                       map[string]*dynamodb.AttributeValue{"key": {S: aws.String(key)}},
+			ConditionExpression:       aws.String("#d = :d"),
+			ExpressionAttributeNames:  map[string]*string{"#d": aws.String("data")},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":d": {B: lock}},
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return errLockLost
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// letters, digits, '.', '_' and '-', to fit the object metadata and a
+// command line: pre-migration, fy2024-close
+var snapshotTagRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+func validateSnapshotTags() error {
+	for _, tag := range config.snapshotTags {
+		if !snapshotTagRegexp.MatchString(tag) {
+			return fmt.Errorf(T("invalid snapshot tag: %s (letters, digits, '.', '_' and '-', up to 64)"), tag)
+		}
+	}
+	return nil
+}
+
+func (r *Run) hasTag(tag string) bool {
+	for _, t := range r.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// the run of the id, or the newest succeeded run tagged so
+func findRun(runs []*Run, idOrTag string) *Run {
+	for _, r := range runs {
+		if r.Id == idOrTag {
+			return r
+		}
+	}
+	for _, r := range runs {
+		if r.Status == RUN_SUCCEEDED && r.hasTag(idOrTag) {
+			return r
+		}
+	}
+	return nil
+}
+
+func taggedRuns(runs []*Run, tag string) []*Run {
+	tagged := make([]*Run, 0)
+	for _, r := range runs {
+		if r.hasTag(tag) {
+			tagged = append(tagged, r)
+		}
+	}
+	return tagged
+}
+
+// write the object of a run to out, decrypting an -envelope-kms-key-id one
+func downloadRun(svc *s3.S3, r *Run, out io.Writer) error {
+	bucket, key, err := parseS3URL(r.Destination)
+	if err != nil || key == "" || strings.HasSuffix(r.Destination, "/") {
+		return fmt.Errorf(T("run %s wrote more than one object, see %s"), r.Id, r.Destination)
+	}
+
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	for name := range head.Metadata {
+		if strings.EqualFold(name, META_ENVELOPE_ALGORITHM) {
+			if envelopeKMS, err = newKMSClient(); err != nil {
+				return err
+			}
+			config.bucketName = bucket
+			return decryptObject(svc, key, out)
+		}
+	}
+
+	obj, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	defer obj.Body.Close()
+	_, err = io.Copy(out, obj.Body)
+	return err
+}
+
+func downloadCommand(svc *s3.S3, runs []*Run, idOrTag string) error {
+	r := findRun(runs, idOrTag)
+	if r == nil {
+		return fmt.Errorf(T("run not found: %s"), idOrTag)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	if err := downloadRun(svc, r, w); err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// one clock for every value expanded in the run, so a query and a key
+// expanded a moment apart still agree on the date
+var templateTime = time.Now()
+
+var templateFuncs = template.FuncMap{
+	"env":    os.Getenv,
+	"secret": lookupSecret,
+	"date": func(layout string) string {
+		return templateTime.Format(layout)
+	},
+	"utcdate": func(layout string) string {
+		return templateTime.UTC().Format(layout)
+	},
+}
+
+// expand {{ env "STAGE" }}, {{ date "2006-01" }} and the like in an option value
+func expandTemplate(s string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	t, err := template.New("").Funcs(templateFuncs).Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, nil); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+var keyPlaceholderRegexp = regexp.MustCompile(`\{[A-Za-z]+\}`)
+
+// expand exports/{appId}/{date}/{timestamp}.{ext} in the object key
+func expandKey(key string) (string, error) {
+	key = strings.NewReplacer(
+		"{appId}", strconv.FormatUint(config.appId, 10),
+		"{domain}", config.domain,
+		"{date}", templateTime.Format("2006-01-02"),
+		"{timestamp}", templateTime.UTC().Format(time.RFC3339),
+		"{ext}", formatExt(),
+	).Replace(key)
+	if p := keyPlaceholderRegexp.FindString(key); p != "" {
+		return "", fmt.Errorf(T("unknown placeholder in the object key: %s"), p)
+	}
+	return key, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// time and volume of one stage of the pipeline
+type StageStats struct {
+	Seconds          float64 `json:"seconds"`
+	Records          uint64  `json:"records,omitempty"`
+	Bytes            int64   `json:"bytes,omitempty"`
+	RecordsPerSecond float64 `json:"records_per_second,omitempty"`
+	BytesPerSecond   float64 `json:"bytes_per_second,omitempty"`
+}
+
+// the serialize time includes the downloads of the attachments in the rows
+const (
+	STAGE_FETCH     = "fetch"
+	STAGE_SERIALIZE = "serialize"
+	STAGE_DOWNLOAD  = "download"
+	STAGE_UPLOAD    = "upload"
+)
+
+var stageOrder = []string{STAGE_FETCH, STAGE_SERIALIZE, STAGE_DOWNLOAD, STAGE_UPLOAD}
+
+// the fetch stage runs in its own goroutine
+var stagesMu sync.Mutex
+
+func addStage(name string, d time.Duration, records uint64, bytes int64) {
+	stagesMu.Lock()
+	defer stagesMu.Unlock()
+	if run.Stages == nil {
+		run.Stages = make(map[string]*StageStats)
+	}
+	s := run.Stages[name]
+	if s == nil {
+		s = &StageStats{}
+		run.Stages[name] = s
+	}
+	s.Seconds += d.Seconds()
+	s.Records += records
+	s.Bytes += bytes
+}
+
+func finishStages() {
+	stagesMu.Lock()
+	defer stagesMu.Unlock()
+	for _, s := range run.Stages {
+		if s.Seconds > 0 {
+			s.RecordsPerSecond = float64(s.Records) / s.Seconds
+			s.BytesPerSecond = float64(s.Bytes) / s.Seconds
+		}
+	}
+}
+
+func logStages() {
+	for _, name := range stageOrder {
+		s := run.Stages[name]
+		if s == nil {
+			continue
+		}
+		if s.Records == 0 {
+			log.Printf(T("%s: %.1f MB in %.1fs (%.2f MB/s)"),
+				T(name), float64(s.Bytes)/1e6, s.Seconds, s.BytesPerSecond/1e6)
+			continue
+		}
+		log.Printf(T("%s: %d records, %.1f MB in %.1fs (%.0f records/s, %.2f MB/s)"),
+			T(name), s.Records, float64(s.Bytes)/1e6, s.Seconds, s.RecordsPerSecond, s.BytesPerSecond/1e6)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	httpClientOnce sync.Once
+	httpClient     *http.Client
+)
+
+// one client for kintone and AWS, so that record fetches and attachment
+// downloads reuse connections instead of paying a TLS handshake each
+func sharedHTTPClient() *http.Client {
+	httpClientOnce.Do(func() {
+		transport := &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          config.maxIdleConns,
+			MaxIdleConnsPerHost:   config.maxIdleConnsPerHost,
+			MaxConnsPerHost:       config.maxConnsPerHost,
+			IdleConnTimeout:       config.idleConnTimeout,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		}
+		httpClient = &http.Client{Transport: transport}
+	})
+	return httpClient
+}
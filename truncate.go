@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/kintone/go-kintone"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// a full value cut short by -truncate-text, one JSON line of the overflow
+// object each
+type overflowValue struct {
+	Id       uint64 `json:"id"`
+	Revision int64  `json:"revision"`
+	Field    string `json:"field"`
+	Table    string `json:"table,omitempty"`
+	Row      uint64 `json:"row,omitempty"`
+	Value    string `json:"value"`
+}
+
+// the overflow values of the run, uploaded once the export is done
+var overflow struct {
+	sync.Mutex
+	buf *spillBuffer
+}
+
+func validateTruncateOptions() error {
+	if config.truncateText == 0 {
+		return nil
+	}
+	if config.truncateText < 0 {
+		return errors.New(T("-truncate-text must be positive"))
+	}
+	if chunked() || config.appendMode || config.format == "arrow" {
+		// a run writes one overflow object next to config.key
+		return errors.New(T("-truncate-text cannot be combined with -chunk-records, -chunk-time, -append or -o arrow"))
+	}
+	return nil
+}
+
+// <key without extension>.overflow.jsonl
+func overflowKey(key string) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + ".overflow.jsonl"
+}
+
+// cut a text value longer than -truncate-text characters, keeping the
+// whole of it for the overflow object
+func truncateValue(value string) (string, bool) {
+	if utf8.RuneCountInString(value) <= config.truncateText {
+		return value, false
+	}
+	n := 0
+	for i := range value {
+		if n == config.truncateText {
+			return value[:i], true
+		}
+		n++
+	}
+	return value, false
+}
+
+func truncateField(field interface{}) (interface{}, string, bool) {
+	switch v := field.(type) {
+	case kintone.SingleLineTextField:
+		if s, cut := truncateValue(string(v)); cut {
+			return kintone.SingleLineTextField(s), string(v), true
+		}
+	case kintone.MultiLineTextField:
+		if s, cut := truncateValue(string(v)); cut {
+			return kintone.MultiLineTextField(s), string(v), true
+		}
+	case kintone.RichTextField:
+		if s, cut := truncateValue(string(v)); cut {
+			return kintone.RichTextField(s), string(v), true
+		}
+	}
+	return field, "", false
+}
+
+func addOverflow(v *overflowValue) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	overflow.Lock()
+	defer overflow.Unlock()
+	if overflow.buf == nil {
+		overflow.buf = newSpillBuffer(bufferMemory() / 4)
+	}
+	if _, err := overflow.buf.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	atomic.AddUint64(&run.Truncated, 1)
+	return nil
+}
+
+// truncate the long text values of a record in place, before it is
+// serialized
+func truncateRecord(record *kintone.Record) error {
+	if config.truncateText == 0 {
+		return nil
+	}
+	for code, field := range record.Fields {
+		if table, ok := field.(kintone.SubTableField); ok {
+			for _, row := range table {
+				for subCode, subField := range row.Fields {
+					short, full, cut := truncateField(subField)
+					if !cut {
+						continue
+					}
+					row.Fields[subCode] = short
+					err := addOverflow(&overflowValue{Id: record.Id(), Revision: record.Revision(), Field: subCode, Table: code, Row: row.Id(), Value: full})
+					if err != nil {
+						return err
+					}
+				}
+			}
+			continue
+		}
+		short, full, cut := truncateField(field)
+		if !cut {
+			continue
+		}
+		record.Fields[code] = short
+		err := addOverflow(&overflowValue{Id: record.Id(), Revision: record.Revision(), Field: code, Value: full})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// put the full values next to the export, if any were cut
+func uploadOverflow(svc *s3.S3, key string) error {
+	overflow.Lock()
+	defer overflow.Unlock()
+	if overflow.buf == nil {
+		return nil
+	}
+	defer func() {
+		overflow.buf.Close()
+		overflow.buf = nil
+	}()
+
+	body, err := overflow.buf.Reader()
+	if err != nil {
+		return err
+	}
+	upload := newUploadPipeline(svc, overflowKey(key))
+	upload.contentType = "application/x-ndjson; charset=utf-8"
+	// the values are as sensitive as the export
+	var out io.Writer = upload
+	env, err := encryptUpload(upload)
+	if err != nil {
+		return err
+	}
+	if env != nil {
+		out = env
+	}
+	buf := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(buf)
+	if _, err := io.CopyBuffer(out, body, *buf); err != nil {
+		upload.Abort()
+		return err
+	}
+	if env != nil {
+		if err := env.Close(); err != nil {
+			upload.Abort()
+			return err
+		}
+	}
+	if err := upload.Close(); err != nil {
+		return uploadError(overflowKey(key), err)
+	}
+	return nil
+}
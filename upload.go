@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"hash"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// smallest part S3 accepts, except for the last one
+const MIN_PART_SIZE = 5 << 20
+
+// the most parts a multipart upload can have
+const MAX_PARTS = 10000
+
+var errUploadAborted = errors.New("upload aborted")
+
+// uploads the output while it is being written: every full part is queued
+// to an uploader goroutine, so uploading overlaps with fetching and
+// serializing. an output smaller than one part is sent with PutObject.
+type uploadPipeline struct {
+	svc      *s3.S3
+	key      string
+	partSize int
+	buf      *bytes.Buffer
+	size     int64
+	hash     hash.Hash
+	// provenance of the object, or nil for none
+	metadata func(complete bool) map[string]*string
+	// -if-not-exists: write only if the key is still free
+	ifNoneMatch bool
+	// the Content-Type if not the export's
+	contentType string
+
+	uploadId *string
+	parts    chan *uploadPart
+	closed   bool
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	done     []*s3.CompletedPart
+	err      error
+}
+
+type uploadPart struct {
+	number int64
+	data   []byte
+}
+
+// the base64 SHA-256 S3 checks the body against
+func checksumSHA256(data []byte) *string {
+	sum := sha256.Sum256(data)
+	return aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// S3 rejects a body not matching the checksum sent; seeing the same one in
+// the response also proves it was stored with the object
+func verifyChecksum(sent, received *string) error {
+	if sent == nil || aws.StringValue(sent) == aws.StringValue(received) {
+		return nil
+	}
+	return fmt.Errorf(T("checksum mismatch: sent %s, S3 stored %s"), aws.StringValue(sent), aws.StringValue(received))
+}
+
+// put key.sha256 in the format of sha256sum, so a download can be checked
+// with "sha256sum -c"
+func putChecksumSidecar(svc *s3.S3, key string, sum []byte) error {
+	line := hex.EncodeToString(sum) + "  " + path.Base(key) + "\n"
+	input := newPutObjectInput(key+".sha256", strings.NewReader(line))
+	input.ContentType = aws.String("text/plain; charset=utf-8")
+	_, err := svc.PutObject(input)
+	return err
+}
+
+// -part-size, but no less than S3 takes
+func uploadPartSize() int {
+	if config.partSize < MIN_PART_SIZE {
+		return MIN_PART_SIZE
+	}
+	return int(config.partSize)
+}
+
+func newUploadPipeline(svc *s3.S3, key string) *uploadPipeline {
+	partSize := uploadPartSize()
+	return &uploadPipeline{
+		svc:      svc,
+		key:      key,
+		partSize: partSize,
+		buf:      bytes.NewBuffer(make([]byte, 0, partSize)),
+		hash:     sha256.New(),
+	}
+}
+
+func (u *uploadPipeline) Write(p []byte) (int, error) {
+	if err := u.failed(); err != nil {
+		return 0, err
+	}
+
+	u.hash.Write(p)
+	n := len(p)
+	for len(p) > 0 {
+		room := u.partSize - u.buf.Len()
+		if room > len(p) {
+			room = len(p)
+		}
+		u.buf.Write(p[:room])
+		p = p[room:]
+
+		if u.buf.Len() == u.partSize {
+			if err := u.flushPart(); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	u.size += int64(n)
+	return n, nil
+}
+
+func (u *uploadPipeline) objectContentType() string {
+	if u.contentType != "" && config.envelopeKmsKeyId == "" {
+		return u.contentType
+	}
+	return exportContentType()
+}
+
+// the Content-Encoding of the export; an object of its own type is not compressed
+func (u *uploadPipeline) objectContentEncoding() *string {
+	if u.contentType != "" {
+		return nil
+	}
+	return exportContentEncoding()
+}
+
+func (u *uploadPipeline) Len() int64 {
+	return u.size
+}
+
+// SHA-256 of everything written
+func (u *uploadPipeline) Sum() []byte {
+	return u.hash.Sum(nil)
+}
+
+func (u *uploadPipeline) failed() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.err
+}
+
+func (u *uploadPipeline) fail(err error) {
+	u.mu.Lock()
+	if u.err == nil {
+		u.err = err
+	}
+	u.mu.Unlock()
+}
+
+// hand the buffered part to the uploader, starting the upload on the first part
+func (u *uploadPipeline) flushPart() error {
+	if len(u.done) == MAX_PARTS {
+		return fmt.Errorf(T("s3://%s/%s needs more than %d parts of %d bytes; raise -part-size"), config.bucketName, u.key, MAX_PARTS, u.partSize)
+	}
+	if u.uploadId == nil {
+		if err := u.start(); err != nil {
+			return err
+		}
+	}
+
+	part := &uploadPart{number: int64(len(u.done)) + 1, data: u.buf.Bytes()}
+	u.mu.Lock()
+	u.done = append(u.done, nil)
+	u.mu.Unlock()
+
+	// blocks while the queue is full, which bounds the memory held by parts
+	u.parts <- part
+	u.buf = bytes.NewBuffer(make([]byte, 0, u.partSize))
+	return nil
+}
+
+func (u *uploadPipeline) start() error {
+	input := newCreateMultipartUploadInput(u.key)
+	input.ContentType = aws.String(u.objectContentType())
+	input.ContentEncoding = u.objectContentEncoding()
+	if config.s3Checksum {
+		input.ChecksumAlgorithm = aws.String(s3.ChecksumAlgorithmSha256)
+	}
+	if u.metadata != nil {
+		input.Metadata = u.metadata(false)
+	}
+	out, err := u.svc.CreateMultipartUpload(input)
+	if err != nil {
+		return err
+	}
+	u.uploadId = out.UploadId
+
+	queue := config.uploadQueue
+	if queue < 1 {
+		queue = 1
+	}
+	u.parts = make(chan *uploadPart, queue)
+	u.wg.Add(1)
+	go u.upload()
+	return nil
+}
+
+func (u *uploadPipeline) upload() {
+	defer u.wg.Done()
+	for part := range u.parts {
+		// keep draining the queue after a failure so the writer never blocks
+		if u.failed() != nil {
+			continue
+		}
+
+		input := &s3.UploadPartInput{
+			Bucket:     aws.String(config.bucketName),
+			Key:        aws.String(u.key),
+			UploadId:   u.uploadId,
+			PartNumber: aws.Int64(part.number),
+			Body:       bytes.NewReader(part.data),
+		}
+		if config.bucketOwner != "" {
+			input.ExpectedBucketOwner = aws.String(config.bucketOwner)
+		}
+		if config.s3Checksum {
+			input.ChecksumSHA256 = checksumSHA256(part.data)
+		}
+
+		started := time.Now()
+		out, err := u.svc.UploadPart(input)
+		if err == nil {
+			err = verifyChecksum(input.ChecksumSHA256, out.ChecksumSHA256)
+		}
+		if err != nil {
+			u.fail(err)
+			continue
+		}
+		addStage(STAGE_UPLOAD, time.Since(started), 0, int64(len(part.data)))
+
+		u.mu.Lock()
+		u.done[part.number-1] = &s3.CompletedPart{ETag: out.ETag, PartNumber: aws.Int64(part.number), ChecksumSHA256: out.ChecksumSHA256}
+		u.mu.Unlock()
+	}
+}
+
+// upload the rest and complete the object
+func (u *uploadPipeline) Close() error {
+	if u.uploadId == nil {
+		started := time.Now()
+		input := newPutObjectInput(u.key, bytes.NewReader(u.buf.Bytes()))
+		input.ContentType = aws.String(u.objectContentType())
+		input.ContentEncoding = u.objectContentEncoding()
+		if u.metadata != nil {
+			input.Metadata = u.metadata(true)
+		}
+		if config.s3Checksum {
+			input.ChecksumSHA256 = checksumSHA256(u.buf.Bytes())
+		}
+		if u.ifNoneMatch {
+			input.IfNoneMatch = aws.String("*")
+		}
+		out, err := u.svc.PutObject(input)
+		if u.ifNoneMatch {
+			err = keyTakenError(err)
+		}
+		if err == nil {
+			err = verifyChecksum(input.ChecksumSHA256, out.ChecksumSHA256)
+		}
+		if err == nil {
+			addStage(STAGE_UPLOAD, time.Since(started), 0, int64(u.buf.Len()))
+		}
+		return err
+	}
+
+	if u.buf.Len() > 0 {
+		if err := u.flushPart(); err != nil {
+			u.Abort()
+			return err
+		}
+	}
+	u.closed = true
+	close(u.parts)
+	u.wg.Wait()
+
+	if err := u.failed(); err != nil {
+		u.abortUpload()
+		return err
+	}
+
+	complete := &s3.CompleteMultipartUploadInput{
+		Bucket:              aws.String(config.bucketName),
+		Key:                 aws.String(u.key),
+		UploadId:            u.uploadId,
+		ExpectedBucketOwner: newPutObjectInput(u.key, nil).ExpectedBucketOwner,
+		MultipartUpload:     &s3.CompletedMultipartUpload{Parts: u.done},
+	}
+	if u.ifNoneMatch {
+		complete.IfNoneMatch = aws.String("*")
+	}
+	_, err := u.svc.CompleteMultipartUpload(complete)
+	if u.ifNoneMatch {
+		err = keyTakenError(err)
+	}
+	if err != nil {
+		u.abortUpload()
+	}
+	return err
+}
+
+// give up the upload after a failed export, so no parts are left behind
+func (u *uploadPipeline) Abort() {
+	if u.uploadId == nil || u.closed {
+		return
+	}
+	u.fail(errUploadAborted)
+	u.closed = true
+	close(u.parts)
+	u.wg.Wait()
+	u.abortUpload()
+}
+
+func (u *uploadPipeline) abortUpload() {
+	_, err := u.svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:              aws.String(config.bucketName),
+		Key:                 aws.String(u.key),
+		UploadId:            u.uploadId,
+		ExpectedBucketOwner: newPutObjectInput(u.key, nil).ExpectedBucketOwner,
+	})
+	if err != nil {
+		log.Printf(T("could not abort upload of s3://%s/%s: %v"), config.bucketName, u.key, err)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kintone/go-kintone"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// the windows of the backfill command
+const (
+	WINDOW_DAY   = "day"
+	WINDOW_MONTH = "month"
+)
+
+// the records of [start, end) of the date field, exported into the
+// partition or key of start
+type backfillWindow struct {
+	start time.Time
+	end   time.Time
+}
+
+// the checkpoint of a window exported, in the -state store
+type backfillCheckpoint struct {
+	RunId      string    `json:"run_id"`
+	Key        string    `json:"key"`
+	Records    uint64    `json:"records"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+func backfillWindows(from, to time.Time, window string) []backfillWindow {
+	windows := make([]backfillWindow, 0)
+	for start := from; !start.After(to); {
+		var end time.Time
+		if window == WINDOW_MONTH {
+			end = time.Date(start.Year(), start.Month()+1, 1, 0, 0, 0, 0, start.Location())
+		} else {
+			end = start.AddDate(0, 0, 1)
+		}
+		windows = append(windows, backfillWindow{start: start, end: end})
+		start = end
+	}
+	return windows
+}
+
+func backfillCheckpointKey(field string, w backfillWindow) string {
+	return fmt.Sprintf("backfill/%s/%d/%s/%s", config.domain, config.appId, url.PathEscape(field), w.start.Format("2006-01-02"))
+}
+
+// the condition selecting the window, by the date or the date and time
+func windowCondition(field *kintone.FieldInfo, w backfillWindow) (string, error) {
+	layout := ""
+	switch field.Type {
+	case kintone.FT_DATE:
+		layout = "2006-01-02"
+	case kintone.FT_DATETIME, kintone.FT_CTIME, kintone.FT_MTIME:
+		layout = time.RFC3339
+	default:
+		return "", fmt.Errorf(T("%s is not a date or date and time field"), field.Code)
+	}
+	return fmt.Sprintf("%s >= %s and %s < %s", field.Code, quoteQueryValue(w.start.Format(layout)), field.Code, quoteQueryValue(w.end.Format(layout))), nil
+}
+
+// the key of the window, as the key template expands on its first day
+func windowKey(w backfillWindow) (string, error) {
+	templateTime = w.start
+	key, err := expandKey(config.keyTemplate)
+	if err != nil {
+		return "", err
+	}
+	config.key = strings.TrimPrefix(key, "/")
+	if partitionColumns != nil && config.splitBy == "" {
+		config.key = partitionKey("")
+	}
+	return config.key, nil
+}
+
+// backfill <date field> <from> <to>: export the records day by day, or
+// month by month with -window month, each window into the key or the dt
+// partition of its first day. a window exported is checkpointed in -state
+// and skipped when the command runs again, so failed windows are retried.
+func backfillCommand(app *kintone.App, args []string) error {
+	if len(args) != 3 {
+		return errors.New(T("usage: backfill <date field> <from> <to>"))
+	}
+	if config.state == "" {
+		return errors.New(T("backfill needs -state for its checkpoints"))
+	}
+	if config.window != WINDOW_DAY && config.window != WINDOW_MONTH {
+		return fmt.Errorf(T("-window must be 'day' or 'month': %s"), config.window)
+	}
+	from, err := time.ParseInLocation("2006-01-02", args[1], time.Local)
+	if err != nil {
+		return err
+	}
+	to, err := time.ParseInLocation("2006-01-02", args[2], time.Local)
+	if err != nil {
+		return err
+	}
+	if to.Before(from) {
+		return fmt.Errorf(T("%s is before %s"), args[2], args[1])
+	}
+	if config.window == WINDOW_MONTH {
+		from = time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.Local)
+	}
+
+	fields, err := getFields(app)
+	if err != nil {
+		return err
+	}
+	field, ok := fields[args[0]]
+	if !ok {
+		return fmt.Errorf(T("no field %s in the app"), args[0])
+	}
+
+	// each window needs an object or partition of its own; -append puts
+	// the parts under the dt partition of the window
+	windows := backfillWindows(from, to, config.window)
+	keys := make(map[string]bool, len(windows))
+	for _, w := range windows {
+		key, err := windowKey(w)
+		if err != nil {
+			return err
+		}
+		if keys[key] && !config.appendMode {
+			return fmt.Errorf(T("the windows would overwrite %s; put {date} into -k or use -partition-by dt or -append"), key)
+		}
+		keys[key] = true
+	}
+
+	store, err := newStateStore(config.state)
+	if err != nil {
+		return err
+	}
+	query := config.query
+	failed := 0
+	for _, w := range windows {
+		if err := interruption(); err != nil {
+			return err
+		}
+		checkpoint := backfillCheckpointKey(field.Code, w)
+		data, err := store.Get(checkpoint)
+		if err != nil {
+			return err
+		}
+		if data != nil {
+			log.Printf(T("%s is exported already, skipped"), w.start.Format("2006-01-02"))
+			continue
+		}
+
+		cond, err := windowCondition(field, w)
+		if err != nil {
+			return err
+		}
+		config.query = andQuery(query, cond)
+		key, err := windowKey(w)
+		if err != nil {
+			return err
+		}
+		// the objects of the window only, for its manifest and replicas
+		manifestObjects.list = nil
+		written = &writtenObjects{seen: make(map[string]bool)}
+
+		log.Printf(T("backfilling %s into s3://%s/%s"), w.start.Format("2006-01-02"), config.bucketName, key)
+		err = export(app)
+		if err == nil && run.Status == RUN_FAILED {
+			err = errors.New(run.Error)
+		}
+		if err != nil {
+			log.Printf(T("%s failed: %v"), w.start.Format("2006-01-02"), err)
+			failed++
+			continue
+		}
+		data, _ = json.Marshal(&backfillCheckpoint{
+			RunId:      run.Id,
+			Key:        key,
+			Records:    run.Records,
+			FinishedAt: run.FinishedAt,
+		})
+		if err := store.Put(checkpoint, data); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf(T("%d of %d windows failed; run backfill again to retry them"), failed, len(windows))
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// scratch directory of the current run; attachments are staged here and
+// only moved into the -b directory once the run succeeded
+var workdir string
+
+// closed on SIGINT or SIGTERM; the error of the export then
+var (
+	interrupted    = make(chan struct{})
+	interruptErr   error
+	interruptsOnce sync.Once
+)
+
+// fail the export at its next page on the first signal, so the lock, the
+// multipart upload and the work directory are cleaned up as on any error.
+// a second signal exits at once.
+func handleInterrupts() {
+	interruptsOnce.Do(func() {
+		signals := make(chan os.Signal, 2)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			sig := <-signals
+			interruptErr = fmt.Errorf(T("interrupted by %v"), sig)
+			close(interrupted)
+			sig = <-signals
+			removeWorkdir()
+			log.Fatalf(T("interrupted by %v"), sig)
+		}()
+	})
+}
+
+// the error of the export once interrupted, else nil
+func interruption() error {
+	select {
+	case <-interrupted:
+		return interruptErr
+	default:
+		return nil
+	}
+}
+
+func createWorkdir() error {
+	dir, err := ioutil.TempDir(config.workdirBase, "kintone-to-s3-"+run.Id+"-")
+	if err != nil {
+		return err
+	}
+	workdir = dir
+	handleInterrupts()
+	return nil
+}
+
+func removeWorkdir() {
+	if workdir == "" {
+		return
+	}
+	if config.keepWorkdir {
+		log.Printf(T("keeping work directory %s"), workdir)
+		return
+	}
+	if err := os.RemoveAll(workdir); err != nil {
+		log.Printf(T("could not remove work directory %s: %v"), workdir, err)
+	}
+}
+
+func attachmentStagingDir() string {
+	return filepath.Join(workdir, "attachments")
+}
+
+// move the staged attachment directories into the -b directory,
+// replacing those left by an earlier run of the same records, and list
+// the failed ones for retry-failures
+func publishAttachments() error {
+	if err := saveAttachmentFailures(); err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(attachmentStagingDir())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(config.fileDir, 0777); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		src := filepath.Join(attachmentStagingDir(), entry.Name())
+		dst := filepath.Join(config.fileDir, entry.Name())
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+		if err := os.Rename(src, dst); err != nil {
+			// the work directory may be on another device
+			if err := copyTree(src, dst); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0777)
+		}
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}